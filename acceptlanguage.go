@@ -0,0 +1,77 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// parseAcceptLanguage returns the languages of an Accept-Language header,
+// most preferred first. Wildcards and languages with q=0 are left out.
+func parseAcceptLanguage(header string) []string {
+	type weighted struct {
+		lang string
+		q    float64
+	}
+
+	var langs []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		lang := strings.TrimSpace(fields[0])
+		if lang == "" || lang == "*" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			v, err := strconv.ParseFloat(param[len("q="):], 64)
+			if err != nil {
+				v = 0
+			}
+			q = v
+		}
+		if q <= 0 {
+			continue
+		}
+		langs = append(langs, weighted{lang, q})
+	}
+
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+	result := make([]string, len(langs))
+	for i, l := range langs {
+		result[i] = l.lang
+	}
+	return result
+}
+
+// acceptLanguage returns the most preferred lang of the Accept-Language
+// header of req that product is available in, as spelled in the catalog. If
+// the string is == "", the product is in none of them.
+func (b *BouncerHandler) acceptLanguage(req *http.Request, product string) (string, error) {
+	langs := parseAcceptLanguage(req.Header.Get("Accept-Language"))
+	if len(langs) == 0 {
+		return "", nil
+	}
+
+	product, err := b.catalogProduct(product)
+	if err != nil {
+		return "", err
+	}
+	for _, lang := range langs {
+		_, _, language, err := b.productForLanguage(product, lang)
+		switch {
+		case err == sql.ErrNoRows:
+			continue
+		case err != nil:
+			return "", err
+		}
+		return language, nil
+	}
+	return "", nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		Header   string
+		Expected []string
+	}{
+		{"fr-CA, fr;q=0.9, en;q=0.8, *;q=0.5", []string{"fr-CA", "fr", "en"}},
+		{"en;q=0.5, de", []string{"de", "en"}},
+		{"de;q=0, en-GB", []string{"en-GB"}},
+		{"de;q=bogus, en-GB;q=0.1", []string{"en-GB"}},
+		{"", []string{}},
+		{" , ;q=1", []string{}},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.Expected, parseAcceptLanguage(test.Header), "header: %q", test.Header)
+	}
+}
+
+func TestBouncerHandlerAcceptLanguage(t *testing.T) {
+	const enGBLocation = "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-GB/Firefox%2039.0.dmg"
+	const enUSLocation = "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"
+
+	tests := []struct {
+		EnableAcceptLanguage bool
+		URL                  string
+		AcceptLanguage       string
+		ExpectedLocation     string
+	}{
+		{true, "http://test/?product=firefox-latest&os=osx", "fr, en-GB;q=0.8, en-US;q=0.5", enGBLocation},
+		{true, "http://test/?product=firefox-latest&os=osx", "fr, en;q=0.8", enGBLocation},
+		{true, "http://test/?product=firefox-latest&os=osx", "fr, de", enUSLocation},
+		{true, "http://test/?product=firefox-latest&os=osx", "", enUSLocation},
+		{true, "http://test/?product=firefox-latest&os=osx&lang=en-US", "en-GB", enUSLocation},
+		{false, "http://test/?product=firefox-latest&os=osx", "en-GB", enUSLocation},
+	}
+
+	for _, test := range tests {
+		handler := &BouncerHandler{
+			db:                   bouncerHandler.db,
+			EnableAcceptLanguage: test.EnableAcceptLanguage,
+		}
+
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", test.URL, nil)
+		assert.NoError(t, err)
+		req.Header.Set("Accept-Language", test.AcceptLanguage)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, 302, w.Code, "test: %+v", test)
+		assert.Equal(t, test.ExpectedLocation, w.HeaderMap.Get("Location"), "test: %+v", test)
+
+		negotiated := test.EnableAcceptLanguage && test.URL == "http://test/?product=firefox-latest&os=osx"
+		assert.Equal(t, negotiated, w.HeaderMap.Get("Vary") == "Accept-Language", "test: %+v", test)
+	}
+}
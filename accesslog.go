@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mozilla-services/go-bouncer/mozlog"
+)
+
+const (
+	AccessLogFormatJSON = "json"
+	AccessLogFormatCLF  = "clf"
+)
+
+// clfTimeFormat is the timestamp layout used by the Combined Log Format
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// AccessLogger writes a line for every request served by the bouncer handler
+type AccessLogger struct {
+	// Format is either AccessLogFormatJSON or AccessLogFormatCLF
+	Format string
+	Output io.Writer
+
+	// LogFullURL logs the complete resolved url. If false, only its path
+	// is logged.
+	LogFullURL bool
+
+	// LogSampleRate is the fraction, between 0 and 1, of successful
+	// requests that are logged. Errors and not founds are always logged.
+	// Zero, the default, logs every request.
+	LogSampleRate float64
+}
+
+// accessLogWriter records the response details needed for the access log
+type accessLogWriter struct {
+	http.ResponseWriter
+
+	status      int
+	size        int
+	resolvedURL string
+	// experiments are the Experiments enabled for the request
+	experiments []string
+}
+
+func (a *accessLogWriter) WriteHeader(status int) {
+	a.status = status
+	a.ResponseWriter.WriteHeader(status)
+}
+
+func (a *accessLogWriter) Write(b []byte) (int, error) {
+	n, err := a.ResponseWriter.Write(b)
+	a.size += n
+	return n, err
+}
+
+// setResolvedURL records the url a request resolved to, if the request is
+// being access logged
+func setResolvedURL(w http.ResponseWriter, url string) {
+	if lw, ok := w.(*accessLogWriter); ok {
+		lw.resolvedURL = url
+	}
+}
+
+// Log writes the access log line for a finished request
+func (a *AccessLogger) Log(req *http.Request, lw *accessLogWriter, start time.Time) {
+	if !a.sampled(req, lw) {
+		return
+	}
+
+	var line []byte
+	switch a.Format {
+	case AccessLogFormatCLF:
+		line = []byte(a.clfLine(req, lw, start))
+	default:
+		var err error
+		line, err = a.jsonLine(req, lw, start)
+		if err != nil {
+			log.Printf("AccessLogger.Log err: %v", err)
+			return
+		}
+	}
+
+	_, err := a.Output.Write(append(line, '\n'))
+	if err != nil {
+		log.Printf("AccessLogger.Log err: %v", err)
+	}
+}
+
+// sampled reports whether a finished request is logged under LogSampleRate.
+// A request is hashed so the same request is always either logged or not.
+func (a *AccessLogger) sampled(req *http.Request, lw *accessLogWriter) bool {
+	if a.LogSampleRate <= 0 || a.LogSampleRate >= 1 || lw.status >= 400 {
+		return true
+	}
+
+	h := fnv.New64a()
+	for _, s := range []string{req.Method, req.URL.RequestURI(), remoteHost(req), req.UserAgent()} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return float64(h.Sum64())/(1<<64) < a.LogSampleRate
+}
+
+func (a *AccessLogger) jsonLine(req *http.Request, lw *accessLogWriter, start time.Time) ([]byte, error) {
+	appLog := mozlog.NewAppLog("Bouncer", nil)
+	appLog.Type = "request.summary"
+	appLog.Fields = map[string]interface{}{
+		"remote": remoteHost(req),
+		"method": req.Method,
+		"path":   req.URL.RequestURI(),
+		"code":   lw.status,
+		"size":   lw.size,
+		"agent":  req.UserAgent(),
+		"t":      int64(time.Since(start) / time.Millisecond),
+		"url":    a.loggedURL(lw.resolvedURL),
+	}
+	if len(lw.experiments) > 0 {
+		appLog.Fields["experiments"] = strings.Join(lw.experiments, ",")
+	}
+	return appLog.ToJSON()
+}
+
+// clfLine formats a request in the Combined Log Format, with the resolved url
+// appended as an extra quoted field
+func (a *AccessLogger) clfLine(req *http.Request, lw *accessLogWriter, start time.Time) string {
+	size := "-"
+	if lw.size > 0 {
+		size = fmt.Sprintf("%d", lw.size)
+	}
+
+	return fmt.Sprintf(`%s - - [%s] "%s %s %s" %d %s "%s" "%s" "%s"`,
+		clfField(remoteHost(req)),
+		start.Format(clfTimeFormat),
+		req.Method,
+		clfEscape(req.URL.RequestURI()),
+		req.Proto,
+		lw.status,
+		size,
+		clfField(req.Referer()),
+		clfField(req.UserAgent()),
+		clfField(a.loggedURL(lw.resolvedURL)),
+	)
+}
+
+// loggedURL returns the part of a resolved url that is written to the log
+func (a *AccessLogger) loggedURL(resolvedURL string) string {
+	if a.LogFullURL || resolvedURL == "" {
+		return resolvedURL
+	}
+
+	u, err := url.Parse(resolvedURL)
+	if err != nil {
+		return ""
+	}
+	return u.RequestURI()
+}
+
+// remoteHost returns the client address of a request without its port
+func remoteHost(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+func clfEscape(s string) string {
+	return strings.Replace(s, `"`, `\"`, -1)
+}
+
+func clfField(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return clfEscape(s)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mozilla-services/go-bouncer/mozlog"
+	"github.com/stretchr/testify/assert"
+)
+
+var clfLineRegex = regexp.MustCompile(`^(\S+) - - \[(\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4})\] "(\S+) (\S+) (\S+)" (\d{3}) (\d+|-) "([^"]*)" "([^"]*)" "([^"]*)"\n$`)
+
+func TestAccessLogCLF(t *testing.T) {
+	out := new(bytes.Buffer)
+	handler := &BouncerHandler{
+		db: bouncerHandler.db,
+		AccessLog: &AccessLogger{
+			Format:     AccessLogFormatCLF,
+			Output:     out,
+			LogFullURL: true,
+		},
+	}
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+	req.RemoteAddr = "192.0.2.1:54321"
+	req.Header.Set("User-Agent", "curl/7.64.1")
+
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 302, w.Code)
+
+	match := clfLineRegex.FindStringSubmatch(out.String())
+	if assert.NotNil(t, match, "line: %q", out.String()) {
+		assert.Equal(t, "192.0.2.1", match[1])
+		assert.Equal(t, "GET", match[3])
+		assert.Equal(t, "/?product=firefox-latest&os=osx&lang=en-US", match[4])
+		assert.Equal(t, "HTTP/1.1", match[5])
+		assert.Equal(t, "302", match[6])
+		assert.Equal(t, "-", match[8])
+		assert.Equal(t, "curl/7.64.1", match[9])
+		assert.Equal(t, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", match[10])
+	}
+}
+
+func TestAccessLogCLFNotFound(t *testing.T) {
+	out := new(bytes.Buffer)
+	handler := &BouncerHandler{
+		db: bouncerHandler.db,
+		AccessLog: &AccessLogger{
+			Format:     AccessLogFormatCLF,
+			Output:     out,
+			LogFullURL: true,
+		},
+	}
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", `http://test/?product=unknown"product&os=osx`, nil)
+	assert.NoError(t, err)
+
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+
+	match := clfLineRegex.FindStringSubmatch(out.String())
+	if assert.NotNil(t, match, "line: %q", out.String()) {
+		assert.Equal(t, "404", match[6])
+		assert.Equal(t, "-", match[10])
+	}
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	out := new(bytes.Buffer)
+	handler := &BouncerHandler{
+		db: bouncerHandler.db,
+		AccessLog: &AccessLogger{
+			Format:     AccessLogFormatJSON,
+			Output:     out,
+			LogFullURL: true,
+		},
+	}
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US&print=yes", nil)
+	assert.NoError(t, err)
+
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	var logEntry mozlog.AppLog
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &logEntry))
+	assert.Equal(t, "request.summary", logEntry.Type)
+	assert.Equal(t, float64(200), logEntry.Fields["code"])
+	assert.Equal(t, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", logEntry.Fields["url"])
+}
+
+func TestAccessLogPathOnly(t *testing.T) {
+	for _, format := range []string{AccessLogFormatCLF, AccessLogFormatJSON} {
+		for _, fullURL := range []bool{true, false} {
+			out := new(bytes.Buffer)
+			handler := &BouncerHandler{
+				db: bouncerHandler.db,
+				AccessLog: &AccessLogger{
+					Format:     format,
+					Output:     out,
+					LogFullURL: fullURL,
+				},
+			}
+
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US", nil)
+			assert.NoError(t, err)
+
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, 302, w.Code)
+
+			var logged string
+			if format == AccessLogFormatCLF {
+				match := clfLineRegex.FindStringSubmatch(out.String())
+				if !assert.NotNil(t, match, "line: %q", out.String()) {
+					continue
+				}
+				logged = match[10]
+			} else {
+				var logEntry mozlog.AppLog
+				assert.NoError(t, json.Unmarshal(out.Bytes(), &logEntry))
+				logged, _ = logEntry.Fields["url"].(string)
+			}
+
+			if fullURL {
+				assert.Equal(t, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", logged, "format: %v", format)
+			} else {
+				assert.Equal(t, "/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", logged, "format: %v", format)
+			}
+		}
+	}
+}
+
+func TestAccessLogSampleRateAlwaysLogsErrors(t *testing.T) {
+	out := new(bytes.Buffer)
+	handler := &BouncerHandler{
+		db: memoizedCatalog(),
+		AccessLog: &AccessLogger{
+			Format:        AccessLogFormatCLF,
+			Output:        out,
+			LogSampleRate: 0.000001,
+		},
+	}
+
+	for i := 0; i < 20; i++ {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://test/?product=bogus&os=osx&lang=en-US", nil)
+		assert.NoError(t, err)
+		req.RemoteAddr = fmt.Sprintf("192.0.2.%d:54321", i)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, 404, w.Code)
+	}
+	assert.Equal(t, 20, strings.Count(out.String(), "\n"))
+
+	out.Reset()
+	for _, status := range []int{400, 500, 503} {
+		req, err := http.NewRequest("GET", "http://test/?product=firefox-latest", nil)
+		assert.NoError(t, err)
+		handler.AccessLog.Log(req, &accessLogWriter{status: status}, time.Now())
+	}
+	assert.Equal(t, 3, strings.Count(out.String(), "\n"))
+}
+
+func TestAccessLogSampleRate(t *testing.T) {
+	const requests = 2000
+
+	for _, rate := range []float64{0.1, 0.5, 0.9} {
+		out := new(bytes.Buffer)
+		logger := &AccessLogger{
+			Format:        AccessLogFormatCLF,
+			Output:        out,
+			LogSampleRate: rate,
+		}
+
+		for i := 0; i < requests; i++ {
+			req, err := http.NewRequest("GET", fmt.Sprintf("http://test/?product=firefox-latest&os=osx&lang=en-US&n=%d", i), nil)
+			assert.NoError(t, err)
+			logger.Log(req, &accessLogWriter{status: 302}, time.Now())
+		}
+
+		logged := float64(strings.Count(out.String(), "\n")) / requests
+		assert.InDelta(t, rate, logged, 0.05, "rate: %v", rate)
+	}
+}
+
+func TestAccessLogSampleRateDeterministic(t *testing.T) {
+	out := new(bytes.Buffer)
+	logger := &AccessLogger{
+		Format:        AccessLogFormatCLF,
+		Output:        out,
+		LogSampleRate: 0.5,
+	}
+
+	for i := 0; i < 50; i++ {
+		req, err := http.NewRequest("GET", fmt.Sprintf("http://test/?product=firefox-latest&n=%d", i), nil)
+		assert.NoError(t, err)
+
+		logger.Log(req, &accessLogWriter{status: 302}, time.Now())
+		first := out.Len()
+		logger.Log(req, &accessLogWriter{status: 302}, time.Now())
+		assert.Equal(t, first, out.Len()-first, "n: %v", i)
+		out.Reset()
+	}
+}
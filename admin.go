@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mozilla-services/go-bouncer/mozlog"
+)
+
+// AuditEvent records an action taken through the admin endpoint
+type AuditEvent struct {
+	// Actor is the ip address of the client taking the action
+	Actor  string
+	Action string
+	Time   time.Time
+}
+
+// EventSink receives audit events
+type EventSink interface {
+	Audit(e *AuditEvent)
+}
+
+// MozLogEventSink writes audit events as mozlog entries of type "audit"
+type MozLogEventSink struct {
+	Output io.Writer
+}
+
+// Audit writes e to Output
+func (s *MozLogEventSink) Audit(e *AuditEvent) {
+	appLog := mozlog.NewAppLog("Bouncer", nil)
+	appLog.Timestamp = e.Time.UnixNano()
+	appLog.Type = "audit"
+	appLog.Fields = map[string]interface{}{
+		"actor":  e.Actor,
+		"action": e.Action,
+	}
+
+	line, err := appLog.ToJSON()
+	if err != nil {
+		log.Printf("MozLogEventSink.Audit err: %v", err)
+		return
+	}
+
+	_, err = s.Output.Write(append(line, '\n'))
+	if err != nil {
+		log.Printf("MozLogEventSink.Audit err: %v", err)
+	}
+}
+
+// Maintenance is a maintenance mode switch. While it is enabled, the bouncer
+// handler responds with 503 Service Unavailable.
+type Maintenance struct {
+	enabled int32
+}
+
+// Enabled returns true if maintenance mode is on. A nil Maintenance is never
+// enabled.
+func (m *Maintenance) Enabled() bool {
+	return m != nil && atomic.LoadInt32(&m.enabled) == 1
+}
+
+// SetEnabled turns maintenance mode on or off
+func (m *Maintenance) SetEnabled(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&m.enabled, v)
+}
+
+// AdminHandler serves the /__admin__/ endpoints
+type AdminHandler struct {
+	Maintenance *Maintenance
+	AliasCache  *AliasCache
+	Building    *BuildingProducts
+	StubSwitch  *StubSwitch
+	Events      EventSink
+
+	// AllowedCIDRs, if set, are the only networks allowed to use the admin
+	// endpoints
+	AllowedCIDRs []*net.IPNet
+}
+
+func (a *AdminHandler) audit(req *http.Request, action string) {
+	if a.Events == nil {
+		return
+	}
+	a.Events.Audit(&AuditEvent{
+		Actor:  remoteHost(req),
+		Action: action,
+		Time:   time.Now(),
+	})
+}
+
+func (a *AdminHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if len(a.AllowedCIDRs) > 0 && !inCIDRs(req, a.AllowedCIDRs) {
+		http.Error(w, "Forbidden.", http.StatusForbidden)
+		return
+	}
+
+	switch req.URL.Path {
+	case "/__admin__/maintenance":
+		a.serveMaintenance(w, req)
+	case "/__admin__/alias-cache/purge":
+		a.servePurgeAliasCache(w, req)
+	case "/__admin__/building":
+		a.serveBuilding(w, req)
+	case "/__admin__/stub":
+		a.serveStub(w, req)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// serveMaintenance returns the maintenance mode state. POST with
+// enabled=true or enabled=false toggles it.
+func (a *AdminHandler) serveMaintenance(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET":
+	case "POST":
+		enabled, err := strconv.ParseBool(req.FormValue("enabled"))
+		if err != nil {
+			http.Error(w, "enabled must be true or false.", http.StatusBadRequest)
+			return
+		}
+		a.Maintenance.SetEnabled(enabled)
+
+		action := "maintenance.disable"
+		if enabled {
+			action = "maintenance.enable"
+		}
+		a.audit(req, action)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method Not Allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	res, err := json.Marshal(map[string]bool{"maintenance": a.Maintenance.Enabled()})
+	if err != nil {
+		log.Printf("AdminHandler err: %v", err)
+		http.Error(w, "Internal Server Error.", http.StatusInternalServerError)
+		return
+	}
+	w.Write(res)
+}
+
+// servePurgeAliasCache purges the alias cache on POST, after the aliases or
+// rewrites it caches were changed
+func (a *AdminHandler) servePurgeAliasCache(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method Not Allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	if a.AliasCache == nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	a.AliasCache.Purge()
+	a.audit(req, "alias_cache.purge")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveBuilding returns the building products. POST with a product and
+// building=true or building=false flags the product or clears its flag.
+func (a *AdminHandler) serveBuilding(w http.ResponseWriter, req *http.Request) {
+	if a.Building == nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	switch req.Method {
+	case "GET":
+	case "POST":
+		product := strings.ToLower(strings.TrimSpace(req.FormValue("product")))
+		building, err := strconv.ParseBool(req.FormValue("building"))
+		if product == "" || err != nil {
+			http.Error(w, "product and building=true or building=false are required.", http.StatusBadRequest)
+			return
+		}
+		a.Building.SetBuilding(product, building)
+
+		action := "building.disable:" + product
+		if building {
+			action = "building.enable:" + product
+		}
+		a.audit(req, action)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method Not Allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	res, err := json.Marshal(map[string][]string{"building": a.Building.List()})
+	if err != nil {
+		log.Printf("AdminHandler err: %v", err)
+		http.Error(w, "Internal Server Error.", http.StatusInternalServerError)
+		return
+	}
+	w.Write(res)
+}
+
+// serveStub returns whether the stub installer is on. POST with
+// enabled=true or enabled=false switches it on or off.
+func (a *AdminHandler) serveStub(w http.ResponseWriter, req *http.Request) {
+	if a.StubSwitch == nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	switch req.Method {
+	case "GET":
+	case "POST":
+		enabled, err := strconv.ParseBool(req.FormValue("enabled"))
+		if err != nil {
+			http.Error(w, "enabled must be true or false.", http.StatusBadRequest)
+			return
+		}
+		a.StubSwitch.SetEnabled(enabled)
+
+		action := "stub.disable"
+		if enabled {
+			action = "stub.enable"
+		}
+		a.audit(req, action)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method Not Allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	res, err := json.Marshal(map[string]bool{"stub": a.StubSwitch.Enabled()})
+	if err != nil {
+		log.Printf("AdminHandler err: %v", err)
+		http.Error(w, "Internal Server Error.", http.StatusInternalServerError)
+		return
+	}
+	w.Write(res)
+}
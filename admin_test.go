@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mozilla-services/go-bouncer/mozlog"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingEventSink struct {
+	events []*AuditEvent
+}
+
+func (r *recordingEventSink) Audit(e *AuditEvent) {
+	r.events = append(r.events, e)
+}
+
+func TestAdminHandlerMaintenance(t *testing.T) {
+	maintenance := &Maintenance{}
+	events := &recordingEventSink{}
+	admin := &AdminHandler{
+		Maintenance: maintenance,
+		Events:      events,
+	}
+	handler := &BouncerHandler{
+		db:          bouncerHandler.db,
+		Maintenance: maintenance,
+	}
+
+	// reading the state isn't audited
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/__admin__/maintenance", nil)
+	assert.NoError(t, err)
+	admin.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, `{"maintenance":false}`, w.Body.String())
+	assert.Len(t, events.events, 0)
+
+	before := time.Now()
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "http://test/__admin__/maintenance?enabled=true", nil)
+	assert.NoError(t, err)
+	req.RemoteAddr = "192.0.2.1:54321"
+	admin.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, `{"maintenance":true}`, w.Body.String())
+	if assert.Len(t, events.events, 1) {
+		assert.Equal(t, "192.0.2.1", events.events[0].Actor)
+		assert.Equal(t, "maintenance.enable", events.events[0].Action)
+		assert.False(t, events.events[0].Time.Before(before))
+	}
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 503, w.Code)
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "http://test/__admin__/maintenance?enabled=false", nil)
+	assert.NoError(t, err)
+	req.RemoteAddr = "192.0.2.2:54321"
+	admin.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	if assert.Len(t, events.events, 2) {
+		assert.Equal(t, "192.0.2.2", events.events[1].Actor)
+		assert.Equal(t, "maintenance.disable", events.events[1].Action)
+	}
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 302, w.Code)
+
+	// invalid toggles are rejected without an audit event
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "http://test/__admin__/maintenance?enabled=maybe", nil)
+	assert.NoError(t, err)
+	admin.ServeHTTP(w, req)
+	assert.Equal(t, 400, w.Code)
+	assert.Len(t, events.events, 2)
+}
+
+func TestMozLogEventSink(t *testing.T) {
+	out := new(bytes.Buffer)
+	sink := &MozLogEventSink{Output: out}
+	now := time.Now()
+	sink.Audit(&AuditEvent{Actor: "192.0.2.1", Action: "maintenance.enable", Time: now})
+
+	var logEntry mozlog.AppLog
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &logEntry))
+	assert.Equal(t, "audit", logEntry.Type)
+	assert.Equal(t, now.UnixNano(), logEntry.Timestamp)
+	assert.Equal(t, "192.0.2.1", logEntry.Fields["actor"])
+	assert.Equal(t, "maintenance.enable", logEntry.Fields["action"])
+}
+
+func TestAdminHandlerAllowedCIDRs(t *testing.T) {
+	_, allowed, err := net.ParseCIDR("203.0.113.0/24")
+	assert.NoError(t, err)
+
+	events := &recordingEventSink{}
+	admin := &AdminHandler{
+		Maintenance:  &Maintenance{},
+		Events:       events,
+		AllowedCIDRs: []*net.IPNet{allowed},
+	}
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "http://test/__admin__/maintenance?enabled=true", nil)
+	assert.NoError(t, err)
+	req.RemoteAddr = "198.51.100.1:54321"
+	admin.ServeHTTP(w, req)
+	assert.Equal(t, 403, w.Code)
+	assert.False(t, admin.Maintenance.Enabled())
+	assert.Len(t, events.events, 0)
+
+	w = httptest.NewRecorder()
+	req.RemoteAddr = "203.0.113.7:54321"
+	admin.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.True(t, admin.Maintenance.Enabled())
+	assert.Len(t, events.events, 1)
+}
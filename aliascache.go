@@ -0,0 +1,117 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultAliasCacheSize is the default number of requested products the
+// AliasCache holds
+const DefaultAliasCacheSize = 10000
+
+// AliasCache caches the products requested products resolve to, after
+// ProductRewrites and aliases, across requests and oses and langs. Entries
+// expire after TTL, so catalog alias changes are picked up, and Purge drops
+// them all when the aliases or rewrites are reloaded. Requested products
+// are client input, so at most size are cached; new products aren't cached
+// once it is full of unexpired entries. It is safe for concurrent use.
+type AliasCache struct {
+	TTL time.Duration
+
+	mu      sync.Mutex
+	size    int
+	entries map[string]aliasCacheEntry
+}
+
+type aliasCacheEntry struct {
+	related string
+	expires time.Time
+}
+
+// NewAliasCache returns an empty cache of at most size entries, which
+// expire after ttl
+func NewAliasCache(ttl time.Duration, size int) *AliasCache {
+	return &AliasCache{
+		TTL:     ttl,
+		size:    size,
+		entries: make(map[string]aliasCacheEntry),
+	}
+}
+
+// Get returns the cached product of a requested product
+func (c *AliasCache) Get(product string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[product]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(e.expires) {
+		delete(c.entries, product)
+		return "", false
+	}
+	return e.related, true
+}
+
+// Set caches the product a requested product resolves to. If the cache is
+// full, its expired entries are dropped to make room, and product isn't
+// cached if there still is none.
+func (c *AliasCache) Set(product, related string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if _, ok := c.entries[product]; !ok && len(c.entries) >= c.size {
+		for key, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, key)
+			}
+		}
+		if len(c.entries) >= c.size {
+			return
+		}
+	}
+	c.entries[product] = aliasCacheEntry{
+		related: related,
+		expires: now.Add(c.TTL),
+	}
+}
+
+// Purge drops every entry, for reloads of the aliases or rewrites
+func (c *AliasCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]aliasCacheEntry)
+}
+
+// Len returns the number of cached entries, including expired ones which
+// haven't been dropped yet
+func (c *AliasCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// withAliasCacheResult returns a copy of b recording whether the alias
+// cache misses while serving a request. b is returned if there is no cache.
+func (b *BouncerHandler) withAliasCacheResult() *BouncerHandler {
+	if b.AliasCache == nil {
+		return b
+	}
+	ab := *b
+	ab.aliasCacheMissed = new(bool)
+	return &ab
+}
+
+// resolveTimingName returns the name a request's resolution is timed in:
+// resolve.hit if its alias lookups were all served by the alias cache,
+// resolve.miss if not, and resolve if there is no cache
+func (b *BouncerHandler) resolveTimingName() string {
+	if b.aliasCacheMissed == nil {
+		return "resolve"
+	}
+	if *b.aliasCacheMissed {
+		return "resolve.miss"
+	}
+	return "resolve.hit"
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mutableAliasCatalog resolves aliases from a map which tests change
+type mutableAliasCatalog struct {
+	Catalog
+	aliases map[string]string
+	lookups int
+}
+
+func (c *mutableAliasCatalog) AliasFor(product string) (string, error) {
+	c.lookups++
+	if related, ok := c.aliases[product]; ok {
+		return related, nil
+	}
+	if c.Catalog == nil {
+		return product, nil
+	}
+	return c.Catalog.AliasFor(product)
+}
+
+func TestAliasCache(t *testing.T) {
+	cache := NewAliasCache(time.Minute, DefaultAliasCacheSize)
+	_, ok := cache.Get("firefox-latest")
+	assert.False(t, ok)
+
+	cache.Set("firefox-latest", "Firefox")
+	related, ok := cache.Get("firefox-latest")
+	assert.True(t, ok)
+	assert.Equal(t, "Firefox", related)
+	assert.Equal(t, 1, cache.Len())
+
+	cache.Purge()
+	_, ok = cache.Get("firefox-latest")
+	assert.False(t, ok)
+	assert.Equal(t, 0, cache.Len())
+
+	expired := NewAliasCache(-time.Second, DefaultAliasCacheSize)
+	expired.Set("firefox-latest", "Firefox")
+	_, ok = expired.Get("firefox-latest")
+	assert.False(t, ok)
+	assert.Equal(t, 0, expired.Len())
+}
+
+func TestAliasCacheSize(t *testing.T) {
+	cache := NewAliasCache(time.Minute, 2)
+	cache.Set("firefox-latest", "Firefox")
+	cache.Set("firefox-sha1", "Firefox-43.0.1-SSL")
+	cache.Set("firefox-beta-latest", "Firefox-SSL")
+	assert.Equal(t, 2, cache.Len())
+	_, ok := cache.Get("firefox-beta-latest")
+	assert.False(t, ok)
+
+	// Cached products are still updated when it is full
+	cache.Set("firefox-latest", "Firefox-SSL")
+	related, ok := cache.Get("firefox-latest")
+	assert.True(t, ok)
+	assert.Equal(t, "Firefox-SSL", related)
+
+	// Expired entries make room
+	expired := NewAliasCache(-time.Second, 1)
+	expired.Set("firefox-latest", "Firefox")
+	expired.Set("firefox-sha1", "Firefox-43.0.1-SSL")
+	assert.Equal(t, 1, expired.Len())
+	expired.TTL = time.Minute
+	expired.Set("firefox-beta-latest", "Firefox-SSL")
+	related, ok = expired.Get("firefox-beta-latest")
+	assert.True(t, ok)
+	assert.Equal(t, "Firefox-SSL", related)
+}
+
+func TestBouncerHandlerAliasCache(t *testing.T) {
+	catalog := &mutableAliasCatalog{Catalog: bouncerHandler.db, aliases: map[string]string{}}
+	metrics := &recordingMetrics{}
+	handler := &BouncerHandler{
+		db:         catalog,
+		AliasCache: NewAliasCache(time.Minute, DefaultAliasCacheSize),
+		Metrics:    metrics,
+	}
+
+	resolve := func() string {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US", nil)
+		assert.NoError(t, err)
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, 302, w.Code)
+		return w.HeaderMap.Get("Location")
+	}
+
+	const firefox39 = "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"
+	const firefox43 = "https://download-installer.cdn.mozilla.net/pub/firefox/releases/43.0.1/mac/en-US/Firefox%2043.0.1.dmg"
+
+	assert.Equal(t, firefox39, resolve())
+	assert.Equal(t, firefox39, resolve())
+	assert.Equal(t, 1, catalog.lookups)
+	assert.Equal(t, 1, metrics.counts["alias_cache.miss"])
+	assert.Equal(t, 1, metrics.counts["alias_cache.hit"])
+
+	// The alias changes, but is served from cache until it is purged
+	catalog.aliases["firefox-latest"] = "Firefox-43.0.1-SSL"
+	assert.Equal(t, firefox39, resolve())
+
+	handler.AliasCache.Purge()
+	assert.Equal(t, firefox43, resolve())
+	assert.Equal(t, firefox43, resolve())
+	assert.Equal(t, 2, catalog.lookups)
+	assert.Equal(t, 2, metrics.counts["alias_cache.miss"])
+	assert.Equal(t, 3, metrics.counts["alias_cache.hit"])
+
+	// Products which aren't aliased aren't cached
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/?product=Firefox&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 302, w.Code)
+	assert.Equal(t, 1, handler.AliasCache.Len())
+}
+
+func TestAdminHandlerPurgeAliasCache(t *testing.T) {
+	cache := NewAliasCache(time.Minute, DefaultAliasCacheSize)
+	cache.Set("firefox-latest", "Firefox")
+	events := &recordingEventSink{}
+	admin := &AdminHandler{
+		Maintenance: &Maintenance{},
+		AliasCache:  cache,
+		Events:      events,
+	}
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/__admin__/alias-cache/purge", nil)
+	assert.NoError(t, err)
+	admin.ServeHTTP(w, req)
+	assert.Equal(t, 405, w.Code)
+	assert.Equal(t, 1, cache.Len())
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "http://test/__admin__/alias-cache/purge", nil)
+	assert.NoError(t, err)
+	admin.ServeHTTP(w, req)
+	assert.Equal(t, 204, w.Code)
+	assert.Equal(t, 0, cache.Len())
+	if assert.Len(t, events.events, 1) {
+		assert.Equal(t, "alias_cache.purge", events.events[0].Action)
+	}
+}
+
+func BenchmarkAliasFor(b *testing.B) {
+	rewrites := []*ProductRewrite{}
+	for i := 0; i < 20; i++ {
+		rewrite, err := NewProductRewrite(fmt.Sprintf(`firefox-(\d+)\.0-channel%d`, i), "firefox-$1.0-latest")
+		if err != nil {
+			b.Fatal(err)
+		}
+		rewrites = append(rewrites, rewrite)
+	}
+	catalog := &mutableAliasCatalog{aliases: map[string]string{"firefox-60.0-latest": "Firefox-60.0"}}
+
+	for _, cached := range []bool{false, true} {
+		handler := &BouncerHandler{db: catalog, ProductRewrites: rewrites}
+		name := "NoCache"
+		if cached {
+			handler.AliasCache = NewAliasCache(time.Minute, DefaultAliasCacheSize)
+			name = "Cache"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := handler.aliasFor("firefox-60.0-channel19"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import "strings"
+
+// ArchOSes maps the architecture qualified oses some clients send, e.g.
+// linux64-x86_64, to the catalog os of their builds. Mac builds are
+// universal, so every mac architecture is osx.
+var ArchOSes = map[string]string{
+	"linux-i686":   "linux",
+	"linux-i386":   "linux",
+	"linux-x86":    "linux",
+	"linux-x86_64": "linux64",
+	"linux-amd64":  "linux64",
+
+	"linux64-x86_64":  "linux64",
+	"linux64-amd64":   "linux64",
+	"linux-aarch64":   "linux64-aarch64",
+	"linux-arm64":     "linux64-aarch64",
+	"linux64-arm64":   "linux64-aarch64",
+	"linux64-aarch64": "linux64-aarch64",
+
+	"win-i686":   "win",
+	"win-x86":    "win",
+	"win32-i686": "win",
+	"win32-x86":  "win",
+	"win-x86_64": "win64",
+	"win-amd64":  "win64",
+
+	"win64-x86_64":  "win64",
+	"win64-amd64":   "win64",
+	"win-aarch64":   "win64-aarch64",
+	"win-arm64":     "win64-aarch64",
+	"win64-arm64":   "win64-aarch64",
+	"win64-aarch64": "win64-aarch64",
+
+	"osx-x86_64":  "osx",
+	"osx-aarch64": "osx",
+	"osx-arm64":   "osx",
+	"mac-x86_64":  "osx",
+	"mac-aarch64": "osx",
+	"mac-arm64":   "osx",
+}
+
+// archOSBases are the oses ArchOSes qualifies with an architecture
+var archOSBases = map[string]bool{
+	"linux":   true,
+	"linux64": true,
+	"win":     true,
+	"win32":   true,
+	"win64":   true,
+	"osx":     true,
+	"mac":     true,
+}
+
+// archOS returns the catalog os of an architecture qualified os, e.g. linux
+// for linux-i686. ok is false if os qualifies one of archOSBases with an
+// unknown architecture, e.g. linux-sparc. Other oses, including ones
+// qualified with a version rather than an architecture, e.g. osx-13, are
+// returned as is.
+func archOS(os string) (catalogOS string, ok bool) {
+	if catalogOS, ok := ArchOSes[os]; ok {
+		return catalogOS, true
+	}
+	parts := strings.SplitN(os, "-", 2)
+	if len(parts) == 2 && archOSBases[parts[0]] && !versionedOSRegex.MatchString(os) {
+		return os, false
+	}
+	return os, true
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchOS(t *testing.T) {
+	testOSes := []struct {
+		OS         string
+		ExpectedOS string
+		ExpectedOK bool
+	}{
+		{"linux-i686", "linux", true},
+		{"linux64-x86_64", "linux64", true},
+		{"linux-x86_64", "linux64", true},
+		{"linux-arm64", "linux64-aarch64", true},
+		{"win-x86", "win", true},
+		{"win64-amd64", "win64", true},
+		{"win-aarch64", "win64-aarch64", true},
+		{"mac-arm64", "osx", true},
+		// catalog oses are unchanged
+		{"win", "win", true},
+		{"win64-aarch64", "win64-aarch64", true},
+		{"linux64-aarch64", "linux64-aarch64", true},
+		// as are oses which aren't architecture qualified
+		{"android-api-16", "android-api-16", true},
+		{"bogus", "bogus", true},
+		// and ones qualified with a version
+		{"osx-13", "osx-13", true},
+		{"win-10", "win-10", true},
+		// unknown architectures
+		{"linux-sparc", "linux-sparc", false},
+		{"win64-mips", "win64-mips", false},
+		{"osx-ppc", "osx-ppc", false},
+	}
+
+	for _, testOS := range testOSes {
+		os, ok := archOS(testOS.OS)
+		assert.Equal(t, testOS.ExpectedOS, os, "os: %v", testOS.OS)
+		assert.Equal(t, testOS.ExpectedOK, ok, "os: %v", testOS.OS)
+	}
+}
+
+func TestBouncerHandlerArchOS(t *testing.T) {
+	handler := &BouncerHandler{db: bouncerHandler.db}
+
+	testRequests := []struct {
+		URL              string
+		ExpectedCode     int
+		ExpectedLocation string
+	}{
+		{"http://test/?product=firefox-latest&os=win64-x86_64&lang=en-US", 302, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win64/en-US/Firefox%20Setup%2039.0.exe"},
+		{"http://test/?product=firefox-latest&os=win-amd64&lang=en-US", 302, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win64/en-US/Firefox%20Setup%2039.0.exe"},
+		{"http://test/?product=firefox-latest&os=win-i686&lang=en-US", 302, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win32/en-US/Firefox%20Setup%2039.0.exe"},
+		{"http://test/?product=firefox-latest&os=osx-arm64&lang=en-US", 302, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{"http://test/?product=firefox-latest&os=linux-sparc&lang=en-US", 404, ""},
+		{"http://test/?product=firefox-latest&os=win64-mips&lang=en-US", 404, ""},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedCode, w.Code, "url: %v", testRequest.URL)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v", testRequest.URL)
+	}
+
+	// unknown architectures are explained like unknown oses
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=linux-sparc&lang=en-US&format=json", nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Contains(t, w.Body.String(), `"code":"os_not_found"`)
+}
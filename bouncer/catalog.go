@@ -0,0 +1,180 @@
+package bouncer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Kinds of CatalogWarning
+const (
+	WarningProductWithoutLocations = "product_without_locations"
+	WarningAliasToUnknownProduct   = "alias_to_unknown_product"
+	WarningLocationWithoutLang     = "location_without_lang"
+	WarningDuplicateAlias          = "duplicate_alias"
+)
+
+// CatalogWarning is catalog data which looks inconsistent
+type CatalogWarning struct {
+	Kind    string
+	Message string
+}
+
+func (w CatalogWarning) String() string {
+	return w.Kind + ": " + w.Message
+}
+
+type CatalogProduct struct {
+	ID      string
+	Name    string
+	SSLOnly bool
+	// Langs is the number of langs the product is available in. Products
+	// without langs are lang neutral.
+	Langs int
+}
+
+type CatalogAlias struct {
+	Alias   string
+	Product string
+}
+
+type CatalogLocation struct {
+	ProductID string
+	OS        string
+	Path      string
+}
+
+// CatalogData is the part of the catalog requests are resolved against
+type CatalogData struct {
+	Products  []CatalogProduct
+	Aliases   []CatalogAlias
+	Locations []CatalogLocation
+}
+
+// LoadCatalogData returns the active products, with their aliases and
+// locations
+func (d *DB) LoadCatalogData() (*CatalogData, error) {
+	data := &CatalogData{}
+
+	rows, err := d.Query(
+		`SELECT prod.id, prod.name, prod.ssl_only, COUNT(langs.id) FROM mirror_products AS prod
+			LEFT JOIN mirror_product_langs AS langs ON (prod.id = langs.product_id)
+			WHERE prod.active='1'
+			GROUP BY prod.id, prod.name, prod.ssl_only`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p CatalogProduct
+		sslInt := 0
+		if err := rows.Scan(&p.ID, &p.Name, &sslInt, &p.Langs); err != nil {
+			return nil, err
+		}
+		p.SSLOnly = sslInt == 1
+		data.Products = append(data.Products, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = d.Query(`SELECT alias, related_product FROM mirror_aliases`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a CatalogAlias
+		if err := rows.Scan(&a.Alias, &a.Product); err != nil {
+			return nil, err
+		}
+		data.Aliases = append(data.Aliases, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = d.Query(
+		`SELECT mirror_locations.product_id, mirror_os.name, mirror_locations.path FROM mirror_locations
+			INNER JOIN mirror_os ON (mirror_os.id = mirror_locations.os_id)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l CatalogLocation
+		if err := rows.Scan(&l.ProductID, &l.OS, &l.Path); err != nil {
+			return nil, err
+		}
+		data.Locations = append(data.Locations, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// Warnings returns the data which looks inconsistent: products without
+// locations, aliases to products which don't exist, locations of products
+// with langs missing :lang, and aliases defined more than once
+func (c *CatalogData) Warnings() []CatalogWarning {
+	warnings := []CatalogWarning{}
+
+	products := make(map[string]CatalogProduct, len(c.Products))
+	productNames := make(map[string]bool, len(c.Products))
+	for _, p := range c.Products {
+		products[p.ID] = p
+		productNames[strings.ToLower(p.Name)] = true
+	}
+
+	locationCounts := make(map[string]int, len(c.Products))
+	for _, l := range c.Locations {
+		locationCounts[l.ProductID]++
+
+		p, ok := products[l.ProductID]
+		if ok && p.Langs > 0 && !strings.Contains(l.Path, ":lang") {
+			warnings = append(warnings, CatalogWarning{
+				Kind:    WarningLocationWithoutLang,
+				Message: fmt.Sprintf("%s location for %s has no :lang: %s", l.OS, p.Name, l.Path),
+			})
+		}
+	}
+
+	for _, p := range c.Products {
+		if locationCounts[p.ID] == 0 {
+			warnings = append(warnings, CatalogWarning{
+				Kind:    WarningProductWithoutLocations,
+				Message: fmt.Sprintf("%s has no locations", p.Name),
+			})
+		}
+	}
+
+	aliasCounts := make(map[string]int, len(c.Aliases))
+	for _, a := range c.Aliases {
+		aliasCounts[strings.TrimSpace(strings.ToLower(a.Alias))]++
+
+		if !productNames[strings.ToLower(a.Product)] {
+			warnings = append(warnings, CatalogWarning{
+				Kind:    WarningAliasToUnknownProduct,
+				Message: fmt.Sprintf("%s refers to unknown product %s", a.Alias, a.Product),
+			})
+		}
+	}
+
+	duplicates := []string{}
+	for alias, count := range aliasCounts {
+		if count > 1 {
+			duplicates = append(duplicates, alias)
+		}
+	}
+	sort.Strings(duplicates)
+	for _, alias := range duplicates {
+		warnings = append(warnings, CatalogWarning{
+			Kind:    WarningDuplicateAlias,
+			Message: fmt.Sprintf("%s is defined %d times", alias, aliasCounts[alias]),
+		})
+	}
+
+	return warnings
+}
@@ -0,0 +1,51 @@
+package bouncer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadCatalogData(t *testing.T) {
+	data, err := testDB.LoadCatalogData()
+	assert.NoError(t, err)
+	assert.Len(t, data.Products, 3)
+	assert.Len(t, data.Aliases, 2)
+	assert.True(t, len(data.Locations) > 0)
+	for _, p := range data.Products {
+		assert.Equal(t, p.Name != "Firefox", p.SSLOnly, "product: %v", p.Name)
+	}
+
+	// the test fixtures are consistent
+	assert.Len(t, data.Warnings(), 0)
+}
+
+func TestCatalogDataWarnings(t *testing.T) {
+	data := &CatalogData{
+		Products: []CatalogProduct{
+			{ID: "1", Name: "Firefox", Langs: 2},
+			{ID: "2", Name: "Firefox-SSL", Langs: 2},
+			{ID: "3", Name: "Firefox-Stub", Langs: 0},
+			{ID: "4", Name: "Firefox-Empty", Langs: 1},
+		},
+		Aliases: []CatalogAlias{
+			{"firefox-latest", "Firefox"},
+			{"firefox-ssl-latest", "firefox-ssl"},
+			{"firefox-gone", "Firefox-Gone"},
+			{"firefox-stub", "Firefox-Stub"},
+			{"Firefox-Stub ", "Firefox-Stub"},
+		},
+		Locations: []CatalogLocation{
+			{"1", "osx", "/firefox/releases/39.0/mac/:lang/Firefox%2039.0.dmg"},
+			{"2", "osx", "/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+			{"3", "win", "/firefox/releases/39.0/win32/Firefox%20Stub.exe"},
+		},
+	}
+
+	assert.Equal(t, []CatalogWarning{
+		{WarningLocationWithoutLang, "osx location for Firefox-SSL has no :lang: /firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{WarningProductWithoutLocations, "Firefox-Empty has no locations"},
+		{WarningAliasToUnknownProduct, "firefox-gone refers to unknown product Firefox-Gone"},
+		{WarningDuplicateAlias, "firefox-stub is defined 2 times"},
+	}, data.Warnings())
+}
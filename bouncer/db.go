@@ -57,23 +57,52 @@ func (d *DB) OSID(name string) (id string, err error) {
 	return
 }
 
-func (d *DB) ProductForLanguage(product, lang string) (productID string, sslOnly bool, err error) {
+// ProductForLanguage returns the id of a product available in lang, and lang
+// as it is spelled in the catalog. Products without languages return lang
+// unchanged.
+func (d *DB) ProductForLanguage(product, lang string) (productID string, sslOnly bool, language string, err error) {
 	sslInt := 0
+	var catalogLang sql.NullString
 	err = d.QueryRow(
-		`SELECT prod.id, prod.ssl_only FROM mirror_products AS prod
+		`SELECT prod.id, prod.ssl_only, langs.language FROM mirror_products AS prod
 		LEFT JOIN mirror_product_langs AS langs ON (prod.id = langs.product_id)
 		WHERE prod.name LIKE ?
 		AND (langs.language LIKE ? OR langs.language IS NULL)`,
-		product, lang).Scan(&productID, &sslInt)
+		product, lang).Scan(&productID, &sslInt, &catalogLang)
 
 	if sslInt == 1 {
 		sslOnly = true
 	} else {
 		sslOnly = false
 	}
+
+	language = lang
+	if catalogLang.Valid {
+		language = catalogLang.String
+	}
 	return
 }
 
+// ProductRegions returns the langs of a product for a language without a
+// region, e.g. fr-CA and fr-FR for fr, sorted
+func (d *DB) ProductRegions(product, language string) ([]string, error) {
+	return d.queryStrings(
+		`SELECT langs.language FROM mirror_product_langs AS langs
+			INNER JOIN mirror_products AS prod ON (prod.id = langs.product_id)
+			WHERE prod.name LIKE ? AND langs.language LIKE ?
+			ORDER BY langs.language`,
+		product, language+"-%")
+}
+
+// ProductExists returns true if the catalog has a product, in any lang
+func (d *DB) ProductExists(product string) (bool, error) {
+	count := 0
+	err := d.QueryRow(
+		`SELECT COUNT(*) FROM mirror_products WHERE name LIKE ?`,
+		product).Scan(&count)
+	return count > 0, err
+}
+
 // Location returns the path of the product/os combonation
 func (d *DB) Location(productID, osID string) (id, path string, err error) {
 	err = d.QueryRow(
@@ -84,6 +113,41 @@ func (d *DB) Location(productID, osID string) (id, path string, err error) {
 	return
 }
 
+type ProductLocationsResult struct {
+	OS   string
+	Path string
+}
+
+// ProductLocations returns the path of a product for every os it is available on
+func (d *DB) ProductLocations(productID string) ([]*ProductLocationsResult, error) {
+	rows, err := d.Query(
+		`SELECT mirror_os.name, mirror_locations.path FROM mirror_locations
+			INNER JOIN mirror_os ON (mirror_os.id = mirror_locations.os_id)
+			WHERE mirror_locations.product_id = ?
+			ORDER BY mirror_os.name`,
+		productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make([]*ProductLocationsResult, 0)
+	for rows.Next() {
+		tmp := new(ProductLocationsResult)
+		err = rows.Scan(&tmp.OS, &tmp.Path)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, tmp)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
 type MirrorsResult struct {
 	ID      string
 	BaseURL string
@@ -133,6 +197,66 @@ func (d *DB) Mirrors(sslOnly bool) ([]MirrorsResult, error) {
 	return results, nil
 }
 
+// ProductCount returns the number of active products
+func (d *DB) ProductCount() (count int, err error) {
+	err = d.QueryRow(
+		`SELECT COUNT(*) FROM mirror_products WHERE active='1'`).Scan(&count)
+
+	return
+}
+
+// HealthyMirrorCount returns the number of active mirrors with a rating
+func (d *DB) HealthyMirrorCount() (count int, err error) {
+	err = d.QueryRow(
+		`SELECT COUNT(*) FROM mirror_mirrors WHERE active='1' AND rating > 0`).Scan(&count)
+
+	return
+}
+
+// CatalogOSes returns the sorted names of the oses active products are
+// available on
+func (d *DB) CatalogOSes() ([]string, error) {
+	return d.queryStrings(
+		`SELECT DISTINCT mirror_os.name FROM mirror_os
+			INNER JOIN mirror_locations ON (mirror_locations.os_id = mirror_os.id)
+			INNER JOIN mirror_products ON (mirror_products.id = mirror_locations.product_id)
+			WHERE mirror_products.active='1'
+			ORDER BY mirror_os.name`)
+}
+
+// CatalogLangs returns the sorted langs active products are available in
+func (d *DB) CatalogLangs() ([]string, error) {
+	return d.queryStrings(
+		`SELECT DISTINCT langs.language FROM mirror_product_langs AS langs
+			INNER JOIN mirror_products ON (mirror_products.id = langs.product_id)
+			WHERE mirror_products.active='1'
+			ORDER BY langs.language`)
+}
+
+// queryStrings returns the single string column of the rows of a query
+func (d *DB) queryStrings(query string, args ...interface{}) ([]string, error) {
+	rows, err := d.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make([]string, 0)
+	for rows.Next() {
+		var tmp string
+		if err := rows.Scan(&tmp); err != nil {
+			return nil, err
+		}
+		results = append(results, tmp)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
 type LocationsActiveResult struct {
 	ID   string
 	Path string
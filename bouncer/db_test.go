@@ -33,15 +33,69 @@ func TestOSID(t *testing.T) {
 }
 
 func TestProductForLanguage(t *testing.T) {
-	res, sslOnly, err := testDB.ProductForLanguage("Firefox", "en-US")
+	res, sslOnly, lang, err := testDB.ProductForLanguage("Firefox", "en-US")
 	assert.NoError(t, err)
 	assert.False(t, sslOnly)
 	assert.Equal(t, "1", res)
+	assert.Equal(t, "en-US", lang)
 
-	res, sslOnly, err = testDB.ProductForLanguage("Firefox-SSL", "en-US")
+	res, sslOnly, lang, err = testDB.ProductForLanguage("Firefox-SSL", "en-US")
 	assert.NoError(t, err)
 	assert.True(t, sslOnly)
 	assert.Equal(t, "2", res)
+	assert.Equal(t, "en-US", lang)
+
+	res, _, lang, err = testDB.ProductForLanguage("Firefox", "en-gb")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", res)
+	assert.Equal(t, "en-GB", lang)
+}
+
+func TestProductExists(t *testing.T) {
+	exists, err := testDB.ProductExists("firefox-ssl")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = testDB.ProductExists("Bogus")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestProductRegions(t *testing.T) {
+	langs, err := testDB.ProductRegions("Firefox", "en")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"en-GB", "en-US"}, langs)
+
+	langs, err = testDB.ProductRegions("Firefox", "fr")
+	assert.NoError(t, err)
+	assert.Len(t, langs, 0)
+}
+
+func TestCatalogOSes(t *testing.T) {
+	oses, err := testDB.CatalogOSes()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"osx", "win", "win64"}, oses)
+}
+
+func TestCatalogLangs(t *testing.T) {
+	langs, err := testDB.CatalogLangs()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"en-GB", "en-US"}, langs)
+}
+
+func TestProductLocations(t *testing.T) {
+	locations, err := testDB.ProductLocations("1")
+	assert.NoError(t, err)
+	if assert.Len(t, locations, 3) {
+		assert.Equal(t, "osx", locations[0].OS)
+		assert.Equal(t, "/firefox/releases/39.0/mac/:lang/Firefox%2039.0.dmg", locations[0].Path)
+		assert.Equal(t, "win", locations[1].OS)
+		assert.Equal(t, "win64", locations[2].OS)
+	}
+
+	locations, err = testDB.ProductLocations("1000")
+	assert.NoError(t, err)
+	assert.Len(t, locations, 0)
 }
 
 func TestMirrors(t *testing.T) {
@@ -55,6 +109,18 @@ func TestMirrors(t *testing.T) {
 	assert.Equal(t, "2", mirrors[0].ID)
 }
 
+func TestProductCount(t *testing.T) {
+	count, err := testDB.ProductCount()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+}
+
+func TestHealthyMirrorCount(t *testing.T) {
+	count, err := testDB.HealthyMirrorCount()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
 func LocationsActive(t *testing.T) {
 	locations, err := testDB.LocationsActive(false)
 	assert.NoError(t, err)
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// BuildingProducts are the products whose release is in progress: their
+// version was bumped but their files aren't uploaded yet. Requests for them
+// get a 503 telling clients to retry, rather than a 404. It is safe for
+// concurrent use.
+type BuildingProducts struct {
+	mu       sync.RWMutex
+	products map[string]bool
+}
+
+// NewBuildingProducts returns the set of building products
+func NewBuildingProducts(products ...string) *BuildingProducts {
+	b := &BuildingProducts{products: make(map[string]bool, len(products))}
+	for _, p := range products {
+		b.SetBuilding(p, true)
+	}
+	return b
+}
+
+// IsBuilding returns true if the requested product is building. A nil
+// BuildingProducts has no building product.
+func (b *BuildingProducts) IsBuilding(product string) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.products[strings.ToLower(product)]
+}
+
+// SetBuilding flags a product as building, or clears the flag
+func (b *BuildingProducts) SetBuilding(product string, building bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	product = strings.ToLower(strings.TrimSpace(product))
+	if building {
+		b.products[product] = true
+	} else {
+		delete(b.products, product)
+	}
+}
+
+// List returns the building products, sorted
+func (b *BuildingProducts) List() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	products := make([]string, 0, len(b.products))
+	for p := range b.products {
+		products = append(products, p)
+	}
+	sort.Strings(products)
+	return products
+}
+
+// buildingBody is the json body of responses for building products
+type buildingBody struct {
+	Status  string    `json:"status"`
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+// serveBuilding responds with a 503 telling the client the product is
+// building, as json whatever the client asked for, since it is read by
+// release automation
+func (b *BouncerHandler) serveBuilding(w http.ResponseWriter, req *http.Request) {
+	setRetryAfter(w, b.retryAfter(ErrorCodeBuilding))
+
+	res, err := json.Marshal(&buildingBody{Status: "building", Code: ErrorCodeBuilding, Message: "Service Unavailable."})
+	if err != nil {
+		log.Printf("serveBuilding err: %v", err)
+		errorResponse(w, req, http.StatusInternalServerError, ErrorCodeInternal, "Internal Server Error.")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write(res)
+}
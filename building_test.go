@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBouncerHandlerBuilding(t *testing.T) {
+	handler := &BouncerHandler{
+		db:       betaCatalog(),
+		Building: NewBuildingProducts("Firefox-Beta-Latest"),
+	}
+
+	// a building product
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-beta-latest&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 503, w.Code)
+	assert.Equal(t, "60", w.HeaderMap.Get("Retry-After"))
+	assert.Equal(t, "application/json", w.HeaderMap.Get("Content-Type"))
+	assert.Equal(t, "no-store", w.HeaderMap.Get("Cache-Control"))
+	assert.Equal(t, `{"status":"building","code":"building","message":"Service Unavailable."}`, w.Body.String())
+
+	// a normal one
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 302, w.Code)
+	assert.Equal(t, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", w.HeaderMap.Get("Location"))
+
+	// Retry-After is configurable
+	handler.RetryAfter = map[ErrorCode]time.Duration{ErrorCodeBuilding: 5 * time.Minute}
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "http://test/?product=firefox-beta-latest&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 503, w.Code)
+	assert.Equal(t, "300", w.HeaderMap.Get("Retry-After"))
+
+	// once built, the product is served
+	handler.Building.SetBuilding("firefox-beta-latest", false)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 302, w.Code)
+}
+
+func TestAdminHandlerBuilding(t *testing.T) {
+	building := NewBuildingProducts()
+	events := &recordingEventSink{}
+	admin := &AdminHandler{Building: building, Events: events}
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "http://test/__admin__/building?product=Firefox-Beta-Latest&building=true", nil)
+	assert.NoError(t, err)
+	admin.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, `{"building":["firefox-beta-latest"]}`, w.Body.String())
+	assert.True(t, building.IsBuilding("firefox-beta-latest"))
+	if assert.Len(t, events.events, 1) {
+		assert.Equal(t, "building.enable:firefox-beta-latest", events.events[0].Action)
+	}
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "http://test/__admin__/building?product=firefox-beta-latest&building=false", nil)
+	assert.NoError(t, err)
+	admin.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, `{"building":[]}`, w.Body.String())
+	assert.False(t, building.IsBuilding("firefox-beta-latest"))
+
+	// invalid toggles are rejected without an audit event
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "http://test/__admin__/building?building=true", nil)
+	assert.NoError(t, err)
+	admin.ServeHTTP(w, req)
+	assert.Equal(t, 400, w.Code)
+	assert.Len(t, events.events, 2)
+}
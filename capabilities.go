@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// CapabilitiesPath is the path of the capabilities document
+const CapabilitiesPath = "/capabilities"
+
+// Capabilities lists the features a deployment has enabled and the limits
+// of its requests, so clients can discover them without trial and error
+type Capabilities struct {
+	Features map[string]bool `json:"features"`
+	// Formats are the format param values served, besides the default
+	// redirect
+	Formats []string       `json:"formats"`
+	Limits  map[string]int `json:"limits"`
+}
+
+// CapabilitiesHandler serves the Capabilities of Bouncer as json
+type CapabilitiesHandler struct {
+	Bouncer *BouncerHandler
+	// GzipMinSize is the GzipHandler MinSize responses are gzipped from,
+	// or 0 if responses aren't gzipped
+	GzipMinSize int
+	// MaxHeaderBytes is the http.Server MaxHeaderBytes, which bounds the
+	// request line, query included. If 0, http.DefaultMaxHeaderBytes is
+	// used.
+	MaxHeaderBytes int
+}
+
+// capabilities computes the Capabilities from the handler config
+func (h *CapabilitiesHandler) capabilities() *Capabilities {
+	b := h.Bouncer
+	c := &Capabilities{
+		Features: map[string]bool{
+			"json_errors":            true,
+			"spec":                   true,
+			"channels":               true,
+			"versioned_os":           true,
+			"immutable_urls":         true,
+			"short_codes":            len(b.ShortCodes) > 0,
+			"bundles":                len(b.Bundles) > 0,
+			"checksums":              len(b.Bundles) > 0 && len(b.Checksums) > 0,
+			"multiple_choices":       b.MultipleChoices,
+			"canonical_os_keys":      b.CanonicalOSKeys,
+			"infer_os":               b.InferOS || b.ClientOSHeaderName != "",
+			"upgrade_win64":          b.UpgradeWin64,
+			"accept_language":        b.EnableAcceptLanguage,
+			"language_regions":       len(b.LanguageRegions) > 0,
+			"locale_fallbacks":       len(b.LocaleFallbackTable) > 0,
+			"lang_landing":           b.LangLandingURL != "",
+			"license":                b.LicenseURLTemplate != "",
+			"esr_cycles":             len(b.ESRCycles) > 0,
+			"prefer_https":           b.PreferHTTPS,
+			"https_fallback":         b.HTTPSFallback,
+			"https_langs":            len(b.HTTPSLangs) > 0,
+			"cache_busting":          b.CacheBusting,
+			"print_json":             b.PrintJSON,
+			"torrents":               b.Torrents,
+			"stub":                   b.StubRootURL != "" && b.StubSwitch.Enabled(),
+			"attribution_forwarding": len(b.AttributionEndpoints) > 0,
+			"one_time_urls":          b.OneTimeTokens != nil && len(b.OneTimeProducts) > 0,
+			"eula":                   len(b.EULAProducts) > 0,
+			"building":               b.Building != nil,
+			"sunset":                 len(b.SunsetDates) > 0,
+			"partner_catalogs":       len(b.Partners) > 0 && len(b.PartnerKey) > 0,
+			"staging":                b.EnableStaging && b.Staging != nil,
+			"fallback_catalogs":      len(b.FallbackCatalogs) > 0,
+			"feature_flags":          len(b.ProductFeatureFlags) > 0,
+			"experiments":            len(b.Experiments) > 0,
+			"archive":                b.ArchiveBaseURL != "",
+			"mirror_pools":           len(b.MirrorPools) > 0,
+			"mirror_regions":         len(b.MirrorRegions) > 0,
+			"mirror_latencies":       b.MirrorLatencies != nil,
+			"mirror_decisions":       b.DebugToken != "",
+			"missing_locales":        b.MissingLocales != nil && b.DebugToken != "",
+			"gzip":                   h.GzipMinSize > 0,
+		},
+		Formats: []string{},
+		Limits: map[string]int{
+			"max_spec_length":  MaxSpecLength,
+			"max_header_bytes": h.MaxHeaderBytes,
+		},
+	}
+	if c.Limits["max_header_bytes"] <= 0 {
+		c.Limits["max_header_bytes"] = http.DefaultMaxHeaderBytes
+	}
+
+	if c.Features["bundles"] {
+		c.Formats = append(c.Formats, FormatJSON)
+		maxBundleSize := 0
+		for _, products := range b.Bundles {
+			if len(products) > maxBundleSize {
+				maxBundleSize = len(products)
+			}
+		}
+		c.Limits["max_bundle_size"] = maxBundleSize
+	}
+	if b.Torrents {
+		c.Formats = append(c.Formats, FormatTorrent)
+	}
+	if h.GzipMinSize > 0 {
+		c.Limits["gzip_min_size"] = h.GzipMinSize
+	}
+	if b.MaxFallbackAttempts > 0 {
+		c.Limits["max_fallback_attempts"] = b.MaxFallbackAttempts
+	}
+	return c
+}
+
+func (h *CapabilitiesHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	res, err := json.Marshal(h.capabilities())
+	if err != nil {
+		log.Printf("CapabilitiesHandler err: %v", err)
+		errorResponse(w, req, http.StatusInternalServerError, ErrorCodeInternal, "Internal Server Error.")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
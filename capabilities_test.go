@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func capabilitiesOf(t *testing.T, handler *CapabilitiesHandler) *Capabilities {
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test"+CapabilitiesPath, nil)
+	assert.NoError(t, err)
+
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "application/json", w.HeaderMap.Get("Content-Type"))
+
+	c := &Capabilities{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), c))
+	return c
+}
+
+func TestCapabilitiesHandlerDefaults(t *testing.T) {
+	c := capabilitiesOf(t, &CapabilitiesHandler{Bouncer: &BouncerHandler{db: bouncerHandler.db}})
+
+	for _, feature := range []string{"json_errors", "spec", "channels", "versioned_os", "immutable_urls"} {
+		assert.True(t, c.Features[feature], "feature: %v", feature)
+	}
+	for _, feature := range []string{"bundles", "checksums", "multiple_choices", "torrents", "one_time_urls", "partner_catalogs", "staging", "gzip", "https_fallback",
+		"https_langs", "stub", "building", "sunset", "experiments", "mirror_pools", "mirror_regions", "mirror_latencies", "missing_locales"} {
+		enabled, ok := c.Features[feature]
+		assert.True(t, ok, "feature: %v", feature)
+		assert.False(t, enabled, "feature: %v", feature)
+	}
+	assert.Equal(t, []string{}, c.Formats)
+	assert.Equal(t, map[string]int{
+		"max_spec_length":  MaxSpecLength,
+		"max_header_bytes": http.DefaultMaxHeaderBytes,
+	}, c.Limits)
+}
+
+func TestCapabilitiesHandlerEnabled(t *testing.T) {
+	handler := &CapabilitiesHandler{
+		Bouncer: &BouncerHandler{
+			db:              bouncerHandler.db,
+			MultipleChoices: true,
+			Torrents:        true,
+			HTTPSFallback:   true,
+			Bundles: map[string][]string{
+				"firefox-all":   {"firefox-latest", "firefox-beta-latest", "firefox-esr-latest"},
+				"firefox-pairs": {"firefox-latest", "firefox-beta-latest"},
+			},
+			Partners:   map[string]Catalog{"acme": bouncerHandler.db},
+			PartnerKey: []byte("secret"),
+			// staging isn't enabled without its catalog
+			EnableStaging:       true,
+			HTTPSLangs:          []string{"en"},
+			StubRootURL:         "https://stub/",
+			StubSwitch:          &StubSwitch{},
+			Building:            NewBuildingProducts(),
+			SunsetDates:         map[string]time.Time{"firefox-latest": time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC)},
+			Experiments:         map[string]float64{"experiment": 10},
+			MirrorPools:         map[string][]string{"eu.download.example.com": {"eu1.cdn.example.com"}},
+			MirrorRegions:       map[string][]string{"eu": {"eu1.cdn.example.com"}},
+			MaxFallbackAttempts: 4,
+		},
+		GzipMinSize:    1400,
+		MaxHeaderBytes: 8192,
+	}
+	c := capabilitiesOf(t, handler)
+
+	for _, feature := range []string{"bundles", "multiple_choices", "torrents", "https_fallback", "partner_catalogs", "gzip",
+		"https_langs", "stub", "building", "sunset", "experiments", "mirror_pools", "mirror_regions"} {
+		assert.True(t, c.Features[feature], "feature: %v", feature)
+	}
+	assert.False(t, c.Features["staging"])
+	assert.False(t, c.Features["one_time_urls"])
+	// nor are checksums without a checksums file
+	assert.False(t, c.Features["checksums"])
+	assert.Equal(t, []string{FormatJSON, FormatTorrent}, c.Formats)
+	assert.Equal(t, map[string]int{
+		"max_spec_length":       MaxSpecLength,
+		"max_header_bytes":      8192,
+		"max_bundle_size":       3,
+		"gzip_min_size":         1400,
+		"max_fallback_attempts": 4,
+	}, c.Limits)
+
+	// the stub switch turns the stub off
+	handler.Bouncer.StubSwitch.SetEnabled(false)
+	assert.False(t, capabilitiesOf(t, handler).Features["stub"])
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/codegangsta/cli"
+	"github.com/mozilla-services/go-bouncer/bouncer"
+)
+
+// catalogCheckMirror is the base url used when resolving catalog checks, so
+// results don't depend on which mirror is picked
+const catalogCheckMirror = "mirror.invalid"
+
+// CatalogCheck is a product, os and lang combination a catalog is expected
+// to resolve
+type CatalogCheck struct {
+	Product string `json:"product"`
+	OS      string `json:"os"`
+	Lang    string `json:"lang"`
+
+	// Expected, if set, is the url the candidate catalog must resolve to,
+	// with mirror.invalid as the mirror, e.g.,:
+	// http://mirror.invalid/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg
+	Expected string `json:"expected,omitempty"`
+}
+
+func (c CatalogCheck) String() string {
+	return fmt.Sprintf("product=%s os=%s lang=%s", c.Product, c.OS, c.Lang)
+}
+
+// CatalogDiff is a check which the candidate catalog resolves differently
+// from the live catalog, or from what was expected
+type CatalogDiff struct {
+	Check     CatalogCheck
+	Live      string
+	Candidate string
+}
+
+func (d CatalogDiff) String() string {
+	want, wantName := d.Live, "live"
+	if d.Check.Expected != "" {
+		want, wantName = d.Check.Expected, "expected"
+	}
+	return fmt.Sprintf("%s: %s %s, candidate %s", d.Check, wantName, catalogField(want), catalogField(d.Candidate))
+}
+
+func catalogField(url string) string {
+	if url == "" {
+		return "(not found)"
+	}
+	return url
+}
+
+// newCatalogCheckHandler returns a handler resolving checks against db
+func newCatalogCheckHandler(db Catalog) *BouncerHandler {
+	return &BouncerHandler{
+		db:                 db,
+		PinnedBaseURLHttp:  catalogCheckMirror,
+		PinnedBaseURLHttps: catalogCheckMirror,
+	}
+}
+
+// diffCatalogs resolves checks against the live and candidate catalogs and
+// returns the checks that resolve differently
+func diffCatalogs(live, candidate *BouncerHandler, checks []CatalogCheck) ([]CatalogDiff, error) {
+	diffs := []CatalogDiff{}
+	for _, check := range checks {
+		liveURL, err := live.URL(false, check.Lang, check.OS, check.Product)
+		if err != nil {
+			return nil, err
+		}
+
+		candidateURL, err := candidate.URL(false, check.Lang, check.OS, check.Product)
+		if err != nil {
+			return nil, err
+		}
+
+		want := liveURL
+		if check.Expected != "" {
+			want = check.Expected
+		}
+		if candidateURL != want {
+			diffs = append(diffs, CatalogDiff{
+				Check:     check,
+				Live:      liveURL,
+				Candidate: candidateURL,
+			})
+		}
+	}
+	return diffs, nil
+}
+
+// readCatalogChecks reads a json list of checks
+func readCatalogChecks(r io.Reader) ([]CatalogCheck, error) {
+	checks := []CatalogCheck{}
+	if err := json.NewDecoder(r).Decode(&checks); err != nil {
+		return nil, err
+	}
+	for i := range checks {
+		if checks[i].Lang == "" {
+			checks[i].Lang = DefaultLang
+		}
+	}
+	return checks, nil
+}
+
+// catalogWarnings returns the inconsistent data in the catalog of db
+func catalogWarnings(db *bouncer.DB) ([]bouncer.CatalogWarning, error) {
+	data, err := db.LoadCatalogData()
+	if err != nil {
+		return nil, err
+	}
+	return data.Warnings(), nil
+}
+
+// logCatalogWarnings logs the inconsistent data in the catalog of db
+func logCatalogWarnings(db *bouncer.DB) {
+	warnings, err := catalogWarnings(db)
+	if err != nil {
+		log.Printf("Could not check catalog: %v", err)
+		return
+	}
+	for _, warning := range warnings {
+		log.Printf("Catalog warning: %s", warning)
+	}
+}
+
+// ValidateCatalog compares a candidate catalog against the live one
+func ValidateCatalog(c *cli.Context) {
+	checksFile, err := os.Open(c.String("checks"))
+	if err != nil {
+		log.Fatalf("Could not open checks: %v", err)
+	}
+	defer checksFile.Close()
+
+	checks, err := readCatalogChecks(checksFile)
+	if err != nil {
+		log.Fatalf("Could not read checks: %v", err)
+	}
+
+	liveDB, err := bouncer.NewDB(c.GlobalString("db-dsn"))
+	if err != nil {
+		log.Fatalf("Could not open live DB: %v", err)
+	}
+	defer liveDB.Close()
+
+	candidateDB, err := bouncer.NewDB(c.String("candidate-dsn"))
+	if err != nil {
+		log.Fatalf("Could not open candidate DB: %v", err)
+	}
+	defer candidateDB.Close()
+
+	warnings, err := catalogWarnings(candidateDB)
+	if err != nil {
+		log.Fatalf("Could not check candidate catalog: %v", err)
+	}
+	for _, warning := range warnings {
+		fmt.Printf("warning: %s\n", warning)
+	}
+
+	diffs, err := diffCatalogs(newCatalogCheckHandler(liveDB), newCatalogCheckHandler(candidateDB), checks)
+	if err != nil {
+		log.Fatalf("Could not validate catalog: %v", err)
+	}
+
+	for _, diff := range diffs {
+		fmt.Println(diff)
+	}
+	fmt.Printf("%d checks, %d differences\n", len(checks), len(diffs))
+	if len(diffs) > 0 {
+		os.Exit(1)
+	}
+}
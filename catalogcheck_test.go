@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffCatalogs(t *testing.T) {
+	live := newCatalogCheckHandler(bouncerHandler.db)
+	candidate := newCatalogCheckHandler(bouncerHandler.db)
+	candidate.ProductPathPrefixes = map[string]string{"firefox-ssl": "/legacy"}
+
+	checks := []CatalogCheck{
+		{Product: "firefox-latest", OS: "osx", Lang: "en-US"},
+		{Product: "firefox-ssl", OS: "osx", Lang: "en-US"},
+		{Product: "firefox-latest", OS: "win64", Lang: "en-US", Expected: "http://mirror.invalid/firefox/releases/40.0/win64/en-US/Firefox%20Setup%2040.0.exe"},
+		{Product: "firefox-latest", OS: "win64", Lang: "en-US", Expected: "http://mirror.invalid/firefox/releases/39.0/win64/en-US/Firefox%20Setup%2039.0.exe"},
+	}
+
+	diffs, err := diffCatalogs(live, candidate, checks)
+	assert.NoError(t, err)
+	if assert.Len(t, diffs, 2) {
+		assert.Equal(t, checks[1], diffs[0].Check)
+		assert.Equal(t, "https://mirror.invalid/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", diffs[0].Live)
+		assert.Equal(t, "https://mirror.invalid/legacy/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", diffs[0].Candidate)
+		assert.Equal(t, "product=firefox-ssl os=osx lang=en-US: live https://mirror.invalid/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg, candidate https://mirror.invalid/legacy/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", diffs[0].String())
+
+		assert.Equal(t, checks[2], diffs[1].Check)
+		assert.Equal(t, "http://mirror.invalid/firefox/releases/39.0/win64/en-US/Firefox%20Setup%2039.0.exe", diffs[1].Candidate)
+	}
+}
+
+func TestReadCatalogChecks(t *testing.T) {
+	checks, err := readCatalogChecks(strings.NewReader(`[{"product": "firefox-latest", "os": "osx"}]`))
+	assert.NoError(t, err)
+	assert.Equal(t, []CatalogCheck{{Product: "firefox-latest", OS: "osx", Lang: "en-US"}}, checks)
+
+	_, err = readCatalogChecks(strings.NewReader(`{`))
+	assert.Error(t, err)
+}
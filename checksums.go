@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/mozilla-services/go-bouncer/bouncer"
+)
+
+// ChecksumEntry is the product a file with a known checksum belongs to
+type ChecksumEntry struct {
+	Product string `json:"product"`
+	Version string `json:"version,omitempty"`
+	OS      string `json:"os"`
+	Lang    string `json:"lang,omitempty"`
+	Path    string `json:"path"`
+}
+
+// ChecksumIndex maps the lowercase sha256 or sha512 of a file to its
+// product
+type ChecksumIndex map[string]ChecksumEntry
+
+// checksumPath returns path unescaped and without its leading slash, so
+// location paths and checksum file paths compare equal
+func checksumPath(path string) string {
+	if unescaped, err := url.PathUnescape(path); err == nil {
+		path = unescaped
+	}
+	return strings.TrimPrefix(path, "/")
+}
+
+// matchLocation returns the lang of path if it is a file of the location
+// path, where :lang matches any single path segment
+func matchLocation(location, path string) (lang string, ok bool) {
+	locationParts := strings.Split(checksumPath(location), "/")
+	pathParts := strings.Split(path, "/")
+	if len(locationParts) != len(pathParts) {
+		return "", false
+	}
+
+	for i, part := range locationParts {
+		if at := strings.Index(part, ":lang"); at >= 0 {
+			prefix, suffix := part[:at], part[at+len(":lang"):]
+			p := pathParts[i]
+			if len(p) <= len(prefix)+len(suffix) || !strings.HasPrefix(p, prefix) || !strings.HasSuffix(p, suffix) {
+				return "", false
+			}
+			lang = p[len(prefix) : len(p)-len(suffix)]
+			continue
+		}
+		if part != pathParts[i] {
+			return "", false
+		}
+	}
+	return lang, true
+}
+
+// BuildChecksumIndex indexes the files of a checksums source which belong
+// to a location in data. The source is in the format of sha256sum or
+// sha512sum, or both, one "<hash>  <path>" line per file, with paths
+// relative to the mirror base url, e.g.:
+// 1b2c...  firefox/releases/39.0/mac/en-US/Firefox 39.0.dmg
+// Files not in the catalog are skipped.
+func BuildChecksumIndex(data *bouncer.CatalogData, sums io.Reader) (ChecksumIndex, error) {
+	products := make(map[string]string, len(data.Products))
+	for _, p := range data.Products {
+		products[p.ID] = p.Name
+	}
+
+	index := make(ChecksumIndex)
+	scanner := bufio.NewScanner(sums)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 || hashType(fields[0]) == "" {
+			return nil, fmt.Errorf("line %d: expected <sha256>  <path> or <sha512>  <path>", lineNum)
+		}
+		hash := strings.ToLower(fields[0])
+		// sha256sum marks binary mode paths with a *
+		path := checksumPath(strings.TrimPrefix(strings.TrimLeft(fields[1], " "), "*"))
+
+		for _, l := range data.Locations {
+			product, ok := products[l.ProductID]
+			if !ok {
+				continue
+			}
+			lang, ok := matchLocation(l.Path, path)
+			if !ok {
+				continue
+			}
+			index[hash] = ChecksumEntry{
+				Product: product,
+				Version: productVersion(product),
+				OS:      l.OS,
+				Lang:    lang,
+				Path:    "/" + path,
+			}
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// ChecksumHandler serves the product of the file with the sha256 or sha512
+// param as json
+type ChecksumHandler struct {
+	Index ChecksumIndex
+}
+
+func (h *ChecksumHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	hash := strings.ToLower(req.URL.Query().Get(ChecksumSHA256))
+	if hash == "" {
+		hash = strings.ToLower(req.URL.Query().Get(ChecksumSHA512))
+	}
+	if hash == "" {
+		errorResponse(w, req, http.StatusBadRequest, ErrorCodeBadRequest, "sha256 or sha512 is required.")
+		return
+	}
+
+	entry, ok := h.Index[hash]
+	if !ok {
+		errorResponse(w, req, http.StatusNotFound, ErrorCodeNotFound, "Not Found.")
+		return
+	}
+
+	res, err := json.Marshal(&entry)
+	if err != nil {
+		errorResponse(w, req, http.StatusInternalServerError, ErrorCodeInternal, "Internal Server Error.")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
+
+// Checksum types of the checksum param
+const (
+	ChecksumSHA256 = "sha256"
+	ChecksumSHA512 = "sha512"
+)
+
+// DefaultChecksumType is the checksum type of requests without a checksum
+// param, or with an unknown one
+const DefaultChecksumType = ChecksumSHA256
+
+// hashType returns the checksum type of a hex hash by its length, or "" if
+// it isn't a sha256 or sha512
+func hashType(hash string) string {
+	switch len(hash) {
+	case 64:
+		return ChecksumSHA256
+	case 128:
+		return ChecksumSHA512
+	}
+	return ""
+}
+
+// fileChecksum is a checksum type of the file of a path
+type fileChecksum struct {
+	Path, Type string
+}
+
+// FileChecksums maps the path and checksum type of a file to its checksum
+type FileChecksums map[fileChecksum]string
+
+// FileChecksums returns the checksums of the files of the index by path
+func (index ChecksumIndex) FileChecksums() FileChecksums {
+	checksums := make(FileChecksums, len(index))
+	for hash, entry := range index {
+		checksums[fileChecksum{entry.Path, hashType(hash)}] = hash
+	}
+	return checksums
+}
+
+// bundleChecksum is the checksum of a bundle product's file
+type bundleChecksum struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// checksumType returns the checksum type of a checksum param
+func checksumType(param string) string {
+	switch param := strings.TrimSpace(strings.ToLower(param)); param {
+	case ChecksumSHA256, ChecksumSHA512:
+		return param
+	}
+	return DefaultChecksumType
+}
+
+// checksum returns the checksum of a type of the file res resolved to, or
+// nil if it isn't in Checksums
+func (b *BouncerHandler) checksum(res *resolution, checksumType string) *bundleChecksum {
+	path := "/" + checksumPath(strings.Replace(res.LocationPath, ":lang", res.Lang, -1))
+	value, ok := b.Checksums[fileChecksum{path, checksumType}]
+	if !ok {
+		return nil
+	}
+	return &bundleChecksum{Type: checksumType, Value: value}
+}
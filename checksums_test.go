@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mozilla-services/go-bouncer/bouncer"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testMacSHA256   = "a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f90"
+	testWinSHA256   = "0000000000000000000000000000000000000000000000000000000000000001"
+	testOtherSHA256 = "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"
+)
+
+var testMacSHA512 = strings.Repeat("ab", 64)
+
+var testChecksumCatalog = &bouncer.CatalogData{
+	Products: []bouncer.CatalogProduct{
+		{ID: "1", Name: "Firefox", Langs: 2},
+		{ID: "3", Name: "Firefox-43.0.1-SSL", SSLOnly: true, Langs: 2},
+	},
+	Locations: []bouncer.CatalogLocation{
+		{ProductID: "1", OS: "osx", Path: "/firefox/releases/39.0/mac/:lang/Firefox%2039.0.dmg"},
+		{ProductID: "3", OS: "win", Path: "/firefox/releases/43.0.1/win32/:lang/Firefox%20Setup%2043.0.1.exe"},
+	},
+}
+
+var testChecksums = testMacSHA256 + "  firefox/releases/39.0/mac/en-US/Firefox 39.0.dmg\n" +
+	testMacSHA512 + "  firefox/releases/39.0/mac/en-US/Firefox 39.0.dmg\n" +
+	strings.ToUpper(testWinSHA256) + " */firefox/releases/43.0.1/win32/en-GB/Firefox Setup 43.0.1.exe\n" +
+	"\n" +
+	testOtherSHA256 + "  firefox/releases/39.0/SHA256SUMS\n"
+
+func TestBuildChecksumIndex(t *testing.T) {
+	index, err := BuildChecksumIndex(testChecksumCatalog, strings.NewReader(testChecksums))
+	assert.NoError(t, err)
+	assert.Len(t, index, 3)
+	assert.Equal(t, ChecksumEntry{
+		Product: "Firefox",
+		OS:      "osx",
+		Lang:    "en-US",
+		Path:    "/firefox/releases/39.0/mac/en-US/Firefox 39.0.dmg",
+	}, index[testMacSHA256])
+	assert.Equal(t, ChecksumEntry{
+		Product: "Firefox-43.0.1-SSL",
+		Version: "43.0.1",
+		OS:      "win",
+		Lang:    "en-GB",
+		Path:    "/firefox/releases/43.0.1/win32/en-GB/Firefox Setup 43.0.1.exe",
+	}, index[testWinSHA256])
+	assert.Equal(t, index[testMacSHA256], index[testMacSHA512])
+
+	assert.Equal(t, FileChecksums{
+		{"/firefox/releases/39.0/mac/en-US/Firefox 39.0.dmg", ChecksumSHA256}:             testMacSHA256,
+		{"/firefox/releases/39.0/mac/en-US/Firefox 39.0.dmg", ChecksumSHA512}:             testMacSHA512,
+		{"/firefox/releases/43.0.1/win32/en-GB/Firefox Setup 43.0.1.exe", ChecksumSHA256}: testWinSHA256,
+	}, index.FileChecksums())
+
+	_, err = BuildChecksumIndex(testChecksumCatalog, strings.NewReader("abc  firefox/file\n"))
+	assert.Error(t, err)
+}
+
+func TestChecksumHandler(t *testing.T) {
+	index, err := BuildChecksumIndex(testChecksumCatalog, strings.NewReader(testChecksums))
+	assert.NoError(t, err)
+	handler := &ChecksumHandler{Index: index}
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/__checksum__?sha256="+strings.ToUpper(testMacSHA256), nil)
+	assert.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "application/json", w.HeaderMap.Get("Content-Type"))
+
+	var entry ChecksumEntry
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &entry))
+	assert.Equal(t, "Firefox", entry.Product)
+	assert.Equal(t, "osx", entry.OS)
+	assert.Equal(t, "en-US", entry.Lang)
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "http://test/__checksum__?sha512="+testMacSHA512, nil)
+	assert.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	for _, url := range []string{"http://test/__checksum__?sha256=" + testOtherSHA256, "http://test/__checksum__"} {
+		w = httptest.NewRecorder()
+		req, err = http.NewRequest("GET", url, nil)
+		assert.NoError(t, err)
+		handler.ServeHTTP(w, req)
+		if strings.Contains(url, "sha256") {
+			assert.Equal(t, 404, w.Code, "url: %v", url)
+		} else {
+			assert.Equal(t, 400, w.Code, "url: %v", url)
+		}
+	}
+}
+
+func TestChecksumType(t *testing.T) {
+	assert.Equal(t, ChecksumSHA256, checksumType(""))
+	assert.Equal(t, ChecksumSHA256, checksumType("sha256"))
+	assert.Equal(t, ChecksumSHA512, checksumType(" SHA512"))
+	assert.Equal(t, ChecksumSHA256, checksumType("md5"))
+}
+
+func TestBouncerHandlerBundleChecksum(t *testing.T) {
+	const path = "/firefox/releases/43.0.1/mac/en-US/Firefox 43.0.1.dmg"
+	sha512 := strings.Repeat("5", 128)
+	index := ChecksumIndex{
+		testMacSHA256: {Product: "Firefox-43.0.1-SSL", OS: "osx", Lang: "en-US", Path: path},
+		sha512:        {Product: "Firefox-43.0.1-SSL", OS: "osx", Lang: "en-US", Path: path},
+	}
+	handler := &BouncerHandler{
+		db: memoizedCatalog(),
+		Bundles: map[string][]string{
+			"firefox-suite": {"firefox-sha1", "firefox-latest"},
+		},
+		Checksums: index.FileChecksums(),
+	}
+
+	testRequests := []struct {
+		Checksum         string
+		ExpectedChecksum *bundleChecksum
+	}{
+		{"", &bundleChecksum{Type: ChecksumSHA256, Value: testMacSHA256}},
+		{"sha256", &bundleChecksum{Type: ChecksumSHA256, Value: testMacSHA256}},
+		{"sha512", &bundleChecksum{Type: ChecksumSHA512, Value: sha512}},
+		// unknown types fall back to sha256
+		{"md5", &bundleChecksum{Type: ChecksumSHA256, Value: testMacSHA256}},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://test/?product=firefox-suite&os=osx&lang=en-US&format=json&checksum="+testRequest.Checksum, nil)
+		assert.NoError(t, err)
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code, "checksum: %v", testRequest.Checksum)
+
+		bundle := struct {
+			Products []bundleProduct `json:"products"`
+		}{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &bundle))
+		if assert.Len(t, bundle.Products, 2, "checksum: %v", testRequest.Checksum) {
+			assert.Equal(t, testRequest.ExpectedChecksum, bundle.Products[0].Checksum, "checksum: %v", testRequest.Checksum)
+			// unknown checksums are omitted
+			assert.Nil(t, bundle.Products[1].Checksum, "checksum: %v", testRequest.Checksum)
+		}
+	}
+
+	// without checksums none are listed
+	handler.Checksums = nil
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-suite&os=osx&lang=en-US&format=json&checksum=sha512", nil)
+	assert.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.NotContains(t, w.Body.String(), "checksum")
+}
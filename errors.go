@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrorCode is a stable, machine readable error code returned in json error
+// responses. Clients branch on it, so existing codes must not change.
+type ErrorCode string
+
+const (
+	ErrorCodeBadRequest       ErrorCode = "bad_request"
+	ErrorCodeNotFound         ErrorCode = "not_found"
+	ErrorCodeProductNotFound  ErrorCode = "product_not_found"
+	ErrorCodeOSNotFound       ErrorCode = "os_not_found"
+	ErrorCodeNoMirror         ErrorCode = "no_mirror"
+	ErrorCodeGeoRestricted    ErrorCode = "geo_restricted"
+	ErrorCodeRateLimited      ErrorCode = "rate_limited"
+	ErrorCodeRetired          ErrorCode = "retired"
+	ErrorCodeMethodNotAllowed ErrorCode = "method_not_allowed"
+	ErrorCodeTokenRedeemed    ErrorCode = "token_redeemed"
+	ErrorCodeMaintenance      ErrorCode = "maintenance"
+	ErrorCodeBuilding         ErrorCode = "building"
+	ErrorCodeDraining         ErrorCode = "draining"
+	ErrorCodeTimeout          ErrorCode = "timeout"
+	ErrorCodeInternal         ErrorCode = "internal_error"
+)
+
+// DefaultRetryAfter is the Retry-After of 503 responses by cause. Rate
+// limited clients may retry soon, maintenance lasts a while.
+var DefaultRetryAfter = map[ErrorCode]time.Duration{
+	ErrorCodeRateLimited: 1 * time.Second,
+	ErrorCodeTimeout:     5 * time.Second,
+	ErrorCodeDraining:    30 * time.Second,
+	ErrorCodeBuilding:    1 * time.Minute,
+	ErrorCodeMaintenance: 5 * time.Minute,
+}
+
+// resolveError is returned when a request can't be resolved to a url
+type resolveError struct {
+	Code ErrorCode
+	// Reason distinguishes the causes of a code, for explaining 404s
+	Reason string
+	// AvailableOS are the oses the product has builds for, if the reason
+	// is ReasonOSNotAvailable
+	AvailableOS []string
+}
+
+func (e *resolveError) Error() string {
+	return "unresolved: " + string(e.Code)
+}
+
+// isResolveError returns true if err is a request that can't be resolved,
+// as opposed to a failure while resolving it
+func isResolveError(err error) bool {
+	_, ok := err.(*resolveError)
+	return ok
+}
+
+// errorBody is the json body of an error response
+type errorBody struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+	// Reason and AvailableOS are only set for products without a build
+	// for the requested os
+	Reason      string   `json:"reason,omitempty"`
+	AvailableOS []string `json:"available_os,omitempty"`
+}
+
+// wantsJSON returns true if the client asked for a json response
+func wantsJSON(req *http.Request) bool {
+	return strings.ToLower(req.URL.Query().Get("format")) == FormatJSON ||
+		strings.Contains(req.Header.Get("Accept"), "application/json")
+}
+
+// errorResponse responds with an error. Clients that want json get the
+// error code and message as json, other clients get the message as text.
+func errorResponse(w http.ResponseWriter, req *http.Request, status int, code ErrorCode, message string) {
+	if !wantsJSON(req) {
+		http.Error(w, message, status)
+		return
+	}
+
+	writeErrorBody(w, status, &errorBody{Code: code, Message: message})
+}
+
+// osNotAvailableResponse responds with a 404 for a product without a build
+// for the requested os. Clients that want json also get the oses the
+// product has builds for, so they can offer alternatives.
+func osNotAvailableResponse(w http.ResponseWriter, req *http.Request, availableOS []string) {
+	const message = "404 page not found"
+	if !wantsJSON(req) {
+		http.Error(w, message, http.StatusNotFound)
+		return
+	}
+
+	writeErrorBody(w, http.StatusNotFound, &errorBody{
+		Code:        ErrorCodeNotFound,
+		Message:     message,
+		Reason:      ReasonOSNotAvailable,
+		AvailableOS: availableOS,
+	})
+}
+
+// writeErrorBody writes body as the json error response
+func writeErrorBody(w http.ResponseWriter, status int, body *errorBody) {
+	res, err := json.Marshal(body)
+	if err != nil {
+		log.Printf("errorResponse err: %v", err)
+		http.Error(w, body.Message, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(res)
+}
+
+// unavailableResponse responds with a 503 telling the client to retry after
+// retryAfter, rounded up to a second. Retry-After is omitted if retryAfter
+// is 0.
+func unavailableResponse(w http.ResponseWriter, req *http.Request, code ErrorCode, retryAfter time.Duration) {
+	setRetryAfter(w, retryAfter)
+	errorResponse(w, req, http.StatusServiceUnavailable, code, "Service Unavailable.")
+}
+
+// setRetryAfter sets the Retry-After header to retryAfter, rounded up to a
+// second, unless it is 0
+func setRetryAfter(w http.ResponseWriter, retryAfter time.Duration) {
+	if retryAfter > 0 {
+		seconds := int((retryAfter + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mozilla-services/go-bouncer/bouncer"
+	"github.com/stretchr/testify/assert"
+)
+
+// noMirrorsCatalog is a catalog without any mirrors
+type noMirrorsCatalog struct {
+	Catalog
+}
+
+func (n *noMirrorsCatalog) Mirrors(sslOnly bool) ([]bouncer.MirrorsResult, error) {
+	return []bouncer.MirrorsResult{}, nil
+}
+
+func TestErrorResponseCodes(t *testing.T) {
+	testRequests := []struct {
+		Handler      *BouncerHandler
+		URL          string
+		ExpectedCode int
+		ExpectedErr  ErrorCode
+	}{
+		{bouncerHandler, "http://test/?product=firefox-unknown&os=osx&lang=en-US&format=json", 404, ErrorCodeProductNotFound},
+		{bouncerHandler, "http://test/?product=firefox-latest&os=osx&lang=xx-YY&format=json", 404, ErrorCodeProductNotFound},
+		{bouncerHandler, "http://test/?product=firefox-latest&os=beos&lang=en-US&format=json", 404, ErrorCodeOSNotFound},
+		{&BouncerHandler{db: &noMirrorsCatalog{bouncerHandler.db}}, "http://test/?product=firefox-latest&os=osx&lang=en-US&format=json", 404, ErrorCodeNoMirror},
+		{&BouncerHandler{db: &failingMirrorsCatalog{bouncerHandler.db}}, "http://test/?product=firefox-latest&os=osx&lang=en-US&format=json", 500, ErrorCodeInternal},
+		{&BouncerHandler{db: bouncerHandler.db, EmptyProductPolicy: EmptyProductBadRequest}, "http://test/?os=osx&format=json", 400, ErrorCodeBadRequest},
+		{&BouncerHandler{db: bouncerHandler.db, Maintenance: &Maintenance{enabled: 1}}, "http://test/?product=firefox-latest&format=json", 503, ErrorCodeMaintenance},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+
+		testRequest.Handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedCode, w.Code, "url: %v", testRequest.URL)
+		assert.Equal(t, "application/json", w.HeaderMap.Get("Content-Type"), "url: %v", testRequest.URL)
+
+		body := &errorBody{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), body), "url: %v", testRequest.URL)
+		assert.Equal(t, testRequest.ExpectedErr, body.Code, "url: %v", testRequest.URL)
+	}
+}
+
+func TestErrorResponseText(t *testing.T) {
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-unknown&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+	bouncerHandler.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+	assert.Equal(t, "404 page not found\n", w.Body.String())
+
+	// Accept: application/json also gets json
+	w = httptest.NewRecorder()
+	req.Header.Set("Accept", "application/json")
+	bouncerHandler.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+	assert.Equal(t, `{"code":"product_not_found","message":"404 page not found"}`, w.Body.String())
+}
+
+func TestErrorResponseAvailableOS(t *testing.T) {
+	handler := &BouncerHandler{db: &winOnlyCatalog{bouncerHandler.db}}
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=win64&lang=en-US&format=json", nil)
+	assert.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+	assert.Equal(t, "application/json", w.HeaderMap.Get("Content-Type"))
+	assert.Equal(t, `{"code":"not_found","message":"404 page not found","reason":"os_not_available","available_os":["osx","win"]}`, w.Body.String())
+
+	// other clients get text
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "http://test/?product=firefox-latest&os=win64&lang=en-US", nil)
+	assert.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+	assert.Equal(t, "404 page not found\n", w.Body.String())
+
+	// unknown oses don't list the available ones
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "http://test/?product=firefox-latest&os=beos&lang=en-US&format=json", nil)
+	assert.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, `{"code":"os_not_found","message":"404 page not found"}`, w.Body.String())
+}
+
+func TestServiceUnavailableRetryAfter(t *testing.T) {
+	handler := &BouncerHandler{
+		db:         bouncerHandler.db,
+		RetryAfter: map[ErrorCode]time.Duration{ErrorCodeDraining: 10 * time.Second, ErrorCodeTimeout: 0},
+	}
+
+	testCauses := []struct {
+		Code               ErrorCode
+		ExpectedRetryAfter string
+	}{
+		{ErrorCodeRateLimited, "1"},
+		{ErrorCodeTimeout, ""},
+		{ErrorCodeDraining, "10"},
+		{ErrorCodeMaintenance, "300"},
+	}
+
+	for _, testCause := range testCauses {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://test/?product=firefox-latest", nil)
+		assert.NoError(t, err)
+
+		handler.serviceUnavailable(w, req, testCause.Code)
+		assert.Equal(t, 503, w.Code, "cause: %v", testCause.Code)
+		assert.Equal(t, testCause.ExpectedRetryAfter, w.HeaderMap.Get("Retry-After"), "cause: %v", testCause.Code)
+	}
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-latest", nil)
+	assert.NoError(t, err)
+	unavailableResponse(w, req, ErrorCodeTimeout, 1500*time.Millisecond)
+	assert.Equal(t, "2", w.HeaderMap.Get("Retry-After"))
+
+	w = httptest.NewRecorder()
+	(&BouncerHandler{db: bouncerHandler.db, Maintenance: &Maintenance{enabled: 1}}).ServeHTTP(w, req)
+	assert.Equal(t, 503, w.Code)
+	assert.Equal(t, "300", w.HeaderMap.Get("Retry-After"))
+}
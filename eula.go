@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strings"
+)
+
+// EULATokenParam is the param carrying a client's EULA acceptance token.
+// The token is the hex encoded HMAC-SHA256 of the requested product with
+// EULAKey.
+const EULATokenParam = "eula_token"
+
+// EULAToken returns the acceptance token of the EULA of a requested product
+func EULAToken(key []byte, product string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strings.ToLower(product)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// eulaURL returns the EULA page a request for product must be redirected to
+// before downloading, or "" if the product has no EULA or the token is
+// valid. EULAProducts is checked against the product an alias resolves to.
+func (b *BouncerHandler) eulaURL(reqParams *BouncerParams) (string, error) {
+	if len(b.EULAProducts) == 0 {
+		return "", nil
+	}
+	product, err := b.catalogProduct(reqParams.Product)
+	if err != nil {
+		return "", err
+	}
+	template := productOrFamilyValue(b.EULAProducts, product)
+	if template == "" {
+		return "", nil
+	}
+
+	if token, err := hex.DecodeString(reqParams.EULAToken); err == nil && len(b.EULAKey) > 0 {
+		expected, _ := hex.DecodeString(EULAToken(b.EULAKey, reqParams.Product))
+		if hmac.Equal(expected, token) {
+			return "", nil
+		}
+	}
+
+	replacer := strings.NewReplacer(
+		"{product}", url.QueryEscape(reqParams.Product),
+		"{os}", url.QueryEscape(reqParams.OS),
+		"{lang}", url.QueryEscape(reqParams.Lang),
+	)
+	return replacer.Replace(template), nil
+}
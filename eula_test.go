@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBouncerHandlerEULA(t *testing.T) {
+	key := []byte("eula-key")
+	handler := &BouncerHandler{
+		db:           bouncerHandler.db,
+		EULAProducts: map[string]string{"firefox-43.0.1-ssl": "https://eula.example.com/?product={product}&os={os}&lang={lang}"},
+		EULAKey:      key,
+	}
+
+	const download = "https://download-installer.cdn.mozilla.net/pub/firefox/releases/43.0.1/mac/en-US/Firefox%2043.0.1.dmg"
+	const eula = "https://eula.example.com/?product=firefox-sha1&os=osx&lang=en-US"
+	base := "http://test/?product=firefox-sha1&os=osx&lang=en-US"
+
+	testRequests := []struct {
+		URL              string
+		ExpectedLocation string
+	}{
+		{base, eula},
+		{base + "&eula_token=" + EULAToken(key, "firefox-sha1"), download},
+		{base + "&eula_token=" + EULAToken([]byte("other-key"), "firefox-sha1"), eula},
+		{base + "&eula_token=" + EULAToken(key, "firefox-latest"), eula},
+		{base + "&eula_token=nothex", eula},
+		{"http://test/?product=firefox-latest&os=osx&lang=en-US", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, 302, w.Code, "url: %v", testRequest.URL)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v", testRequest.URL)
+	}
+}
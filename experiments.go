@@ -0,0 +1,77 @@
+package main
+
+import (
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// experimentKey returns the key a request is bucketed into experiments by:
+// the client ip, so a client gets the same features across requests, or
+// the trace id of requests without one
+func experimentKey(req *http.Request) string {
+	if host := remoteHost(req); host != "" {
+		return host
+	}
+	return traceID(req)
+}
+
+// inExperiment returns true if key is in the first percentage of the
+// buckets of feature. Each feature buckets keys independently.
+func inExperiment(feature, key string, percentage float64) bool {
+	if percentage <= 0 || key == "" {
+		return false
+	}
+	if percentage >= 100 {
+		return true
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(feature))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	return float64(h.Sum64())/(1<<64)*100 < percentage
+}
+
+// withExperiments returns a copy of b with the Experiments enabled for req.
+// b is returned if there are no experiments.
+func (b *BouncerHandler) withExperiments(req *http.Request) *BouncerHandler {
+	if len(b.Experiments) == 0 {
+		return b
+	}
+
+	key := experimentKey(req)
+	enabled := []string{}
+	for feature, percentage := range b.Experiments {
+		if inExperiment(feature, key, percentage) {
+			enabled = append(enabled, feature)
+		}
+	}
+	sort.Strings(enabled)
+
+	eb := *b
+	eb.experiments = enabled
+	return &eb
+}
+
+// isExperiment returns true if feature is rolled out by Experiments, whether
+// or not it is enabled for the request
+func (b *BouncerHandler) isExperiment(feature string) bool {
+	for f := range b.Experiments {
+		if strings.EqualFold(f, feature) {
+			return true
+		}
+	}
+	return false
+}
+
+// experimentEnabled returns true if feature is enabled for the request
+func (b *BouncerHandler) experimentEnabled(feature string) bool {
+	for _, f := range b.experiments {
+		if strings.EqualFold(f, feature) {
+			return true
+		}
+	}
+	return false
+}
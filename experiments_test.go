@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInExperiment(t *testing.T) {
+	assert.False(t, inExperiment("signing", "10.0.0.1", 0))
+	assert.True(t, inExperiment("signing", "10.0.0.1", 100))
+	assert.False(t, inExperiment("signing", "", 50))
+
+	// keys stay in or out of an experiment
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("10.0.0.%d", i)
+		assert.Equal(t, inExperiment("signing", key, 50), inExperiment("signing", key, 50), "key: %v", key)
+		// raising the percentage keeps the keys already enabled
+		if inExperiment("signing", key, 10) {
+			assert.True(t, inExperiment("signing", key, 20), "key: %v", key)
+		}
+	}
+
+	for _, percentage := range []float64{1, 10, 50, 90} {
+		enabled := 0
+		const keys = 20000
+		for i := 0; i < keys; i++ {
+			if inExperiment("signing", fmt.Sprintf("10.%d.%d.1", i/256, i%256), percentage) {
+				enabled++
+			}
+		}
+		assert.InDelta(t, percentage, float64(enabled)/keys*100, 1, "percentage: %v", percentage)
+	}
+}
+
+func TestBouncerHandlerExperiments(t *testing.T) {
+	testRequests := []struct {
+		Experiments          map[string]float64
+		ExpectedLocation     string
+		ExpectedCacheControl string
+	}{
+		// responses gated by an experiment aren't shared
+		{map[string]float64{"experiment": 100}, "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", "private, no-store"},
+		{map[string]float64{"experiment": 0}, "", "private, no-store"},
+		{map[string]float64{"other": 100}, "", ""},
+		{nil, "", ""},
+	}
+
+	for _, testRequest := range testRequests {
+		handler := &BouncerHandler{
+			db:                  bouncerHandler.db,
+			ProductFeatureFlags: map[string]string{"firefox-ssl": "experiment"},
+			Experiments:         testRequest.Experiments,
+			CacheTime:           time.Minute,
+		}
+
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://test/?product=firefox-ssl&os=osx&lang=en-US", nil)
+		assert.NoError(t, err)
+		req.RemoteAddr = "10.0.0.1:1234"
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "experiments: %v", testRequest.Experiments)
+		assert.Equal(t, testRequest.ExpectedCacheControl, w.HeaderMap.Get("Cache-Control"), "experiments: %v", testRequest.Experiments)
+	}
+}
+
+func TestBouncerHandlerExperimentsStable(t *testing.T) {
+	out := &bytes.Buffer{}
+	handler := &BouncerHandler{
+		db:                  memoizedCatalog(),
+		ProductFeatureFlags: map[string]string{"firefox-ssl": "experiment"},
+		Experiments:         map[string]float64{"experiment": 50},
+		AccessLog:           &AccessLogger{Format: AccessLogFormatJSON, Output: out},
+	}
+
+	served := 0
+	const clients = 40
+	for i := 0; i < clients; i++ {
+		remoteAddr := fmt.Sprintf("10.0.%d.1:1234", i)
+		var codes []int
+		for j := 0; j < 2; j++ {
+			out.Reset()
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "http://test/?product=firefox-ssl&os=osx&lang=en-US", nil)
+			assert.NoError(t, err)
+			req.RemoteAddr = remoteAddr
+			handler.ServeHTTP(w, req)
+			codes = append(codes, w.Code)
+
+			// enabled experiments are logged
+			line := struct {
+				Fields map[string]interface{}
+			}{}
+			assert.NoError(t, json.Unmarshal(out.Bytes(), &line), "remote addr: %v", remoteAddr)
+			if w.Code == 302 {
+				assert.Equal(t, "experiment", line.Fields["experiments"], "remote addr: %v", remoteAddr)
+			} else {
+				assert.Nil(t, line.Fields["experiments"], "remote addr: %v", remoteAddr)
+			}
+		}
+		assert.Equal(t, codes[0], codes[1], "remote addr: %v", remoteAddr)
+		if codes[0] == 302 {
+			served++
+		}
+	}
+	// roughly half the clients are served
+	assert.InDelta(t, clients/2, served, clients/4)
+}
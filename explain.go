@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log"
+
+	"github.com/mozilla-services/go-bouncer/mozlog"
+)
+
+// Reasons a request 404s, logged by explainNotFound
+const (
+	ReasonUnknownProduct      = "unknown_product"
+	ReasonLangNotAvailable    = "lang_not_available"
+	ReasonUnknownOS           = "unknown_os"
+	ReasonOSNotAvailable      = "os_not_available"
+	ReasonNoMirror            = "no_mirror"
+	ReasonFeatureFlagRequired = "feature_flag_required"
+	ReasonNoURL               = "no_url"
+)
+
+// productNotFound returns the error of a product which isn't in the catalog
+// in the requested lang, telling unknown products apart from products
+// missing the lang
+func (b *BouncerHandler) productNotFound(product string) error {
+	exists, err := b.db.ProductExists(product)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return &resolveError{Code: ErrorCodeProductNotFound, Reason: ReasonLangNotAvailable}
+	}
+	return &resolveError{Code: ErrorCodeProductNotFound, Reason: ReasonUnknownProduct}
+}
+
+// osNotAvailable returns the error of a product which has no build for the
+// requested os, listing the oses it has builds for
+func (b *BouncerHandler) osNotAvailable(productID string) error {
+	locations, err := b.db.ProductLocations(productID)
+	if err != nil {
+		return err
+	}
+	oses := make([]string, 0, len(locations))
+	for _, location := range locations {
+		oses = append(oses, location.OS)
+	}
+	return &resolveError{Code: ErrorCodeNotFound, Reason: ReasonOSNotAvailable, AvailableOS: oses}
+}
+
+// explainNotFound logs why a request 404s to ExplainLog
+func (b *BouncerHandler) explainNotFound(reqParams *BouncerParams, code ErrorCode, reason string) {
+	if b.ExplainLog == nil {
+		return
+	}
+
+	appLog := mozlog.NewAppLog("Bouncer", nil)
+	appLog.Type = "resolution.explained"
+	appLog.Fields = map[string]interface{}{
+		"product": reqParams.Product,
+		"os":      reqParams.OS,
+		"lang":    reqParams.Lang,
+		"code":    string(code),
+		"reason":  reason,
+	}
+
+	line, err := appLog.ToJSON()
+	if err != nil {
+		log.Printf("explainNotFound err: %v", err)
+		return
+	}
+	if _, err := b.ExplainLog.Write(append(line, '\n')); err != nil {
+		log.Printf("explainNotFound err: %v", err)
+	}
+}
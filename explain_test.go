@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mozilla-services/go-bouncer/mozlog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBouncerHandlerExplainNotFound(t *testing.T) {
+	testRequests := []struct {
+		URL            string
+		ExpectedCode   ErrorCode
+		ExpectedReason string
+	}{
+		{"http://test/?product=bogus&os=osx&lang=en-US", ErrorCodeProductNotFound, ReasonUnknownProduct},
+		{"http://test/?product=firefox-latest&os=osx&lang=fr", ErrorCodeProductNotFound, ReasonLangNotAvailable},
+		{"http://test/?product=firefox-latest&os=bogus&lang=en-US", ErrorCodeOSNotFound, ReasonUnknownOS},
+	}
+
+	for _, testRequest := range testRequests {
+		out := new(bytes.Buffer)
+		handler := &BouncerHandler{
+			db:         bouncerHandler.db,
+			ExplainLog: out,
+		}
+
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, 404, w.Code, "url: %v", testRequest.URL)
+
+		var logEntry mozlog.AppLog
+		if assert.NoError(t, json.Unmarshal(out.Bytes(), &logEntry), "url: %v", testRequest.URL) {
+			assert.Equal(t, "resolution.explained", logEntry.Type)
+			assert.Equal(t, string(testRequest.ExpectedCode), logEntry.Fields["code"], "url: %v", testRequest.URL)
+			assert.Equal(t, testRequest.ExpectedReason, logEntry.Fields["reason"], "url: %v", testRequest.URL)
+		}
+	}
+}
+
+func TestBouncerHandlerExplainNotFoundOnlyOn404(t *testing.T) {
+	out := new(bytes.Buffer)
+	handler := &BouncerHandler{
+		db:         bouncerHandler.db,
+		ExplainLog: out,
+	}
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 302, w.Code)
+	assert.Equal(t, "", out.String())
+}
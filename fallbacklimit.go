@@ -0,0 +1,27 @@
+package main
+
+// withFallbackLimit returns a copy of b counting the fallback attempts of a
+// request against MaxFallbackAttempts. b is returned if there is no limit.
+func (b *BouncerHandler) withFallbackLimit() *BouncerHandler {
+	if b.MaxFallbackAttempts <= 0 {
+		return b
+	}
+	fb := *b
+	fb.fallbackAttempts = new(int)
+	return &fb
+}
+
+// fallbackAllowed counts a fallback attempt of the request. It returns
+// false once the request has made MaxFallbackAttempts attempts, and the
+// product is then not found.
+func (b *BouncerHandler) fallbackAllowed() bool {
+	if b.fallbackAttempts == nil {
+		return true
+	}
+	if *b.fallbackAttempts >= b.MaxFallbackAttempts {
+		b.incr("fallback.limit")
+		return false
+	}
+	*b.fallbackAttempts++
+	return true
+}
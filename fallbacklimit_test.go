@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBouncerHandlerMaxFallbackAttempts(t *testing.T) {
+	// win64 falls back through many oses the catalog doesn't have to win
+	var chain []string
+	for i := 0; i < 50; i++ {
+		chain = append(chain, fmt.Sprintf("os%d", i))
+	}
+	chain = append(chain, "win")
+
+	testRequests := []struct {
+		MaxFallbackAttempts int
+		URL                 string
+		ExpectedLocation    string
+	}{
+		{0, "http://test/?product=firefox-latest&os=win64&lang=en-US", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win32/en-US/Firefox%20Setup%2039.0.exe"},
+		{51, "http://test/?product=firefox-latest&os=win64&lang=en-US", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win32/en-US/Firefox%20Setup%2039.0.exe"},
+		{50, "http://test/?product=firefox-latest&os=win64&lang=en-US", ""},
+		{5, "http://test/?product=firefox-latest&os=win64&lang=en-US", ""},
+		// requests without fallbacks are served
+		{1, "http://test/?product=firefox-latest&os=osx&lang=en-US", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		// lang fallbacks count too
+		{1, "http://test/?product=firefox-latest&os=osx&lang=en-AU", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{1, "http://test/?product=firefox-latest&os=osx&lang=en-NZ", ""},
+		{2, "http://test/?product=firefox-latest&os=osx&lang=en-NZ", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-GB/Firefox%2039.0.dmg"},
+		{2, "http://test/?product=firefox-latest&os=win64&lang=en-AU", ""},
+	}
+
+	catalog := &winOnlyCatalog{memoizedCatalog()}
+	for _, testRequest := range testRequests {
+		handler := &BouncerHandler{
+			db:                  catalog,
+			OSFallback:          map[string][]string{"win64": chain},
+			LocaleFallbackTable: map[string]string{"en-au": "en-US", "en-nz": "en"},
+			MaxFallbackAttempts: testRequest.MaxFallbackAttempts,
+		}
+
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v max: %v", testRequest.URL, testRequest.MaxFallbackAttempts)
+		if testRequest.ExpectedLocation == "" {
+			assert.Equal(t, 404, w.Code, "url: %v max: %v", testRequest.URL, testRequest.MaxFallbackAttempts)
+		}
+	}
+}
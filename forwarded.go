@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parseForwarded returns the client ip and proto of an RFC 7239 Forwarded
+// header. With several hops, the first element is the client's. Either is
+// empty if not given, obfuscated or malformed.
+func parseForwarded(header string) (ip, proto string) {
+	first := strings.SplitN(header, ",", 2)[0]
+	for _, pair := range strings.Split(first, ";") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		value, ok := forwardedValue(kv[1])
+		if !ok {
+			continue
+		}
+
+		switch strings.ToLower(kv[0]) {
+		case "for":
+			ip = forwardedIP(value)
+		case "proto":
+			proto = strings.ToLower(value)
+			if proto != "http" && proto != "https" {
+				proto = ""
+			}
+		}
+	}
+	return ip, proto
+}
+
+// forwardedValue returns a token or quoted string value unquoted
+func forwardedValue(value string) (string, bool) {
+	if !strings.HasPrefix(value, `"`) {
+		return value, !strings.ContainsAny(value, `"[]`) && value != ""
+	}
+	if len(value) < 2 || !strings.HasSuffix(value, `"`) {
+		return "", false
+	}
+	return strings.Replace(value[1:len(value)-1], `\"`, `"`, -1), true
+}
+
+// forwardedIP returns the ip of a for= node, which may have a port and, for
+// ipv6, is in brackets. Obfuscated and unknown nodes have no ip.
+func forwardedIP(node string) string {
+	host := node
+	if strings.HasPrefix(node, "[") {
+		end := strings.Index(node, "]")
+		if end < 0 {
+			return ""
+		}
+		host = node[1:end]
+	} else if h, _, err := net.SplitHostPort(node); err == nil {
+		host = h
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// withForwarded returns req with the client ip of its Forwarded header as
+// its RemoteAddr, if the handler trusts the header
+func (b *BouncerHandler) withForwarded(req *http.Request) *http.Request {
+	if !b.TrustForwarded {
+		return req
+	}
+	ip, _ := parseForwarded(req.Header.Get("Forwarded"))
+	if ip == "" {
+		return req
+	}
+	fr := *req
+	fr.RemoteAddr = ip
+	return &fr
+}
+
+// forwardedProto returns the client proto of the Forwarded header of req,
+// if the handler trusts the header
+func (b *BouncerHandler) forwardedProto(req *http.Request) string {
+	if !b.TrustForwarded {
+		return ""
+	}
+	_, proto := parseForwarded(req.Header.Get("Forwarded"))
+	return proto
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseForwarded(t *testing.T) {
+	tests := []struct {
+		Header string
+		IP     string
+		Proto  string
+	}{
+		{"for=192.0.2.60;proto=https;by=203.0.113.43", "192.0.2.60", "https"},
+		{"For=\"192.0.2.60:4711\";Proto=HTTP", "192.0.2.60", "http"},
+		{"for=\"[2001:db8:cafe::17]:4711\";proto=https", "2001:db8:cafe::17", "https"},
+		{"for=192.0.2.43, for=198.51.100.17;proto=http", "192.0.2.43", ""},
+		{"for=192.0.2.43;proto=https, for=\"[2001:db8:cafe::17]\";proto=http", "192.0.2.43", "https"},
+		{"proto=https", "", "https"},
+		{"for=unknown;proto=https", "", "https"},
+		{"for=_hidden", "", ""},
+		{"", "", ""},
+		{"for", "", ""},
+		{"for=;proto=", "", ""},
+		{"for=\"192.0.2.60;proto=https", "", "https"},
+		{"for=[2001:db8:cafe::17]", "", ""},
+		{"for=192.0.2.60;proto=gopher", "192.0.2.60", ""},
+		{"garbage;;,,", "", ""},
+	}
+
+	for _, test := range tests {
+		ip, proto := parseForwarded(test.Header)
+		assert.Equal(t, test.IP, ip, "header: %q", test.Header)
+		assert.Equal(t, test.Proto, proto, "header: %q", test.Header)
+	}
+}
+
+func TestBouncerHandlerTrustForwarded(t *testing.T) {
+	const httpLocation = "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"
+	const httpsLocation = "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"
+
+	tests := []struct {
+		TrustForwarded   bool
+		Forwarded        string
+		XForwardedProto  string
+		ExpectedLocation string
+	}{
+		{true, "for=192.0.2.60;proto=https", "", httpsLocation},
+		{true, "for=192.0.2.60;proto=http", "https", httpLocation},
+		{true, "for=192.0.2.60", "https", httpsLocation},
+		{true, "", "https", httpsLocation},
+		{false, "for=192.0.2.60;proto=https", "", httpLocation},
+		{false, "for=192.0.2.60;proto=http", "https", httpsLocation},
+	}
+
+	for _, test := range tests {
+		handler := &BouncerHandler{
+			db:                 bouncerHandler.db,
+			PinHttpsHeaderName: "X-Forwarded-Proto",
+			TrustForwarded:     test.TrustForwarded,
+		}
+
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US", nil)
+		assert.NoError(t, err)
+		req.Header.Set("Forwarded", test.Forwarded)
+		req.Header.Set("X-Forwarded-Proto", test.XForwardedProto)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, 302, w.Code)
+		assert.Equal(t, test.ExpectedLocation, w.HeaderMap.Get("Location"), "test: %+v", test)
+	}
+}
+
+func TestBouncerHandlerTrustForwardedClientIP(t *testing.T) {
+	_, trusted, err := net.ParseCIDR("192.0.2.0/24")
+	assert.NoError(t, err)
+
+	for _, trustForwarded := range []bool{true, false} {
+		handler := &BouncerHandler{
+			db:             bouncerHandler.db,
+			CacheTime:      60 * time.Second,
+			TrustedCIDRs:   []*net.IPNet{trusted},
+			TrustForwarded: trustForwarded,
+		}
+
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US", nil)
+		assert.NoError(t, err)
+		req.RemoteAddr = "10.0.0.1:54321"
+		req.Header.Set("Forwarded", "for=192.0.2.60")
+		req.Header.Set(CacheTimeHeaderName, "5")
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, 302, w.Code)
+		if trustForwarded {
+			assert.Equal(t, "max-age=5", w.HeaderMap.Get("Cache-Control"))
+		} else {
+			assert.Equal(t, "max-age=60", w.HeaderMap.Get("Cache-Control"))
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultGzipMinSize is the size, in bytes, responses must reach to be
+// compressed if GzipHandler doesn't set MinSize. Smaller responses fit in a
+// packet anyway.
+const DefaultGzipMinSize = 1400
+
+// GzipHandler gzips the responses of Handler which reach MinSize bytes, for
+// clients accepting gzip. Smaller responses are sent as is, since
+// compressing them costs more than it saves.
+type GzipHandler struct {
+	Handler http.Handler
+	MinSize int
+}
+
+func (g *GzipHandler) minSize() int {
+	if g.MinSize <= 0 {
+		return DefaultGzipMinSize
+	}
+	return g.MinSize
+}
+
+func (g *GzipHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	if req.Method == "HEAD" || !acceptsGzip(req.Header.Get("Accept-Encoding")) {
+		g.Handler.ServeHTTP(w, req)
+		return
+	}
+
+	gw := &gzipResponseWriter{ResponseWriter: w, minSize: g.minSize(), status: http.StatusOK}
+	defer gw.close()
+	g.Handler.ServeHTTP(gw, req)
+}
+
+// acceptsGzip returns true if an Accept-Encoding header accepts gzip
+func acceptsGzip(header string) bool {
+	for _, encoding := range strings.Split(header, ",") {
+		parts := strings.Split(encoding, ";")
+		if strings.ToLower(strings.TrimSpace(parts[0])) != "gzip" {
+			continue
+		}
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter buffers a response until it reaches minSize bytes, and
+// gzips it from then on. Shorter responses are written as is on close.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minSize int
+
+	status      int
+	wroteHeader bool
+	buf         []byte
+	gz          *gzip.Writer
+	passThrough bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = status
+	// Bodiless and already encoded responses are never compressed
+	if status < 200 || status == http.StatusNoContent || status == http.StatusNotModified || w.Header().Get("Content-Encoding") != "" {
+		w.passThrough = true
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.passThrough {
+		return w.ResponseWriter.Write(p)
+	}
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+
+	w.buf = append(w.buf, p...)
+	if len(w.buf) < w.minSize {
+		return len(p), nil
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.status)
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+	buf := w.buf
+	w.buf = nil
+	if _, err := w.gz.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// close flushes the gzip stream, or writes the buffered response as is if
+// it never reached minSize
+func (w *gzipResponseWriter) close() {
+	switch {
+	case w.passThrough:
+	case w.gz != nil:
+		w.gz.Close()
+	default:
+		w.ResponseWriter.WriteHeader(w.status)
+		if len(w.buf) > 0 {
+			w.ResponseWriter.Write(w.buf)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGzipHandler(t *testing.T) {
+	small := strings.Repeat("a", 99)
+	large := strings.Repeat("a", 100)
+	handler := &GzipHandler{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTeapot)
+			body := small
+			if req.URL.Query().Get("size") == "large" {
+				body = large
+			}
+			// written in pieces, so the threshold is crossed mid response
+			for _, c := range body {
+				w.Write([]byte(string(c)))
+			}
+		}),
+		MinSize: 100,
+	}
+
+	testRequests := []struct {
+		URL            string
+		AcceptEncoding string
+		ExpectedGzip   bool
+		ExpectedBody   string
+	}{
+		{"http://test/?size=small", "gzip", false, small},
+		{"http://test/?size=large", "gzip", true, large},
+		{"http://test/?size=large", "deflate, GZIP;q=0.5", true, large},
+		{"http://test/?size=large", "gzip;q=0", false, large},
+		{"http://test/?size=large", "", false, large},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err)
+		req.Header.Set("Accept-Encoding", testRequest.AcceptEncoding)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusTeapot, w.Code, "url: %v encoding: %v", testRequest.URL, testRequest.AcceptEncoding)
+		assert.Equal(t, "application/json", w.HeaderMap.Get("Content-Type"))
+		assert.Equal(t, "Accept-Encoding", w.HeaderMap.Get("Vary"))
+
+		body := w.Body.String()
+		if testRequest.ExpectedGzip {
+			assert.Equal(t, "gzip", w.HeaderMap.Get("Content-Encoding"), "url: %v encoding: %v", testRequest.URL, testRequest.AcceptEncoding)
+			gz, err := gzip.NewReader(w.Body)
+			assert.NoError(t, err)
+			decompressed, err := ioutil.ReadAll(gz)
+			assert.NoError(t, err)
+			body = string(decompressed)
+		} else {
+			assert.Equal(t, "", w.HeaderMap.Get("Content-Encoding"), "url: %v encoding: %v", testRequest.URL, testRequest.AcceptEncoding)
+		}
+		assert.Equal(t, testRequest.ExpectedBody, body, "url: %v encoding: %v", testRequest.URL, testRequest.AcceptEncoding)
+	}
+}
+
+func TestGzipHandlerRedirect(t *testing.T) {
+	handler := &GzipHandler{Handler: bouncerHandler}
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 302, w.Code)
+	assert.Equal(t, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", w.HeaderMap.Get("Location"))
+	assert.Equal(t, "", w.HeaderMap.Get("Content-Encoding"))
+}
@@ -3,9 +3,12 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
 	"regexp"
@@ -19,8 +22,78 @@ import (
 
 const DefaultLang = "en-US"
 const DefaultOS = "win"
+const DefaultOSToken = "default"
+const AllOSToken = "all"
 const firefoxSHA1ESRAliasSuffix = "sha1"
 
+// DefaultSHA1AliasSuffixes are the suffixes of legacy sha1 signed aliases,
+// such as firefox-sha1, used if the handler doesn't set SHA1AliasSuffixes
+var DefaultSHA1AliasSuffixes = []string{"sha1"}
+
+// EmptyProductPolicy is how requests without a product are handled. A
+// request without a product is checked before any other param, so its os,
+// lang etc. are ignored.
+type EmptyProductPolicy string
+
+const (
+	// EmptyProductRedirect redirects to www.mozilla.org
+	EmptyProductRedirect EmptyProductPolicy = "redirect"
+	// EmptyProductBadRequest returns 400 if the request has other params,
+	// which is a client bug. Requests without any params are redirected.
+	EmptyProductBadRequest EmptyProductPolicy = "badrequest"
+)
+
+// SchemeMismatchPolicy is how requests are served when no mirror serves the
+// scheme they are pinned to, but one serves the other scheme. Ssl only
+// products are never served over http, whatever the policy.
+type SchemeMismatchPolicy string
+
+const (
+	// SchemeMismatchNoMirror returns 404 no_mirror
+	SchemeMismatchNoMirror SchemeMismatchPolicy = "no_mirror"
+	// SchemeMismatchServe serves a mirror of the other scheme. Serving http
+	// to https pinned requests is logged as a warning.
+	SchemeMismatchServe SchemeMismatchPolicy = "serve"
+	// SchemeMismatchError returns 500
+	SchemeMismatchError SchemeMismatchPolicy = "error"
+)
+
+const (
+	// FormatTorrent is the format param value requesting a product's torrent
+	FormatTorrent = "torrent"
+	// FormatJSON is the format param value requesting a json response
+	FormatJSON = "json"
+)
+
+// Arch32Token is the arch param of clients explicitly asking for 32-bit
+// builds
+const Arch32Token = "x86"
+
+// DefaultTorrentPathTemplate derives a torrent path from an installer path
+const DefaultTorrentPathTemplate = "{path}.torrent"
+
+// installers large enough to be offered as torrents
+var torrentInstallerSuffixes = []string{".dmg", ".exe", ".msi", ".tar.bz2", ".tar.xz"}
+
+// ArchiveAllVersions is the ArchiveProducts version of products served from
+// the archive regardless of their version
+const ArchiveAllVersions = "*"
+
+// FeatureFlagsHeaderName and FeatureFlagsCookieName carry the comma separated
+// feature flags of a client
+const (
+	FeatureFlagsHeaderName = "X-Bouncer-Flags"
+	FeatureFlagsCookieName = "bouncer_flags"
+)
+
+// DefaultContentSecurityPolicy forbids the html body of redirects from
+// loading or running anything
+const DefaultContentSecurityPolicy = "default-src 'none'; base-uri 'none'; form-action 'none'; frame-ancestors 'none'"
+
+// CacheTimeHeaderName is the header trusted clients can send to override the
+// Cache-Control max-age of a response, in seconds
+const CacheTimeHeaderName = "X-Bouncer-Cache-Time"
+
 type xpRelease struct {
 	Version string
 }
@@ -38,6 +111,39 @@ var deprecatedOSXRegex = regexp.MustCompile(`^Mozilla/5.0 \(Macintosh; Intel Mac
 var deprecatedOSXPkgProduct = "firefox-esr-next-pkg-latest-ssl"
 var deprecatedOSXDmgProduct = "firefox-esr-next-latest-ssl"
 
+// detects the platform of a client for os inference
+var windowsRegex = regexp.MustCompile(`Windows`)
+var windows64Regex = regexp.MustCompile(`Win64|WOW64`)
+var macRegex = regexp.MustCompile(`Macintosh|Mac OS X`)
+var linuxRegex = regexp.MustCompile(`Linux`)
+var linux64Regex = regexp.MustCompile(`x86_64|amd64`)
+var androidRegex = regexp.MustCompile(`Android`)
+
+// matches the version in a product name, e.g. 43.0.1 in Firefox-43.0.1-SSL
+var productVersionRegex = regexp.MustCompile(`-(\d+\.\d+(?:\.\d+)*(?:[ab]\d+|esr)?)`)
+
+// matches the release version in a location path, e.g. 39.0 in
+// /firefox/releases/39.0/mac/:lang/Firefox 39.0.dmg
+var locationVersionRegex = regexp.MustCompile(`/releases/(\d+\.\d+(?:\.\d+)*(?:[ab]\d+|esr)?)/`)
+
+// matches the build number of a release candidate, e.g. build1 in firefox-48.0build1
+var buildNumberRegex = regexp.MustCompile(`(\d)build\d+`)
+
+// matches the parts of a locale, e.g. pt and br in pt-BR
+var localeLanguageRegex = regexp.MustCompile(`^[a-z]{2,3}$`)
+var localeRegionRegex = regexp.MustCompile(`^[a-z]{2}$`)
+
+// product name suffixes that look like locales but aren't
+var nonLocaleProductSuffixes = map[string]bool{
+	"dmg": true,
+	"esr": true,
+	"exe": true,
+	"msi": true,
+	"pkg": true,
+	"rc":  true,
+	"ssl": true,
+}
+
 var tBirdWinXPLastRelease = xpRelease{"38.5.0"}
 var tBirdWinXPLastBeta = xpRelease{"43.0b1"}
 
@@ -49,6 +155,26 @@ func isWindowsXPUserAgent(userAgent string) bool {
 	return windowsXPRegex.MatchString(userAgent)
 }
 
+// osFromUserAgent returns the bouncer os name for a user agent, or "" if the
+// platform can't be determined
+func osFromUserAgent(userAgent string) string {
+	switch {
+	case windowsRegex.MatchString(userAgent):
+		if windows64Regex.MatchString(userAgent) {
+			return "win64"
+		}
+		return "win"
+	case macRegex.MatchString(userAgent):
+		return "osx"
+	case linuxRegex.MatchString(userAgent) && !androidRegex.MatchString(userAgent):
+		if linux64Regex.MatchString(userAgent) {
+			return "linux64"
+		}
+		return "linux"
+	}
+	return ""
+}
+
 func isNotNumber(r rune) bool {
 	return !unicode.IsNumber(r)
 }
@@ -88,18 +214,78 @@ func compareVersions(a, b string) int {
 	return 0
 }
 
+// productVersion returns the version embedded in a product name, or "" if the
+// product has none
+func productVersion(product string) string {
+	match := productVersionRegex.FindStringSubmatch(product)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// locationVersion returns the release version in a location path, or "" if
+// the path has none
+func locationVersion(path string) string {
+	match := locationVersionRegex.FindStringSubmatch(path)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// stripBuildNumber removes the build number from a product name, e.g.
+// firefox-48.0build1-ssl becomes firefox-48.0-ssl
+func stripBuildNumber(product string) string {
+	return buildNumberRegex.ReplaceAllString(product, "$1")
+}
+
+// release channels named in product names
+var productChannels = map[string]bool{
+	"aurora":     true,
+	"beta":       true,
+	"devedition": true,
+	"esr":        true,
+	"nightly":    true,
+	"release":    true,
+}
+
+// splitProductLocale splits a trailing locale off a product name, e.g.
+// firefox-48.0-pt-br becomes firefox-48.0 and pt-BR
+// if the locale is == "", the product has no trailing locale
+func splitProductLocale(product string) (string, string) {
+	parts := strings.Split(product, "-")
+	isLanguage := func(s string) bool {
+		return localeLanguageRegex.MatchString(s) && !nonLocaleProductSuffixes[s]
+	}
+
+	if n := len(parts); n >= 3 && isLanguage(parts[n-2]) && localeRegionRegex.MatchString(parts[n-1]) && !nonLocaleProductSuffixes[parts[n-1]] {
+		return strings.Join(parts[:n-2], "-"), parts[n-2] + "-" + strings.ToUpper(parts[n-1])
+	}
+	if n := len(parts); n >= 2 && isLanguage(parts[n-1]) {
+		return strings.Join(parts[:n-1], "-"), parts[n-1]
+	}
+	return product, ""
+}
+
+// tBirdSha1Product returns the last Thunderbird product signed with sha1
+// for the suffix of a Thunderbird product. Thunderbird releases are built
+// from ESR, so the esr aliases get the last release.
 func tBirdSha1Product(productSuffix string) string {
 	switch productSuffix {
 	case "beta", "beta-latest":
 		return tBirdWinXPLastBeta.Version
-	case "ssl":
-		return tBirdWinXPLastRelease.Version + "-ssl"
-	case "latest":
+	case "beta-ssl", "beta-latest-ssl":
+		return tBirdWinXPLastBeta.Version + "-ssl"
+	case "latest", "esr", "esr-latest":
 		return tBirdWinXPLastRelease.Version
+	case "ssl", "latest-ssl", "esr-ssl", "esr-latest-ssl":
+		return tBirdWinXPLastRelease.Version + "-ssl"
 	}
 
 	productSuffixParts := strings.SplitN(productSuffix, "-", 2)
-	ver := productSuffixParts[0]
+	// build numbers would otherwise look like betas, e.g. 38.6.0build1
+	ver := stripBuildNumber(productSuffixParts[0])
 
 	possibleVersion := tBirdWinXPLastRelease
 	if strings.Contains(ver, ".0b") {
@@ -121,7 +307,10 @@ func tBirdSha1Product(productSuffix string) string {
 	return productSuffix
 }
 
-func firefoxSha1Product(productSuffix string) string {
+// firefoxSha1Product returns the sha1 signed alias for the suffix of a
+// Firefox product. Products ending with one of aliasSuffixes are already
+// sha1 signed and returned as is.
+func firefoxSha1Product(productSuffix string, aliasSuffixes []string) string {
 	// Example list of products:
 	// Firefox-48.0-Complete
 	// Firefox-48.0build1-Complete
@@ -145,9 +334,12 @@ func firefoxSha1Product(productSuffix string) string {
 	// firefox-sha1
 	// Firefox-stub
 
-	// Do not touch products ending with "sha1"
-	if strings.HasSuffix(productSuffix, "-sha1") {
-		return productSuffix
+	// Do not touch products ending with a sha1 alias suffix, e.g. "sha1"
+	for _, suffix := range aliasSuffixes {
+		suffix = strings.ToLower(suffix)
+		if productSuffix == suffix || strings.HasSuffix(productSuffix, "-"+suffix) {
+			return productSuffix
+		}
 	}
 
 	// Do not touch completes and partials
@@ -158,13 +350,19 @@ func firefoxSha1Product(productSuffix string) string {
 }
 
 func sha1Product(product string) string {
+	return sha1ProductWithSuffixes(product, DefaultSHA1AliasSuffixes)
+}
+
+// sha1ProductWithSuffixes is sha1Product, leaving firefox products ending
+// with one of aliasSuffixes as is
+func sha1ProductWithSuffixes(product string, aliasSuffixes []string) string {
 	productParts := strings.SplitN(product, "-", 2)
 	if len(productParts) == 1 {
 		return product
 	}
 
 	if productParts[0] == "firefox" {
-		return "firefox-" + firefoxSha1Product(productParts[1])
+		return "firefox-" + firefoxSha1Product(productParts[1], aliasSuffixes)
 	}
 
 	if productParts[0] == "thunderbird" {
@@ -184,11 +382,104 @@ func osxEsrProduct(product string) string {
 	return product
 }
 
+// DefaultDependencyTimeout is the time a dependency has to respond to a
+// health check
+const DefaultDependencyTimeout = 2 * time.Second
+
 // HealthResult represents service health
 type HealthResult struct {
-	DB      bool   `json:"db"`
-	Healthy bool   `json:"healthy"`
-	Version string `json:"version"`
+	DB      bool          `json:"db"`
+	Healthy bool          `json:"healthy"`
+	Version string        `json:"version"`
+	Detail  *HealthDetail `json:"detail,omitempty"`
+
+	// Dependencies is the state of each checked dependency, by name
+	Dependencies map[string]DependencyHealth `json:"dependencies"`
+}
+
+// DependencyHealth is the state of a dependency
+type DependencyHealth struct {
+	Healthy  bool `json:"healthy"`
+	Required bool `json:"required"`
+	// LatencyMS is the time the check took, in milliseconds
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+// DependencyCheck checks a dependency of bouncer. Bouncer is unhealthy if a
+// required dependency is, optional dependencies are only reported.
+type DependencyCheck struct {
+	Name     string
+	Required bool
+	Check    func() error
+}
+
+// run returns the state of the dependency
+func (d DependencyCheck) run() DependencyHealth {
+	start := time.Now()
+	err := d.Check()
+	latency := time.Since(start)
+	if err != nil {
+		log.Printf("HealthHandler %s err: %v", d.Name, err)
+	}
+	return DependencyHealth{
+		Healthy:   err == nil,
+		Required:  d.Required,
+		LatencyMS: float64(latency) / float64(time.Millisecond),
+	}
+}
+
+// URLCheck returns a check that url is reachable: it responds to a HEAD
+// request without a server error
+func URLCheck(client *http.Client, url string) func() error {
+	return func() error {
+		resp, err := client.Head(url)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 500 {
+			return fmt.Errorf("%s returned %d", url, resp.StatusCode)
+		}
+		return nil
+	}
+}
+
+// MirrorsCheck returns a check that db has a healthy mirror
+func MirrorsCheck(db HealthSource) func() error {
+	return func() error {
+		count, err := db.HealthyMirrorCount()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return errors.New("no healthy mirrors")
+		}
+		return nil
+	}
+}
+
+// HealthDetail is the state of each subsystem, returned for ?detail=1
+type HealthDetail struct {
+	DB      DBHealth      `json:"db"`
+	Catalog CatalogHealth `json:"catalog"`
+	Mirrors MirrorsHealth `json:"mirrors"`
+}
+
+// DBHealth is the state of the database connection
+type DBHealth struct {
+	Healthy bool `json:"healthy"`
+	// LatencyMS is the time the ping took, in milliseconds
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+// CatalogHealth is the state of the product catalog
+type CatalogHealth struct {
+	Products int `json:"products"`
+}
+
+// MirrorsHealth is the state of the mirrors
+type MirrorsHealth struct {
+	Healthy int `json:"healthy"`
 }
 
 // JSON returns json string
@@ -201,26 +492,81 @@ func (h *HealthResult) JSON() []byte {
 	return res
 }
 
+// HealthSource is the state checked by HealthHandler. *bouncer.DB is a
+// HealthSource.
+type HealthSource interface {
+	Ping() error
+	ProductCount() (int, error)
+	HealthyMirrorCount() (int, error)
+}
+
 // HealthHandler returns 200 if the app looks okay
 type HealthHandler struct {
-	db *bouncer.DB
+	db HealthSource
 
 	CacheTime time.Duration
+
+	// Dependencies are checked, and reported, besides the db, which is
+	// always a required dependency
+	Dependencies []DependencyCheck
 }
 
-func (h *HealthHandler) check() *HealthResult {
+func (h *HealthHandler) check(detail bool) *HealthResult {
 	result := &HealthResult{
 		DB:      true,
 		Healthy: true,
 		Version: bouncer.Version,
 	}
 
+	start := time.Now()
 	err := h.db.Ping()
+	latency := time.Since(start)
 	if err != nil {
 		result.DB = false
 		result.Healthy = false
 		log.Printf("HealthHandler err: %v", err)
 	}
+
+	result.Dependencies = map[string]DependencyHealth{
+		"db": {
+			Healthy:   result.DB,
+			Required:  true,
+			LatencyMS: float64(latency) / float64(time.Millisecond),
+		},
+	}
+	for _, dependency := range h.Dependencies {
+		health := dependency.run()
+		if dependency.Required && !health.Healthy {
+			result.Healthy = false
+		}
+		result.Dependencies[dependency.Name] = health
+	}
+
+	if !detail {
+		return result
+	}
+
+	result.Detail = &HealthDetail{
+		DB: DBHealth{
+			Healthy:   result.DB,
+			LatencyMS: float64(latency) / float64(time.Millisecond),
+		},
+	}
+	if !result.DB {
+		return result
+	}
+
+	result.Detail.Catalog.Products, err = h.db.ProductCount()
+	if err != nil {
+		result.Healthy = false
+		log.Printf("HealthHandler err: %v", err)
+	}
+
+	result.Detail.Mirrors.Healthy, err = h.db.HealthyMirrorCount()
+	if err != nil {
+		result.Healthy = false
+		log.Printf("HealthHandler err: %v", err)
+	}
 	return result
 }
 
@@ -231,36 +577,443 @@ func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 
-	result := h.check()
+	result := h.check(req.URL.Query().Get("detail") == "1")
 	if !result.Healthy {
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 	w.Write(result.JSON())
 }
 
+// DisallowAllRobotsTxt is the default /robots.txt body
+const DisallowAllRobotsTxt = "User-agent: *\nDisallow: /\n"
+
+// RobotsHandler serves /robots.txt
+type RobotsHandler struct {
+	// Body is served as is. If empty, DisallowAllRobotsTxt is served.
+	Body string
+
+	CacheTime time.Duration
+}
+
+func (h *RobotsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if h.CacheTime > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", h.CacheTime/time.Second))
+	}
+
+	body := h.Body
+	if body == "" {
+		body = DisallowAllRobotsTxt
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(body))
+}
+
+// FaviconHandler serves /favicon.ico
+type FaviconHandler struct {
+	// RedirectURL is the favicon requests are redirected to. If empty,
+	// 204 No Content is returned.
+	RedirectURL string
+
+	CacheTime time.Duration
+}
+
+func (h *FaviconHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if h.CacheTime > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", h.CacheTime/time.Second))
+	}
+
+	if h.RedirectURL == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	http.Redirect(w, req, h.RedirectURL, 302)
+}
+
+// Catalog is the product catalog the bouncer handler resolves requests
+// against. *bouncer.DB is a Catalog.
+type Catalog interface {
+	AliasFor(product string) (string, error)
+	OSID(name string) (string, error)
+	ProductForLanguage(product, lang string) (productID string, sslOnly bool, language string, err error)
+	Location(productID, osID string) (id, path string, err error)
+	ProductLocations(productID string) ([]*bouncer.ProductLocationsResult, error)
+	ProductRegions(product, language string) ([]string, error)
+	Mirrors(sslOnly bool) ([]bouncer.MirrorsResult, error)
+	ProductExists(product string) (bool, error)
+}
+
 // BouncerHandler is the primary handler for this application
 type BouncerHandler struct {
-	db *bouncer.DB
+	db Catalog
 
 	CacheTime          time.Duration
 	PinHttpsHeaderName string
 	PinnedBaseURLHttp  string
 	PinnedBaseURLHttps string
 	StubRootURL        string
+	InferOS            bool
+	ClientOSHeaderName string
+
+	// CleanOSLang trims trailing dots and drops empty segments of the os and
+	// lang of requests, e.g. win. and en--US, before they are looked up
+	CleanOSLang bool
+
+	// OSVersionNames maps the name of an os qualified with its version,
+	// e.g. macos for macos13, to the catalog os it is looked up as.
+	// DefaultOSVersionNames is used if nil.
+	OSVersionNames map[string]string
+
+	// UniversalOS maps a product or product family to the os of its cross
+	// platform installer, served instead of DefaultOS to clients whose os
+	// can't be determined
+	UniversalOS map[string]string
+
+	// OSFallback maps an os to the oses, in order, served when a product
+	// isn't available on it, e.g. win64 to win
+	OSFallback map[string][]string
+
+	// MaxFallbackAttempts caps the lang and os fallbacks, e.g. of
+	// LocaleFallbackTable and OSFallback, tried for a request, bounding
+	// the lookups of long fallback chains. Requests needing more 404.
+	// Zero is no cap.
+	MaxFallbackAttempts int
+	// fallbackAttempts counts the fallbacks tried on the copy of the
+	// handler serving a request
+	fallbackAttempts *int
+
+	// UpgradeWin64 serves win64 builds to 64-bit Windows clients asking
+	// for os=win, unless they ask for arch=x86
+	UpgradeWin64 bool
+
+	LicenseURLTemplate string
+	ParseProductLocale bool
+	AccessLog          *AccessLogger
+	Maintenance        *Maintenance
+	// StubSwitch turns the stub installer off for every product while it
+	// is off. May be nil.
+	StubSwitch *StubSwitch
+
+	// EULAProducts maps a product, or a product family, to the url
+	// template of the EULA page its clients must accept before
+	// downloading. {product}, {os} and {lang} are replaced with the
+	// request's. Requests with a valid EULATokenParam signed with EULAKey
+	// are served the download.
+	EULAProducts map[string]string
+	EULAKey      []byte
+
+	// AttributionEndpoints maps a product, or a product family, to the
+	// url of the campaign endpoint requests for it with an attribution
+	// code and signature are redirected to, on any os, with the same
+	// params as StubRootURL, which they take precedence over
+	AttributionEndpoints map[string]string
+
+	// OneTimeProducts are the products, or product families, served with
+	// one-time urls: the redirect is to RedeemPath, which redirects to the
+	// resolved url once within OneTimeTTL. Their urls are stored in
+	// OneTimeTokens, without which no product is served one-time.
+	OneTimeProducts []string
+	OneTimeTokens   TokenStore
+	OneTimeTTL      time.Duration
+
+	// LangLandingURL is the url template of the page requests for a lang
+	// the product isn't available in are redirected to, instead of 404ing,
+	// e.g. a localized page choosing another download. {product}, {os} and
+	// {lang} are replaced with the request's.
+	LangLandingURL string
+
+	// AliasCache, if set, caches what requested products resolve to
+	// across requests. It only caches the default catalog.
+	AliasCache *AliasCache
+	// aliasCacheMissed is set on the copy of the handler serving a request
+	// when one of its alias lookups isn't served by AliasCache
+	aliasCacheMissed *bool
+
+	// ExplainLog receives a mozlog resolution.explained entry for every
+	// request which 404s, with the reason it couldn't be resolved. Nothing
+	// is logged if nil.
+	ExplainLog io.Writer
+
+	// MirrorDecisionLog receives a mozlog mirror.decision entry recording
+	// how the mirror of every request was chosen. Nothing is logged if nil.
+	MirrorDecisionLog io.Writer
+	// DebugToken, if set, gets requests with an Authorization: Bearer
+	// <token> header the mirror decision in MirrorDecisionHeader
+	DebugToken string
+	// mirrorDecisions is set on the copy of the handler serving a request
+	// whose mirror decision is recorded
+	mirrorDecisions *mirrorDecisions
+
+	// ProductMethods maps a product, or a product family such as firefox,
+	// to the | separated methods it is served with, e.g. GET|POST. Other
+	// methods get a 405, and allowed methods other than GET and HEAD are
+	// redirected with a 307. Products without a policy are served with any
+	// method.
+	ProductMethods map[string]string
+
+	// EnableAcceptLanguage serves requests without a lang param the most
+	// preferred lang of their Accept-Language header that the product is
+	// available in, instead of DefaultLang
+	EnableAcceptLanguage bool
+
+	// ESRCycles maps the major version an ESR cycle started at to the
+	// product of that cycle, e.g. 60 to firefox-esr60-latest. Requests for
+	// the latest ESR with a version param get the product of the client's
+	// cycle.
+	ESRCycles map[string]string
+
+	// ProductRewrites are applied, in order, to products before they are
+	// looked up. The first rule matching a product wins.
+	ProductRewrites []*ProductRewrite
+
+	// UARewriteRules rewrite products for matching user agents. The first
+	// matching rule wins, and replaces the built in legacy client rewrites.
+	UARewriteRules []*UARewriteRule
+
+	// ProductRenames maps the old name of a renamed product, or of the
+	// product an alias is for, to its new name
+	ProductRenames map[string]string
+	// CanonicalizeRenames redirects requests for the old name of a renamed
+	// product to the url with its new name, with a 301
+	CanonicalizeRenames bool
+
+	// NormalizeThunderbird looks up Thunderbird products requested by their
+	// channel alone by their ThunderbirdNames, e.g. thunderbird-beta as
+	// thunderbird-beta-latest. DefaultThunderbirdNames is used if
+	// ThunderbirdNames is nil.
+	NormalizeThunderbird bool
+	ThunderbirdNames     map[string]string
+
+	// ProductFeatureFlags maps a product to the feature flag clients must
+	// send to resolve it. Other clients get a 404.
+	ProductFeatureFlags map[string]string
+
+	// Experiments maps a feature to the percentage, from 0 to 100, of
+	// clients it is enabled for, bucketed by a hash of the client ip.
+	// Enabled experiments are access logged, and grant the feature flags
+	// of ProductFeatureFlags of the same name.
+	Experiments map[string]float64
+	// experiments are the Experiments enabled on the copy of the handler
+	// serving a request, sorted
+	experiments []string
+
+	// PreferHTTPS serves https urls to requests without a scheme param
+	PreferHTTPS bool
+	// CanonicalSchemeUpgrade redirects scheme=http requests for products
+	// which aren't ssl only to the https bouncer url without the scheme
+	// param, with a 308, if PreferHTTPS is set
+	CanonicalSchemeUpgrade bool
+	// HTTPSFallback serves requests without a scheme param or pin https
+	// urls of products which aren't ssl only if an https base is available,
+	// and http urls otherwise
+	HTTPSFallback bool
+	// preferHttps is set on the copy of the handler serving a request which
+	// HTTPSFallback applies to
+	preferHttps bool
+	// HTTPSLangs are the langs, or languages such as fa for every fa-*
+	// lang, always served over https, like ssl only products, whatever the
+	// scheme param
+	HTTPSLangs []string
+
+	// CacheBusting honors nocache=1, serving a url with a unique
+	// CacheBustingParam and Cache-Control: no-store
+	CacheBusting bool
+
+	// PrintJSON serves print=yes requests which want json the url as a json
+	// string, instead of text
+	PrintJSON bool
+
+	// RetryAfter overrides the DefaultRetryAfter of 503 responses by cause
+	RetryAfter map[ErrorCode]time.Duration
+
+	// Building are the products whose release is in progress. Requests for
+	// them get a 503 with a {"status": "building"} body rather than a 404.
+	Building *BuildingProducts
+
+	// SunsetDates maps a product, or a product family such as thunderbird,
+	// scheduled to be retired to its retirement date, sent in an RFC 8594
+	// Sunset header. SunsetPolicyURL, if set, is sent as its Link
+	// rel="sunset"; {product} is replaced with the requested product.
+	SunsetDates     map[string]time.Time
+	SunsetPolicyURL string
+
+	// TimingAllowOrigin is the Timing-Allow-Origin header of every response,
+	// letting browsers measure bouncer in the Resource Timing API. Not set
+	// if empty.
+	TimingAllowOrigin string
+
+	// Partners maps a partner id to the catalog of its branded builds,
+	// resolved against instead of the default catalog for requests with
+	// the partner param signed with PartnerKey
+	Partners   map[string]Catalog
+	PartnerKey []byte
+
+	// Staging is the catalog of upcoming changes, resolved against instead
+	// of the live catalog for requests with staging=1 if EnableStaging is
+	// set. Keep EnableStaging off in production.
+	Staging       Catalog
+	EnableStaging bool
+
+	// FallbackCatalogs are resolved against, in priority order, when a
+	// product isn't in the catalog, e.g. the old catalog while products
+	// migrate to a new one. The first catalog with the product wins.
+	FallbackCatalogs []Catalog
+
+	// ContentSecurityPolicy is the Content-Security-Policy header of
+	// redirects, whose html body links to a url built from request
+	// params. DefaultContentSecurityPolicy if empty.
+	ContentSecurityPolicy string
+
+	// ShortCodes maps a c param to the product, os and lang it expands to.
+	// Explicit product, os and lang params take precedence.
+	ShortCodes map[string]ResolveTuple
+
+	// LanguageRegions maps a language to the region preferred when a request's
+	// lang has no region, e.g. fr to FR
+	LanguageRegions map[string]string
+
+	// LocaleFallbackTable maps a lang to the lang served when a product isn't
+	// available in it, e.g. es-AR to es-ES, before falling back to a region
+	// of a language without one
+	LocaleFallbackTable map[string]string
+
+	// Bundles maps a bundle alias to the products it expands to. format=json
+	// requests list every product; other requests use the first one.
+	Bundles map[string][]string
+	// Checksums are the checksums of catalog files, listed in format=json
+	// bundle responses. May be nil.
+	Checksums FileChecksums
+
+	// MirrorFallbackURL is used as the mirror base url when no mirror can be
+	// selected. If empty, such requests fail.
+	MirrorFallbackURL string
+
+	// MirrorPools maps the host a request is sent to, its TLS server name or
+	// Host header, to the hosts of the mirrors it is served from. Requests
+	// for other hosts, or whose pool has no available mirror, are served
+	// from every mirror. Pinned base urls take precedence over pools.
+	MirrorPools map[string][]string
+	// mirrorPool is set on the copy of the handler serving a request to a
+	// MirrorPools host, or with a MirrorRegions region param
+	mirrorPool []string
+	// MirrorRegions maps the known values of the region param to the hosts
+	// of the mirrors requests with it are served from. The region pool takes
+	// precedence over the MirrorPools one.
+	MirrorRegions map[string][]string
+	// StrictRegions returns 400 for requests with a region param not in
+	// MirrorRegions. Otherwise such requests are served as if they had none.
+	StrictRegions bool
+
+	// MirrorLatencies, if set, picks the healthy mirror with the lowest
+	// smoothed latency instead of picking by rating. Mirrors are picked by
+	// rating until a latency is observed.
+	MirrorLatencies *MirrorLatencies
+
+	// RecentErrors records requests that fail to resolve. May be nil.
+	RecentErrors *RecentErrors
+
+	// MissingLocales, if set, counts the requests for langs products aren't
+	// available in, for the missing locales report
+	MissingLocales *MissingLocales
+
+	// Metrics receives counters, e.g.,: mirror fallbacks. May be nil.
+	Metrics Metrics
+
+	// EmptyProductPolicy defaults to EmptyProductRedirect
+	EmptyProductPolicy EmptyProductPolicy
+
+	// HTTPPinMismatch is the policy of http requests when only https mirrors
+	// are available, and HTTPSPinMismatch the policy of https pinned requests
+	// for products which aren't ssl only when only http mirrors are. Both
+	// default to SchemeMismatchNoMirror.
+	HTTPPinMismatch  SchemeMismatchPolicy
+	HTTPSPinMismatch SchemeMismatchPolicy
+
+	// MultipleChoices makes os=all requests that can't be narrowed to one os
+	// return 300 with the url for every os instead of 404ing
+	MultipleChoices bool
+
+	// CanonicalOSKeys presents the oses of os=all choices by their
+	// OSCanonicalNames, e.g. win32 for win, instead of their catalog names.
+	// DefaultOSCanonicalNames is used if OSCanonicalNames is nil.
+	CanonicalOSKeys  bool
+	OSCanonicalNames map[string]string
+
+	// MirrorDefaultSchemes maps a mirror host to the scheme it is served
+	// over when neither the product nor the request requires https
+	MirrorDefaultSchemes map[string]string
+
+	// SHA1RewriteProducts limits the Windows XP sha1 rewrite to these
+	// products. If empty, every product is rewritten.
+	SHA1RewriteProducts []string
+	// SHA1AliasSuffixes are the suffixes of legacy sha1 signed aliases,
+	// e.g. sha1 for firefox-sha1 or firefox-esr-sha1, which are served as
+	// is to Windows XP clients. If nil, DefaultSHA1AliasSuffixes is used.
+	SHA1AliasSuffixes []string
+
+	// ArchiveBaseURL is the base url, without scheme, old builds are served
+	// from, e.g. archive.mozilla.org/pub
+	ArchiveBaseURL string
+	// ArchiveProducts maps a product or product family to the version before
+	// which it is served from ArchiveBaseURL, or to ArchiveAllVersions
+	ArchiveProducts map[string]string
+
+	// XPSupportedUntil maps a product, or a product family such as firefox,
+	// to the last version built for Windows XP. Later versions aren't
+	// rewritten to sha1 signed products.
+	XPSupportedUntil map[string]string
+
+	// Torrents enables format=torrent requests, which redirect to the
+	// torrent of the installer. TorrentPathTemplate is the torrent path;
+	// {path} and {lang} are replaced with the installer path and lang. If
+	// empty, DefaultTorrentPathTemplate is used.
+	Torrents            bool
+	TorrentPathTemplate string
+
+	// TrustedCIDRs are the networks whose requests may override the cache
+	// time with CacheTimeHeaderName
+	TrustedCIDRs []*net.IPNet
+
+	// TrustForwarded takes the client ip and scheme of requests from their
+	// RFC 7239 Forwarded header, over RemoteAddr and PinHttpsHeaderName.
+	// Only set it if a proxy in front of bouncer sets the header.
+	TrustForwarded bool
+
+	// AdminCIDRs are the networks of admin clients, which may also override
+	// the cache time with CacheTimeHeaderName
+	AdminCIDRs []*net.IPNet
+
+	// ProductPathPrefixes maps a product, or a product family such as
+	// thunderbird, to a path prepended to its location paths
+	ProductPathPrefixes map[string]string
 }
 
 func randomMirror(mirrors []bouncer.MirrorsResult) *bouncer.MirrorsResult {
+	return weightedMirror(mirrors, weightRoll(mirrors))
+}
+
+// weightRoll returns a roll between 1 and the total rating of mirrors, or 0
+// if they have no rating
+func weightRoll(mirrors []bouncer.MirrorsResult) int {
 	totalRatings := 0
 	for _, m := range mirrors {
 		totalRatings += m.Rating
 	}
-	for _, m := range mirrors {
-		// Intn(x) returns from [0,x) and we need [1,x], so adding 1
-		rand := rand.Intn(totalRatings) + 1
-		if rand <= m.Rating {
-			return &m
+	if totalRatings <= 0 {
+		return 0
+	}
+	// Intn(x) returns from [0,x) and we need [1,x], so adding 1
+	return rand.Intn(totalRatings) + 1
+}
+
+// weightedMirror returns the mirror whose share of the total rating the roll
+// falls in
+func weightedMirror(mirrors []bouncer.MirrorsResult, roll int) *bouncer.MirrorsResult {
+	for i, m := range mirrors {
+		if roll <= m.Rating {
+			return &mirrors[i]
 		}
-		totalRatings -= m.Rating
+		roll -= m.Rating
 	}
 
 	// This shouldn't happen
@@ -270,112 +1023,910 @@ func randomMirror(mirrors []bouncer.MirrorsResult) *bouncer.MirrorsResult {
 	return &mirrors[0]
 }
 
+// inCIDRs returns true if req comes from one of cidrs
+func inCIDRs(req *http.Request, cidrs []*net.IPNet) bool {
+	ip := net.ParseIP(remoteHost(req))
+	if ip == nil {
+		return false
+	}
+	for _, cidr := range cidrs {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// incr increments a counter, if the handler has metrics
+func (b *BouncerHandler) incr(name string) {
+	if b.Metrics != nil {
+		b.Metrics.Incr(name)
+	}
+}
+
+// incrTagged increments a counter with tags if the handler's metrics
+// support them, and without otherwise
+func (b *BouncerHandler) incrTagged(name string, tags map[string]string) {
+	if m, ok := b.Metrics.(TaggedMetrics); ok {
+		m.IncrTagged(name, tags)
+		return
+	}
+	b.incr(name)
+}
+
+// timing records a timing, if the handler has metrics
+func (b *BouncerHandler) timing(name string, d time.Duration) {
+	if b.Metrics != nil {
+		b.Metrics.Timing(name, d)
+	}
+}
+
+// requestTiming records a timing for req, with the trace id of req as its
+// exemplar if the handler's metrics support exemplars
+func (b *BouncerHandler) requestTiming(req *http.Request, name string, d time.Duration) {
+	if m, ok := b.Metrics.(ExemplarMetrics); ok {
+		m.TimingExemplar(name, d, traceID(req))
+		return
+	}
+	b.timing(name, d)
+}
+
+// normalizeProduct returns the name a requested product is looked up by,
+// with its Thunderbird name normalized and ProductRewrites applied
+func (b *BouncerHandler) normalizeProduct(product string) string {
+	return rewriteProduct(b.ProductRewrites, b.thunderbirdProduct(product))
+}
+
+// aliasFor returns the product an alias refers to, after normalizeProduct
+func (b *BouncerHandler) aliasFor(product string) (string, error) {
+	if b.AliasCache == nil {
+		return b.db.AliasFor(b.normalizeProduct(product))
+	}
+
+	if related, ok := b.AliasCache.Get(product); ok {
+		b.incr("alias_cache.hit")
+		return related, nil
+	}
+	b.incr("alias_cache.miss")
+	if b.aliasCacheMissed != nil {
+		*b.aliasCacheMissed = true
+	}
+
+	related, err := b.db.AliasFor(b.normalizeProduct(product))
+	if err != nil {
+		return "", err
+	}
+	// Only products which are aliased or rewritten are cached, so requests
+	// for made up products don't fill the cache
+	if !strings.EqualFold(related, product) {
+		b.AliasCache.Set(product, related)
+	}
+	return related, nil
+}
+
+// resolution is a request resolved to a mirror url
+type resolution struct {
+	URL string
+	// Lang is the lang used in the url, as it is spelled in the catalog
+	Lang string
+
+	// BaseURL is the url the location path is relative to
+	BaseURL string
+	// LocationPath is the path of the location, before :lang is replaced
+	LocationPath string
+	// SSLOnly is true if the product is only served over https
+	SSLOnly bool
+	// Product is the catalog product the url is of, after aliases and
+	// renames
+	Product string
+	// OS is the os the product was resolved for
+	OS string
+}
+
 // URL returns the final redirect URL given a lang, os and product
 // if the string is == "", no mirror or location was found
 func (b *BouncerHandler) URL(pinHttps bool, lang, os, product string) (string, error) {
-	product, err := b.db.AliasFor(product)
+	res, err := b.resolve(pinHttps, lang, os, product)
+	if isResolveError(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return res.URL, nil
+}
+
+// catalogProduct returns the catalog name of a requested product, after
+// aliases and renames
+func (b *BouncerHandler) catalogProduct(product string) (string, error) {
+	product, err := b.aliasFor(product)
 	if err != nil {
 		return "", err
 	}
+	if renamed, ok := b.ProductRenames[strings.ToLower(product)]; ok {
+		return b.aliasFor(renamed)
+	}
+	return product, nil
+}
+
+// resolve resolves a lang, os and product to a mirror url, against the
+// first of the catalog and FallbackCatalogs the product is in
+// if no mirror or location was found, the error is a *resolveError
+func (b *BouncerHandler) resolve(pinHttps bool, lang, os, product string) (*resolution, error) {
+	res, err := b.resolveCatalog(pinHttps, lang, os, product)
+	for _, catalog := range b.FallbackCatalogs {
+		if resErr, ok := err.(*resolveError); !ok || resErr.Reason != ReasonUnknownProduct {
+			break
+		}
+		fb := *b
+		fb.db = catalog
+		fb.AliasCache = nil
+		res, err = fb.resolveCatalog(pinHttps, lang, os, product)
+	}
+	return res, err
+}
+
+// resolveCatalog resolves a lang, os and product to a mirror url against
+// the catalog
+func (b *BouncerHandler) resolveCatalog(pinHttps bool, lang, os, product string) (*resolution, error) {
+	product, err := b.catalogProduct(product)
+	if err != nil {
+		return nil, err
+	}
 
 	osID, err := b.db.OSID(os)
 	switch {
 	case err == sql.ErrNoRows:
-		return "", nil
+		return nil, &resolveError{Code: ErrorCodeOSNotFound, Reason: ReasonUnknownOS}
 	case err != nil:
-		return "", err
+		return nil, err
 	}
 
-	productID, sslOnly, err := b.db.ProductForLanguage(product, lang)
+	productID, sslOnly, lang, err := b.productForLanguage(product, lang)
 	switch {
 	case err == sql.ErrNoRows:
-		return "", nil
+		return nil, b.productNotFound(product)
 	case err != nil:
-		return "", err
+		return nil, err
 	}
 
 	_, locationPath, err := b.db.Location(productID, osID)
+	if err == sql.ErrNoRows {
+		locationPath, err = b.fallbackLocation(productID, os)
+	}
 	switch {
 	case err == sql.ErrNoRows:
-		return "", nil
+		return nil, b.osNotAvailable(productID)
 	case err != nil:
-		return "", err
+		return nil, err
 	}
 
-	mirrorBaseURL, err := b.mirrorBaseURL(pinHttps || sslOnly)
+	mirrorBaseURL, err := b.archiveBaseURL(pinHttps || sslOnly, product)
+	if mirrorBaseURL == "" {
+		mirrorBaseURL, err = b.schemeMirrorBaseURL(pinHttps, sslOnly)
+	}
 	if err != nil || mirrorBaseURL == "" {
-		return "", err
+		if b.MirrorFallbackURL == "" && err == nil {
+			return nil, &resolveError{Code: ErrorCodeNoMirror, Reason: ReasonNoMirror}
+		}
+		if b.MirrorFallbackURL == "" {
+			return nil, err
+		}
+		if err != nil {
+			log.Printf("Falling back to %s: %v", b.MirrorFallbackURL, err)
+		}
+		b.incr("mirror.fallback")
+		mirrorBaseURL = b.MirrorFallbackURL
 	}
 
-	locationPath = strings.Replace(locationPath, ":lang", lang, -1)
+	// Code signing requires ssl only products to be served over https,
+	// whatever the base url
+	if sslOnly && strings.HasPrefix(mirrorBaseURL, "http://") {
+		mirrorBaseURL = "https://" + strings.TrimPrefix(mirrorBaseURL, "http://")
+	}
 
-	return mirrorBaseURL + locationPath, nil
+	baseURL := mirrorBaseURL + b.pathPrefix(product)
+
+	return &resolution{
+		URL:          baseURL + strings.Replace(locationPath, ":lang", lang, -1),
+		Lang:         lang,
+		BaseURL:      baseURL,
+		LocationPath: locationPath,
+		SSLOnly:      sslOnly,
+		Product:      product,
+		OS:           os,
+	}, nil
 }
 
-func (b *BouncerHandler) mirrorBaseURL(sslOnly bool) (string, error) {
-	if b.PinnedBaseURLHttps != "" && sslOnly {
-		return "https://" + b.PinnedBaseURLHttps, nil
-	}
+// fallbackLocation returns the path of the product on the first of the
+// OSFallback oses of os it is available on, or sql.ErrNoRows
+func (b *BouncerHandler) fallbackLocation(productID, os string) (string, error) {
+	for _, fallback := range b.OSFallback[os] {
+		if !b.fallbackAllowed() {
+			break
+		}
+		osID, err := b.db.OSID(fallback)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
 
-	if b.PinnedBaseURLHttp != "" && !sslOnly {
-		return "http://" + b.PinnedBaseURLHttp, nil
+		_, locationPath, err := b.db.Location(productID, osID)
+		if err != sql.ErrNoRows {
+			return locationPath, err
+		}
 	}
+	return "", sql.ErrNoRows
+}
 
-	mirrors, err := b.db.Mirrors(sslOnly)
-	if err != nil {
-		return "", err
+func (b *BouncerHandler) productForLanguage(product, lang string) (productID string, sslOnly bool, language string, err error) {
+	productID, sslOnly, language, err = b.db.ProductForLanguage(product, lang)
+	if err == sql.ErrNoRows && stripBuildNumber(product) != product {
+		// Release QA links include build numbers that aren't in the catalog
+		product = stripBuildNumber(product)
+		productID, sslOnly, language, err = b.db.ProductForLanguage(product, lang)
 	}
-
-	if len(mirrors) == 0 {
-		return "", nil
+	if fallback := b.LocaleFallbackTable[strings.ToLower(lang)]; err == sql.ErrNoRows && fallback != "" && b.fallbackAllowed() {
+		// Some locales fall back to another region, e.g. es-AR to es-ES
+		lang = fallback
+		productID, sslOnly, language, err = b.db.ProductForLanguage(product, lang)
+	}
+	if err == sql.ErrNoRows && !strings.Contains(lang, "-") && b.fallbackAllowed() {
+		// A language without a region, e.g. fr, gets a region the product
+		// is available in, e.g. fr-FR
+		regionLang, regionErr := b.languageRegion(product, lang)
+		if regionErr != nil {
+			return "", false, "", regionErr
+		}
+		if regionLang != "" {
+			productID, sslOnly, language, err = b.db.ProductForLanguage(product, regionLang)
+		}
 	}
+	return
+}
 
-	mirror := randomMirror(mirrors)
-	if mirror == nil {
-		return "", nil
+// languageRegion returns the lang of product for a language without a
+// region: the preferred region in LanguageRegions if the product has it, or
+// else the first one. If the string is == "", the product has no region for
+// the language.
+func (b *BouncerHandler) languageRegion(product, language string) (string, error) {
+	langs, err := b.db.ProductRegions(product, language)
+	if err != nil || len(langs) == 0 {
+		return "", err
 	}
 
-	return mirror.BaseURL, nil
+	if region := b.LanguageRegions[strings.ToLower(language)]; region != "" {
+		for _, lang := range langs {
+			if strings.EqualFold(lang, language+"-"+region) {
+				return lang, nil
+			}
+		}
+	}
+	return langs[0], nil
 }
 
-func (b *BouncerHandler) stubAttributionURL(reqParams *BouncerParams) string {
-	query := url.Values{}
-	query.Set("lang", reqParams.Lang)
-	query.Set("os", reqParams.OS)
-	query.Set("product", reqParams.Product)
-	query.Set("attribution_code", reqParams.AttributionCode)
-	query.Set("attribution_sig", reqParams.AttributionSig)
-
-	return b.StubRootURL + "?" + query.Encode()
+// DefaultOSCanonicalNames are the canonical names of catalog oses whose
+// names consumers don't expect. Other oses are presented unchanged.
+var DefaultOSCanonicalNames = map[string]string{
+	"win": "win32",
+	"osx": "mac",
 }
 
-func (b *BouncerHandler) shouldPinHttps(req *http.Request) bool {
-	if b.PinHttpsHeaderName == "" {
-		return false
+// presentedOS returns the name os is presented by in os=all choices
+func (b *BouncerHandler) presentedOS(os string) string {
+	if !b.CanonicalOSKeys {
+		return os
+	}
+	names := b.OSCanonicalNames
+	if names == nil {
+		names = DefaultOSCanonicalNames
+	}
+	if name, ok := names[os]; ok {
+		return name
 	}
+	return os
+}
 
-	return req.Header.Get(b.PinHttpsHeaderName) == "https"
+// osChoice is the url of a product for one os
+type osChoice struct {
+	OS  string `json:"os"`
+	URL string `json:"url"`
 }
 
-func (b *BouncerHandler) shouldAttribute(reqParams *BouncerParams) bool {
-	validOs := func() bool {
-		// Only include windows.
-		for _, s := range []string{"win", "win64", "win64-aarch64"} {
-			if reqParams.OS == s {
-				return true
-			}
-		}
-		return false
+// osChoices returns the url of a product for every os it is available on
+func (b *BouncerHandler) osChoices(pinHttps bool, lang, product string) ([]osChoice, error) {
+	product, err := b.aliasFor(product)
+	if err != nil {
+		return nil, err
 	}
 
-	if b.StubRootURL == "" {
-		return false
+	productID, sslOnly, lang, err := b.productForLanguage(product, lang)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+	case err != nil:
+		return nil, err
 	}
 
-	if reqParams.AttributionCode == "" {
+	locations, err := b.db.ProductLocations(productID)
+	if err != nil || len(locations) == 0 {
+		return nil, err
+	}
+
+	mirrorBaseURL, err := b.schemeMirrorBaseURL(pinHttps, sslOnly)
+	if err != nil || mirrorBaseURL == "" {
+		return nil, err
+	}
+
+	choices := make([]osChoice, 0, len(locations))
+	for _, location := range locations {
+		choices = append(choices, osChoice{
+			OS:  location.OS,
+			URL: mirrorBaseURL + b.pathPrefix(product) + strings.Replace(location.Path, ":lang", lang, -1),
+		})
+	}
+	return choices, nil
+}
+
+// serveMultipleChoices responds with the url of the product for every os it
+// is available on, or redirects if there is only one
+func (b *BouncerHandler) serveMultipleChoices(w http.ResponseWriter, req *http.Request, reqParams *BouncerParams) {
+	choices, err := b.osChoices(b.pinHttps(req, reqParams), reqParams.Lang, reqParams.Product)
+	if err != nil || len(choices) <= 1 {
+		url := ""
+		if len(choices) == 1 {
+			url = choices[0].URL
+		}
+		b.serveURL(w, req, reqParams, url, err)
+		return
+	}
+
+	for i := range choices {
+		choices[i].OS = b.presentedOS(choices[i].OS)
+	}
+	b.serveJSON(w, req, http.StatusMultipleChoices, struct {
+		Choices []osChoice `json:"choices"`
+	}{choices})
+}
+
+// bundleProduct is a product of a bundle, resolved to its url
+type bundleProduct struct {
+	Product string `json:"product"`
+	URL     string `json:"url"`
+	// Checksum is the checksum of the file of the type of the checksum
+	// param, if the catalog records it
+	Checksum *bundleChecksum `json:"checksum,omitempty"`
+}
+
+// serveBundle responds with the url of every product of a bundle that is
+// available for the requested os and lang
+func (b *BouncerHandler) serveBundle(w http.ResponseWriter, req *http.Request, reqParams *BouncerParams, products []string) {
+	bundle := make([]bundleProduct, 0, len(products))
+	for _, product := range products {
+		res, err := b.resolve(b.pinHttps(req, reqParams), reqParams.Lang, reqParams.OS, product)
+		if isResolveError(err) {
+			continue
+		}
+		if err != nil {
+			b.serveURL(w, req, reqParams, "", err)
+			return
+		}
+		checksum := b.checksum(res, checksumType(reqParams.Checksum))
+		url := res.URL
+		if b.isOneTime(product) {
+			url, err = b.oneTimeURL(url)
+			if err != nil {
+				b.serveURL(w, req, reqParams, "", err)
+				return
+			}
+			w.Header().Set("Cache-Control", "no-store")
+		}
+		bundle = append(bundle, bundleProduct{Product: product, URL: url, Checksum: checksum})
+	}
+
+	if len(bundle) == 0 {
+		errorResponse(w, req, http.StatusNotFound, ErrorCodeNotFound, "404 page not found")
+		return
+	}
+
+	b.serveJSON(w, req, http.StatusOK, struct {
+		Products []bundleProduct `json:"products"`
+	}{bundle})
+}
+
+// serveJSON responds with v as json, cached for cacheTime unless the
+// response already has a Cache-Control header
+func (b *BouncerHandler) serveJSON(w http.ResponseWriter, req *http.Request, status int, v interface{}) {
+	res, err := json.Marshal(v)
+	if err != nil {
+		errorResponse(w, req, http.StatusInternalServerError, ErrorCodeInternal, "Internal Server Error.")
+		log.Println(err)
+		return
+	}
+
+	if cacheTime := b.cacheTime(req); cacheTime > 0 && w.Header().Get("Cache-Control") == "" {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", cacheTime/time.Second))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(res)
+}
+
+// licenseURL returns the license url for a product, os and lang, versioned
+// by the catalog product the request resolves to, or by its location if the
+// catalog product doesn't name a version
+// if the string is == "", no template is configured or the product wasn't found
+func (b *BouncerHandler) licenseURL(lang, os, product string) (string, error) {
+	if b.LicenseURLTemplate == "" {
+		return "", nil
+	}
+
+	res, err := b.resolve(false, lang, os, product)
+	if isResolveError(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	version := productVersion(res.Product)
+	if version == "" {
+		version = locationVersion(res.LocationPath)
+	}
+	if version == "" && strings.Contains(b.LicenseURLTemplate, "{version}") {
+		return "", nil
+	}
+
+	replacer := strings.NewReplacer("{version}", version, "{lang}", res.Lang)
+	return replacer.Replace(b.LicenseURLTemplate), nil
+}
+
+func (b *BouncerHandler) mirrorBaseURL(sslOnly bool) (string, error) {
+	decision := b.newMirrorDecision(sslOnly)
+	if b.PinnedBaseURLHttps != "" && sslOnly {
+		decision.pin("https://" + b.PinnedBaseURLHttps)
+		return "https://" + b.PinnedBaseURLHttps, nil
+	}
+
+	if b.PinnedBaseURLHttp != "" && !sslOnly {
+		decision.pin("http://" + b.PinnedBaseURLHttp)
+		return "http://" + b.PinnedBaseURLHttp, nil
+	}
+
+	mirrors, err := b.db.Mirrors(sslOnly)
+	if err != nil {
+		return "", err
+	}
+	mirrors, outOfPool := b.poolMirrors(mirrors)
+	if decision != nil {
+		// Mirrors are listed by the scheme of their base url
+		otherScheme, err := b.db.Mirrors(!sslOnly)
+		if err != nil {
+			return "", err
+		}
+		decision.consider(mirrors, b.MirrorLatencies)
+		decision.exclude(outOfPool, ExcludedPool)
+		decision.exclude(otherScheme, ExcludedScheme)
+	}
+
+	if len(mirrors) == 0 {
+		return "", nil
+	}
+
+	var mirror *bouncer.MirrorsResult
+	strategy, roll := StrategyLatency, 0
+	if b.MirrorLatencies != nil {
+		mirror = b.MirrorLatencies.Fastest(mirrors)
+	}
+	if mirror == nil {
+		strategy, roll = StrategyWeighted, weightRoll(mirrors)
+		mirror = weightedMirror(mirrors, roll)
+	}
+	if mirror == nil {
+		return "", nil
+	}
+
+	baseURL := mirror.BaseURL
+	if !sslOnly {
+		baseURL = b.mirrorDefaultScheme(mirror.BaseURL)
+	}
+	decision.choose(mirror, strategy, roll, baseURL)
+	return baseURL, nil
+}
+
+// schemeMirrorBaseURL returns the mirror base url of the scheme a product is
+// served over. Requests HTTPSFallback applies to get an http base url if
+// there is no https one; pinned requests get the other scheme if their
+// SchemeMismatchPolicy serves it.
+func (b *BouncerHandler) schemeMirrorBaseURL(pinHttps, sslOnly bool) (string, error) {
+	mirrorBaseURL, err := b.mirrorBaseURL(pinHttps || sslOnly)
+	if err != nil || mirrorBaseURL != "" || sslOnly {
+		return mirrorBaseURL, err
+	}
+	if b.preferHttps {
+		b.incr("scheme.http_fallback")
+		return b.mirrorBaseURL(false)
+	}
+
+	scheme, policy := "http", b.HTTPPinMismatch
+	if pinHttps {
+		scheme, policy = "https", b.HTTPSPinMismatch
+	}
+	switch policy {
+	case SchemeMismatchServe:
+		if pinHttps {
+			log.Printf("Warning: no https mirror, serving http to an https pinned request")
+			b.incr("scheme.mismatch_downgrade")
+		} else {
+			b.incr("scheme.mismatch_upgrade")
+		}
+		return b.mirrorBaseURL(!pinHttps)
+	case SchemeMismatchError:
+		return "", fmt.Errorf("no %s mirror for a %s pinned request", scheme, scheme)
+	}
+	return "", nil
+}
+
+// archiveBaseURL returns the base url of the archive if product is served
+// from it rather than from the release mirrors
+// if the string is == "", the product is served from the mirrors
+func (b *BouncerHandler) archiveBaseURL(sslOnly bool, product string) (string, error) {
+	if b.ArchiveBaseURL == "" || !b.isArchived(product) {
+		return "", nil
+	}
+	if sslOnly {
+		return "https://" + b.ArchiveBaseURL, nil
+	}
+	return "http://" + b.ArchiveBaseURL, nil
+}
+
+// isArchived returns true if product is configured in ArchiveProducts, either
+// as a whole or with a version earlier than the configured one. Products
+// without a version, e.g. aliases, are only archived as a whole.
+func (b *BouncerHandler) isArchived(product string) bool {
+	before := productOrFamilyValue(b.ArchiveProducts, product)
+	if before == ArchiveAllVersions {
+		return true
+	}
+	version := productVersion(product)
+	if before == "" || version == "" {
 		return false
 	}
-	if reqParams.AttributionSig == "" {
+	return compareVersions(version, before) < 0
+}
+
+// mirrorDefaultScheme returns baseURL with the default scheme configured for
+// its host, if any
+func (b *BouncerHandler) mirrorDefaultScheme(baseURL string) string {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return baseURL
+	}
+
+	scheme, ok := b.MirrorDefaultSchemes[u.Host]
+	if !ok || (scheme != "http" && scheme != "https") {
+		return baseURL
+	}
+
+	u.Scheme = scheme
+	return u.String()
+}
+
+// torrentURL returns the path of the torrent for an installer location
+// if the string is == "", there is no torrent for the location
+func (b *BouncerHandler) torrentURL(locationPath, lang string) string {
+	if !b.Torrents {
+		return ""
+	}
+
+	isInstaller := false
+	for _, suffix := range torrentInstallerSuffixes {
+		if strings.HasSuffix(strings.ToLower(locationPath), suffix) {
+			isInstaller = true
+			break
+		}
+	}
+	if !isInstaller {
+		return ""
+	}
+
+	template := b.TorrentPathTemplate
+	if template == "" {
+		template = DefaultTorrentPathTemplate
+	}
+	return strings.NewReplacer(
+		"{path}", strings.Replace(locationPath, ":lang", lang, -1),
+		"{lang}", lang,
+	).Replace(template)
+}
+
+// pathPrefix returns the path prefix configured for a product, falling back to
+// the prefix of its family (the product name up to the first -)
+func (b *BouncerHandler) pathPrefix(product string) string {
+	return productOrFamilyValue(b.ProductPathPrefixes, product)
+}
+
+// productOrFamilyValue returns the value of product in m, falling back to the
+// value of its family (the product name up to the first -)
+func productOrFamilyValue(m map[string]string, product string) string {
+	product = strings.ToLower(product)
+	if v, ok := m[product]; ok {
+		return v
+	}
+	return m[strings.SplitN(product, "-", 2)[0]]
+}
+
+func (b *BouncerHandler) stubAttributionURL(reqParams *BouncerParams) string {
+	return attributionURL(b.StubRootURL, reqParams)
+}
+
+// attributionForwardURL returns the url of the endpoint the attribution of
+// a request is forwarded to, if its product, or product family, opted in
+// to AttributionEndpoints and it has a valid attribution. If the string
+// is == "", the attribution isn't forwarded.
+func (b *BouncerHandler) attributionForwardURL(reqParams *BouncerParams) string {
+	rootURL := productOrFamilyValue(b.AttributionEndpoints, reqParams.Product)
+	if rootURL == "" || !hasAttribution(reqParams) {
+		return ""
+	}
+	return attributionURL(rootURL, reqParams)
+}
+
+// attributionURL returns the url of rootURL receiving the product, os, lang
+// and attribution of a request
+func attributionURL(rootURL string, reqParams *BouncerParams) string {
+	query := url.Values{}
+	query.Set("lang", reqParams.Lang)
+	query.Set("os", reqParams.OS)
+	query.Set("product", reqParams.Product)
+	query.Set("attribution_code", reqParams.AttributionCode)
+	query.Set("attribution_sig", reqParams.AttributionSig)
+
+	return rootURL + "?" + query.Encode()
+}
+
+// inferOS returns the os of the client making req, or "" if it can't be
+// determined. If InferOS is set, the os is inferred from the user agent.
+// Otherwise, or if the user agent is missing or generic, the
+// ClientOSHeaderName header is used.
+func (b *BouncerHandler) inferOS(req *http.Request) string {
+	if b.InferOS {
+		if os := osFromUserAgent(req.UserAgent()); os != "" {
+			return os
+		}
+	}
+
+	if b.ClientOSHeaderName != "" {
+		return strings.TrimSpace(strings.ToLower(req.Header.Get(b.ClientOSHeaderName)))
+	}
+	return ""
+}
+
+// defaultOS returns the os used when a request omits os or asks for the
+// server default, and false if it isn't the os of the client because that
+// couldn't be determined
+func (b *BouncerHandler) defaultOS(req *http.Request) (string, bool) {
+	if os := b.inferOS(req); os != "" {
+		return os, true
+	}
+	return DefaultOS, false
+}
+
+// pinHttps returns true if the request must be served over https. A scheme
+// param takes precedence over the pin header.
+func (b *BouncerHandler) pinHttps(req *http.Request, reqParams *BouncerParams) bool {
+	if b.isHTTPSLang(reqParams.Lang) {
+		return true
+	}
+	switch reqParams.Scheme {
+	case "https":
+		return true
+	case "http":
+		return false
+	}
+	return b.PreferHTTPS || b.preferHttps || b.shouldPinHttps(req)
+}
+
+// withHTTPSFallback returns a copy of b preferring https for a request, if
+// HTTPSFallback is set and the request isn't pinned to either scheme. b is
+// returned otherwise.
+func (b *BouncerHandler) withHTTPSFallback(req *http.Request, reqParams *BouncerParams) *BouncerHandler {
+	if !b.HTTPSFallback || b.PreferHTTPS || reqParams.Scheme != "" || b.shouldPinHttps(req) || b.isHTTPSLang(reqParams.Lang) {
+		return b
+	}
+	fb := *b
+	fb.preferHttps = true
+	return &fb
+}
+
+// isHTTPSLang returns true if lang is configured in HTTPSLangs, either as a
+// whole or by its language
+func (b *BouncerHandler) isHTTPSLang(lang string) bool {
+	language := strings.SplitN(lang, "-", 2)[0]
+	for _, l := range b.HTTPSLangs {
+		if strings.EqualFold(l, lang) || strings.EqualFold(l, language) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *BouncerHandler) shouldPinHttps(req *http.Request) bool {
+	if proto := b.forwardedProto(req); proto != "" {
+		return proto == "https"
+	}
+	if b.PinHttpsHeaderName == "" {
+		return false
+	}
+
+	return req.Header.Get(b.PinHttpsHeaderName) == "https"
+}
+
+// isESRAlias returns true if product is the latest ESR of a family, e.g.
+// firefox-esr-latest or firefox-esr-latest-ssl
+func isESRAlias(product string) bool {
+	parts := strings.Split(strings.ToLower(product), "-")
+	return len(parts) >= 3 && parts[1] == "esr" && parts[2] == "latest"
+}
+
+// esrCycleProduct returns the ESRCycles product of the latest ESR cycle
+// started at or before version, if product is the latest ESR of the same
+// family, e.g. firefox-esr60-latest for firefox-esr-latest and 60.9.0.
+// Otherwise product is returned unchanged.
+func (b *BouncerHandler) esrCycleProduct(product, version string) string {
+	if len(b.ESRCycles) == 0 || version == "" || !isESRAlias(product) {
+		return product
+	}
+	major, err := strconv.Atoi(strings.TrimRightFunc(strings.SplitN(version, ".", 2)[0], isNotNumber))
+	if err != nil {
+		return product
+	}
+
+	family := strings.SplitN(strings.ToLower(product), "-", 2)[0]
+	cycle, cycleProduct := -1, product
+	for c, p := range b.ESRCycles {
+		cycleMajor, err := strconv.Atoi(c)
+		if err != nil || cycleMajor > major || cycleMajor <= cycle {
+			continue
+		}
+		if strings.SplitN(strings.ToLower(p), "-", 2)[0] != family {
+			continue
+		}
+		cycle, cycleProduct = cycleMajor, p
+	}
+	return cycleProduct
+}
+
+// xpSupported returns false if product is a version later than the last one
+// built for Windows XP. Products without a version, e.g. aliases, are
+// supported.
+func (b *BouncerHandler) xpSupported(product string) bool {
+	until := productOrFamilyValue(b.XPSupportedUntil, product)
+	version := productVersion(product)
+	if until == "" || version == "" {
+		return true
+	}
+	return compareVersions(version, until) <= 0
+}
+
+// shouldRewriteSha1 returns true if product may be rewritten to its sha1
+// signed equivalent for Windows XP clients
+func (b *BouncerHandler) shouldRewriteSha1(product string) bool {
+	if !b.xpSupported(product) {
+		return false
+	}
+	if len(b.SHA1RewriteProducts) == 0 {
+		return true
+	}
+	for _, p := range b.SHA1RewriteProducts {
+		if strings.EqualFold(p, product) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *BouncerHandler) sha1AliasSuffixes() []string {
+	if b.SHA1AliasSuffixes == nil {
+		return DefaultSHA1AliasSuffixes
+	}
+	return b.SHA1AliasSuffixes
+}
+
+// isTrusted returns true if req comes from one of the TrustedCIDRs
+func (b *BouncerHandler) isTrusted(req *http.Request) bool {
+	return inCIDRs(req, b.TrustedCIDRs)
+}
+
+// requiredFeatureFlag returns the feature flag product, or the product it is
+// an alias for, is gated behind
+// if the string is == "", the product isn't gated
+func (b *BouncerHandler) requiredFeatureFlag(product string) (string, error) {
+	if len(b.ProductFeatureFlags) == 0 {
+		return "", nil
+	}
+	if flag := b.ProductFeatureFlags[strings.ToLower(product)]; flag != "" {
+		return flag, nil
+	}
+
+	related, err := b.aliasFor(product)
+	if err != nil {
+		return "", err
+	}
+	return b.ProductFeatureFlags[strings.ToLower(related)], nil
+}
+
+// featureFlagAllowed returns false if product is gated behind a feature flag
+// that req doesn't carry in its FeatureFlagsHeaderName header or
+// FeatureFlagsCookieName cookie, and that isn't an experiment enabled for
+// the request
+func (b *BouncerHandler) featureFlagAllowed(w http.ResponseWriter, req *http.Request, product string) (bool, error) {
+	required, err := b.requiredFeatureFlag(product)
+	if err != nil || required == "" {
+		return true, err
+	}
+	if b.isExperiment(required) {
+		// Responses depend on the experiments of the client, so they mustn't
+		// be shared
+		w.Header().Set("Cache-Control", "private, no-store")
+	}
+	if b.experimentEnabled(required) {
+		return true, nil
+	}
+
+	// Responses for gated products depend on the flags of the client
+	w.Header().Add("Vary", FeatureFlagsHeaderName+", Cookie")
+
+	flags := req.Header.Get(FeatureFlagsHeaderName)
+	if cookie, err := req.Cookie(FeatureFlagsCookieName); err == nil {
+		flags += "," + cookie.Value
+	}
+	for _, flag := range strings.Split(flags, ",") {
+		if strings.EqualFold(strings.TrimSpace(flag), required) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// isAdmin returns true if req comes from one of the AdminCIDRs
+func (b *BouncerHandler) isAdmin(req *http.Request) bool {
+	return inCIDRs(req, b.AdminCIDRs)
+}
+
+// cacheTime returns the Cache-Control max-age for the response to req
+func (b *BouncerHandler) cacheTime(req *http.Request) time.Duration {
+	// Mirror decisions are per request
+	if b.mirrorDecisions != nil && b.mirrorDecisions.debug {
+		return 0
+	}
+	if v := req.Header.Get(CacheTimeHeaderName); v != "" && (b.isTrusted(req) || b.isAdmin(req)) {
+		seconds, err := strconv.Atoi(v)
+		if err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return b.CacheTime
+}
+
+func (b *BouncerHandler) shouldAttribute(reqParams *BouncerParams) bool {
+	validOs := func() bool {
+		// Only include windows.
+		for _, s := range []string{"win", "win64", "win64-aarch64"} {
+			if reqParams.OS == s {
+				return true
+			}
+		}
+		return false
+	}
+
+	if b.StubRootURL == "" || !b.StubSwitch.Enabled() {
+		return false
+	}
+
+	if !hasAttribution(reqParams) {
 		return false
 	}
 
@@ -383,6 +1934,19 @@ func (b *BouncerHandler) shouldAttribute(reqParams *BouncerParams) bool {
 		return false
 	}
 
+	return true
+}
+
+// hasAttribution returns true if a request has an attribution code and
+// signature, and isn't for an update
+func hasAttribution(reqParams *BouncerParams) bool {
+	if reqParams.AttributionCode == "" {
+		return false
+	}
+	if reqParams.AttributionSig == "" {
+		return false
+	}
+
 	// Exclude updates
 	for _, s := range []string{"-partial", "-complete", "-msi"} {
 		if strings.Contains(reqParams.Product, s) {
@@ -394,61 +1958,408 @@ func (b *BouncerHandler) shouldAttribute(reqParams *BouncerParams) bool {
 }
 
 func (b *BouncerHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	b = b.withPartnerCatalog(req).withStagingCatalog(req).withRequestCache().withAliasCacheResult().withMirrorDecisions(req).withMirrorPool(req).withFallbackLimit()
+	req = b.withForwarded(req)
+	b = b.withExperiments(req)
+
+	if b.AccessLog != nil {
+		start := time.Now()
+		lw := &accessLogWriter{ResponseWriter: w, status: http.StatusOK, experiments: b.experiments}
+		defer func() { b.AccessLog.Log(req, lw, start) }()
+		w = lw
+	}
+
+	if b.TimingAllowOrigin != "" {
+		w.Header().Set("Timing-Allow-Origin", b.TimingAllowOrigin)
+	}
+
+	if b.Maintenance.Enabled() {
+		b.serviceUnavailable(w, req, ErrorCodeMaintenance)
+		return
+	}
+
 	reqParams := BouncerParamsFromValues(req.URL.Query())
+	if t, ok := b.ShortCodes[reqParams.ShortCode]; ok {
+		reqParams.applyShortCode(t)
+	}
+	if reqParams.Spec != "" {
+		t, err := parseSpec(reqParams.Spec)
+		if err != nil {
+			errorResponse(w, req, http.StatusBadRequest, ErrorCodeBadRequest, "Invalid spec.")
+			return
+		}
+		reqParams.applyShortCode(t)
+	}
+	if reqParams.Region != "" {
+		rb, ok := b.withMirrorRegion(reqParams.Region)
+		if !ok && b.StrictRegions {
+			errorResponse(w, req, http.StatusBadRequest, ErrorCodeBadRequest, "Unknown region.")
+			return
+		}
+		b = rb
+	}
+	if b.CleanOSLang {
+		reqParams.cleanOSLang()
+	}
+	b = b.withHTTPSFallback(req, reqParams)
 
 	if reqParams.Product == "" {
-		http.Redirect(w, req, "https://www.mozilla.org/", 302)
+		if b.EmptyProductPolicy == EmptyProductBadRequest && len(req.URL.Query()) > 0 {
+			errorResponse(w, req, http.StatusBadRequest, ErrorCodeBadRequest, "product is required.")
+			return
+		}
+		b.redirect(w, req, "https://www.mozilla.org/", 302)
 		return
 	}
 
-	if reqParams.OS == "" {
-		reqParams.OS = DefaultOS
+	if renamed, ok := b.ProductRenames[reqParams.Product]; ok && b.CanonicalizeRenames {
+		b.redirect(w, req, renamedURL(req, renamed), http.StatusMovedPermanently)
+		return
+	}
+
+	osKnown := true
+	if reqParams.OS == "" || reqParams.OS == DefaultOSToken {
+		reqParams.OS, osKnown = b.defaultOS(req)
+	}
+	if os, ok := archOS(reqParams.OS); ok {
+		reqParams.OS = os
+	} else {
+		b.serveURL(w, req, reqParams, "", &resolveError{Code: ErrorCodeOSNotFound, Reason: ReasonUnknownOS})
+		return
+	}
+	reqParams.OS = b.versionedOS(req, reqParams)
+	if reqParams.Lang == "" && b.ParseProductLocale {
+		reqParams.Product, reqParams.Lang = splitProductLocale(reqParams.Product)
+	}
+	if reqParams.Lang == "" && b.EnableAcceptLanguage {
+		w.Header().Add("Vary", "Accept-Language")
+		lang, err := b.acceptLanguage(req, reqParams.Product)
+		if err != nil {
+			log.Printf("acceptLanguage err: %v", err)
+		}
+		reqParams.Lang = lang
 	}
 	if reqParams.Lang == "" {
 		reqParams.Lang = DefaultLang
 	}
 
+	if products := b.Bundles[reqParams.Product]; len(products) > 0 {
+		if reqParams.Format == FormatJSON {
+			b.serveBundle(w, req, reqParams, products)
+			return
+		}
+		reqParams.Product = products[0]
+	}
+
+	reqParams.Product = b.esrCycleProduct(reqParams.Product, reqParams.Version)
+
+	// The full installer is served while the stub is switched off
+	if !b.StubSwitch.Enabled() {
+		reqParams.Product = fullInstallerProduct(reqParams.Product)
+	}
+
+	// Gated products don't exist for clients without the flag
+	if allowed, err := b.featureFlagAllowed(w, req, reqParams.Product); err != nil || !allowed {
+		if err == nil {
+			err = &resolveError{Code: ErrorCodeProductNotFound, Reason: ReasonFeatureFlagRequired}
+		}
+		b.serveURL(w, req, reqParams, "", err)
+		return
+	}
+
+	// Releases in progress will be served soon
+	if b.Building.IsBuilding(reqParams.Product) {
+		b.serveBuilding(w, req)
+		return
+	}
+	b.setSunset(w, reqParams.Product)
+
+	methods, err := b.productMethods(reqParams.Product)
+	if err != nil {
+		b.serveURL(w, req, reqParams, "", err)
+		return
+	}
+	if methods != nil && !methodAllowed(methods, req.Method) {
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		errorResponse(w, req, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed, "Method Not Allowed.")
+		return
+	}
+
+	// Products with a EULA are only served to clients which accepted it
+	eulaURL, err := b.eulaURL(reqParams)
+	if err != nil {
+		b.serveURL(w, req, reqParams, "", err)
+		return
+	}
+	if eulaURL != "" {
+		b.redirect(w, req, eulaURL, http.StatusFound)
+		return
+	}
+
+	if reqParams.OS == AllOSToken {
+		if os := b.inferOS(req); os != "" {
+			reqParams.OS = os
+		}
+	}
+
+	// If the os can't be narrowed down, let the client choose
+	if reqParams.OS == AllOSToken && b.MultipleChoices {
+		b.serveMultipleChoices(w, req, reqParams)
+		return
+	}
+
+	// If ?view=license, serve the license url for the product instead
+	if reqParams.View == "license" {
+		url, err := b.licenseURL(reqParams.Lang, reqParams.OS, reqParams.Product)
+		b.serveURL(w, req, reqParams, url, err)
+		return
+	}
+
 	isWinXpClient := isWindowsXPUserAgent(req.UserAgent())
 
+	// Products opted in to attribution forwarding send it to their endpoint
+	if forwardURL := b.attributionForwardURL(reqParams); forwardURL != "" {
+		setResolvedURL(w, forwardURL)
+		b.redirect(w, req, forwardURL, 302)
+		return
+	}
+
 	// If the client is not WinXP and attribution_code is set, redirect to the stub service
 	if b.shouldAttribute(reqParams) && !isWinXpClient {
 		stubURL := b.stubAttributionURL(reqParams)
-		http.Redirect(w, req, stubURL, 302)
+		setResolvedURL(w, stubURL)
+		b.redirect(w, req, stubURL, 302)
 		return
 	}
 
+	// Configured user agent rules take precedence over the HACKS below
 	// HACKS
 	// If the user is coming from windows xp or vista, send a sha1
 	// signed product
 	// If the user is coming from an old version of OSX, change their product to ESR
 	// HACKS
-	if reqParams.OS == "win" && isWinXpClient {
-		reqParams.Product = sha1Product(reqParams.Product)
+	if product, ok := rewriteUserAgentProduct(b.UARewriteRules, req.UserAgent(), reqParams.Product); ok {
+		reqParams.Product = product
+	} else if reqParams.OS == "win" && isWinXpClient && b.shouldRewriteSha1(reqParams.Product) {
+		reqParams.Product = sha1ProductWithSuffixes(b.thunderbirdProduct(reqParams.Product), b.sha1AliasSuffixes())
 	} else if reqParams.OS == "osx" && isDeprecatedOSXAgent(req.UserAgent()) {
 		reqParams.Product = osxEsrProduct(reqParams.Product)
 	}
 
-	url, err := b.URL(b.shouldPinHttps(req), reqParams.Lang, reqParams.OS, reqParams.Product)
+	url := ""
+	start := time.Now()
+	res, err := b.resolveForClient(req, reqParams, osKnown, isWinXpClient)
+	b.requestTiming(req, b.resolveTimingName(), time.Since(start))
+	b.reportMirrorDecision(w, reqParams)
+	if err == nil {
+		url = res.URL
+		w.Header().Set("X-Bouncer-Resolved-Lang", res.Lang)
+
+		if res.SSLOnly && reqParams.Scheme == "http" {
+			log.Printf("Blocked http downgrade of ssl only product %s", reqParams.Product)
+			b.incr("scheme.downgrade_blocked")
+		}
+
+		// Have clients remember to ask for https
+		if !res.SSLOnly && reqParams.Scheme == "http" && b.PreferHTTPS && b.CanonicalSchemeUpgrade {
+			b.redirect(w, req, httpsCanonicalURL(req), http.StatusPermanentRedirect)
+			return
+		}
+
+		if reqParams.Format == FormatTorrent {
+			url = ""
+			if torrentPath := b.torrentURL(res.LocationPath, res.Lang); torrentPath != "" {
+				url = res.BaseURL + torrentPath
+			}
+		}
+		// One-time products never get a url which can be shared
+		immutable := reqParams.Immutable && !b.isOneTime(reqParams.Product)
+		if url != "" && immutable {
+			// products naming no version are served their mirror url,
+			// which names the file
+			if immutableURL := b.immutableURL(req, res); immutableURL != "" {
+				url = immutableURL
+			} else {
+				immutable = false
+			}
+		}
+		if url != "" && !reqParams.PrintOnly && !immutable {
+			b.countRedirectScheme(req, reqParams, url, res.SSLOnly)
+			b.incrTagged("download", map[string]string{"product": res.Product, "os": res.OS})
+		}
+	}
+	b.serveURL(w, req, reqParams, url, err)
+}
+
+// resolveForClient resolves reqParams, serving win64 instead of win to
+// 64-bit Windows clients if UpgradeWin64 is set and the product has a win64
+// build, and the universal installer of the product to clients whose os
+// isn't known, if it has one
+func (b *BouncerHandler) resolveForClient(req *http.Request, reqParams *BouncerParams, osKnown, isWinXpClient bool) (*resolution, error) {
+	pinHttps := b.pinHttps(req, reqParams)
+	if universalOS := productOrFamilyValue(b.UniversalOS, reqParams.Product); !osKnown && universalOS != "" {
+		res, err := b.resolve(pinHttps, reqParams.Lang, universalOS, reqParams.Product)
+		if !isResolveError(err) {
+			return res, err
+		}
+	}
+	if b.UpgradeWin64 && reqParams.OS == "win" && reqParams.Arch != Arch32Token &&
+		!isWinXpClient && osFromUserAgent(req.UserAgent()) == "win64" {
+		res, err := b.resolve(pinHttps, reqParams.Lang, "win64", reqParams.Product)
+		if !isResolveError(err) {
+			return res, err
+		}
+	}
+	return b.resolve(pinHttps, reqParams.Lang, reqParams.OS, reqParams.Product)
+}
+
+// httpsCanonicalURL returns the https bouncer url of req, without the scheme
+// param
+func httpsCanonicalURL(req *http.Request) string {
+	query := req.URL.Query()
+	query.Del("scheme")
+
+	u := *req.URL
+	u.Scheme = "https"
+	u.Host = req.Host
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// CacheBustingParam is the query param added to urls to bypass caches
+const CacheBustingParam = "bouncer_nocache"
+
+// printURL writes url as the body of a print=yes response: as text, or as
+// a json string if PrintJSON is set and the client wants json
+func (b *BouncerHandler) printURL(w http.ResponseWriter, req *http.Request, url string) {
+	if b.PrintJSON {
+		w.Header().Add("Vary", "Accept")
+	}
+	if !b.PrintJSON || !wantsJSON(req) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(url))
+		return
+	}
+
+	body, err := json.Marshal(url)
+	if err != nil {
+		errorResponse(w, req, http.StatusInternalServerError, ErrorCodeInternal, "Internal Server Error.")
+		log.Println(err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// cacheBustedURL returns url with a unique CacheBustingParam, so caches
+// between the client and the mirror miss
+func cacheBustedURL(url string) string {
+	separator := "?"
+	if strings.Contains(url, "?") {
+		separator = "&"
+	}
+	return fmt.Sprintf("%s%s%s=%x%x", url, separator, CacheBustingParam, time.Now().UnixNano(), rand.Int63())
+}
+
+// renamedURL returns the url of req with its product renamed to product
+func renamedURL(req *http.Request, product string) string {
+	query := req.URL.Query()
+	query.Del("channel")
+	query.Set("product", product)
+
+	u := *req.URL
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// serviceUnavailable responds with a 503 for code, with the Retry-After
+// configured for it in RetryAfter or else DefaultRetryAfter
+func (b *BouncerHandler) serviceUnavailable(w http.ResponseWriter, req *http.Request, code ErrorCode) {
+	unavailableResponse(w, req, code, b.retryAfter(code))
+}
+
+// retryAfter returns the Retry-After of 503 responses for code
+func (b *BouncerHandler) retryAfter(code ErrorCode) time.Duration {
+	retryAfter, ok := b.RetryAfter[code]
+	if !ok {
+		retryAfter = DefaultRetryAfter[code]
+	}
+	return retryAfter
+}
+
+// redirect redirects req to url. Browsers get an html body linking to url,
+// which is locked down with a Content-Security-Policy.
+func (b *BouncerHandler) redirect(w http.ResponseWriter, req *http.Request, url string, code int) {
+	policy := b.ContentSecurityPolicy
+	if policy == "" {
+		policy = DefaultContentSecurityPolicy
+	}
+	w.Header().Set("Content-Security-Policy", policy)
+	http.Redirect(w, req, url, code)
+}
+
+// serveURL writes the response for a resolved url
+func (b *BouncerHandler) serveURL(w http.ResponseWriter, req *http.Request, reqParams *BouncerParams, url string, err error) {
+	if err != nil && b.RecentErrors != nil {
+		b.RecentErrors.Add(ResolutionError{
+			Product: reqParams.Product,
+			OS:      reqParams.OS,
+			Lang:    reqParams.Lang,
+			Error:   err.Error(),
+			Time:    time.Now(),
+		})
+	}
+	if resErr, ok := err.(*resolveError); ok {
+		b.explainNotFound(reqParams, resErr.Code, resErr.Reason)
+		if resErr.Reason == ReasonLangNotAvailable && b.MissingLocales != nil {
+			b.MissingLocales.Add(reqParams.Product, reqParams.OS, reqParams.Lang)
+		}
+		if landingURL := b.langLandingURL(req, reqParams, resErr); landingURL != "" {
+			b.redirect(w, req, landingURL, http.StatusFound)
+			return
+		}
+		if resErr.AvailableOS != nil {
+			osNotAvailableResponse(w, req, resErr.AvailableOS)
+			return
+		}
+		errorResponse(w, req, http.StatusNotFound, resErr.Code, "404 page not found")
+		return
+	}
 	if err != nil {
-		http.Error(w, "Internal Server Error.", http.StatusInternalServerError)
+		errorResponse(w, req, http.StatusInternalServerError, ErrorCodeInternal, "Internal Server Error.")
 		log.Println(err)
 		return
 	}
 	if url == "" {
-		http.NotFound(w, req)
+		b.explainNotFound(reqParams, ErrorCodeNotFound, ReasonNoURL)
+		errorResponse(w, req, http.StatusNotFound, ErrorCodeNotFound, "404 page not found")
 		return
 	}
 
-	if b.CacheTime > 0 {
-		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", b.CacheTime/time.Second))
+	if b.CacheBusting && reqParams.NoCache {
+		url = cacheBustedURL(url)
+		w.Header().Set("Cache-Control", "no-store")
+	} else if cacheTime := b.cacheTime(req); cacheTime > 0 && w.Header().Get("Cache-Control") == "" {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", cacheTime/time.Second))
+	}
+
+	setResolvedURL(w, url)
+
+	if b.isOneTime(reqParams.Product) {
+		url, err = b.oneTimeURL(url)
+		if err != nil {
+			errorResponse(w, req, http.StatusInternalServerError, ErrorCodeInternal, "Internal Server Error.")
+			log.Println(err)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
 	}
 
 	// If ?print=yes, print the resulting URL instead of 302ing
 	if reqParams.PrintOnly {
-		w.Header().Set("Content-Type", "text/plain")
-		w.Write([]byte(url))
+		b.printURL(w, req, url)
 		return
 	}
 
-	http.Redirect(w, req, url, 302)
+	b.redirect(w, req, url, b.redirectStatus(req, reqParams.Product))
 }
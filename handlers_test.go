@@ -1,11 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/mozilla-services/go-bouncer/bouncer"
 	"github.com/stretchr/testify/assert"
@@ -33,6 +40,30 @@ func init() {
 	}
 }
 
+// memoizedCatalog returns the test catalog looking each lookup up once, for
+// tests making many requests which don't test the database
+func memoizedCatalog() Catalog {
+	return newRequestCatalog(bouncerHandler.db)
+}
+
+// betaCatalog returns the test catalog with a firefox-beta-latest alias of
+// Firefox-SSL, for tests of the beta channel
+func betaCatalog() Catalog {
+	return &mutableAliasCatalog{
+		Catalog: bouncerHandler.db,
+		aliases: map[string]string{"firefox-beta-latest": "Firefox-SSL"},
+	}
+}
+
+// newerCatalog returns the test catalog with firefox-latest moved to the
+// 43.0.1 release, for tests of catalogs besides the live one
+func newerCatalog() Catalog {
+	return &mutableAliasCatalog{
+		Catalog: bouncerHandler.db,
+		aliases: map[string]string{"firefox-latest": "Firefox-43.0.1-SSL"},
+	}
+}
+
 func TestShouldAttribute(t *testing.T) {
 	tests := []struct {
 		In  *BouncerParams
@@ -149,6 +180,53 @@ func TestBouncerHandlerAttributionCode(t *testing.T) {
 	}
 }
 
+func TestBouncerHandlerAttributionEndpoints(t *testing.T) {
+	handler := &BouncerHandler{
+		db:                   betaCatalog(),
+		StubRootURL:          "https://stub/",
+		AttributionEndpoints: map[string]string{"firefox-beta-latest": "https://campaigns.example.com/"},
+	}
+
+	tests := []struct {
+		In  string
+		Out string
+	}{
+		// opted in products forward their attribution on any os
+		{
+			`http://test/?product=firefox-beta-latest&os=osx&lang=en-US&attribution_code=source%3Dgoogle.com&attribution_sig=anhmacsig`,
+			`https://campaigns.example.com/?attribution_code=source%3Dgoogle.com&attribution_sig=anhmacsig&lang=en-US&os=osx&product=firefox-beta-latest`,
+		},
+		{
+			`http://test/?product=Firefox-Beta-Latest&os=win&lang=en-US&attribution_code=source%3Dgoogle.com&attribution_sig=anhmacsig`,
+			`https://campaigns.example.com/?attribution_code=source%3Dgoogle.com&attribution_sig=anhmacsig&lang=en-US&os=win&product=firefox-beta-latest`,
+		},
+		// without a valid attribution, the download is served
+		{
+			`http://test/?product=firefox-beta-latest&os=osx&lang=en-US&attribution_code=source%3Dgoogle.com`,
+			`https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg`,
+		},
+		// other products aren't forwarded
+		{
+			`http://test/?product=firefox-latest&os=osx&lang=en-US&attribution_code=source%3Dgoogle.com&attribution_sig=anhmacsig`,
+			`http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg`,
+		},
+		{
+			`http://test/?product=firefox-latest&os=win&lang=en-US&attribution_code=source%3Dgoogle.com&attribution_sig=anhmacsig`,
+			`https://stub/?attribution_code=source%3Dgoogle.com&attribution_sig=anhmacsig&lang=en-US&os=win&product=firefox-latest`,
+		},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+
+		req, err := http.NewRequest("GET", test.In, nil)
+		assert.NoError(t, err)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, 302, w.Code, "url: %v", test.In)
+		assert.Equal(t, test.Out, w.HeaderMap.Get("Location"), "url: %v", test.In)
+	}
+}
+
 func TestBouncerHandlerParams(t *testing.T) {
 	w := httptest.NewRecorder()
 
@@ -188,9 +266,45 @@ func TestBouncerHandlerPrintQuery(t *testing.T) {
 
 	bouncerHandler.ServeHTTP(w, req)
 	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "text/plain; charset=utf-8", w.HeaderMap.Get("Content-Type"))
+	assert.Equal(t, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", w.Body.String())
+
+	// json is only served if configured
+	req.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	bouncerHandler.ServeHTTP(w, req)
+	assert.Equal(t, "text/plain; charset=utf-8", w.HeaderMap.Get("Content-Type"))
 	assert.Equal(t, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", w.Body.String())
 }
 
+func TestBouncerHandlerPrintJSON(t *testing.T) {
+	handler := &BouncerHandler{db: bouncerHandler.db, PrintJSON: true}
+
+	testRequests := []struct {
+		Accept              string
+		ExpectedContentType string
+		ExpectedBody        string
+	}{
+		{"application/json", "application/json", `"http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"`},
+		{"text/html, application/json;q=0.9", "application/json", `"http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"`},
+		{"", "text/plain; charset=utf-8", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{"text/plain", "text/plain; charset=utf-8", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+	}
+
+	for _, testRequest := range testRequests {
+		req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US&print=yes", nil)
+		assert.NoError(t, err)
+		req.Header.Set("Accept", testRequest.Accept)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code, "accept: %v", testRequest.Accept)
+		assert.Equal(t, testRequest.ExpectedContentType, w.HeaderMap.Get("Content-Type"), "accept: %v", testRequest.Accept)
+		assert.Equal(t, testRequest.ExpectedBody, w.Body.String(), "accept: %v", testRequest.Accept)
+		assert.Contains(t, w.HeaderMap["Vary"], "Accept", "accept: %v", testRequest.Accept)
+	}
+}
+
 func TestBouncerHandlerPinnedValid(t *testing.T) {
 	defaultUA := "Mozilla/5.0 (Windows NT 7.0; rv:10.0) Gecko/20100101 Firefox/43.0"
 	testRequests := []struct {
@@ -295,6 +409,115 @@ func TestIsDeprecatedOSXAgent(t *testing.T) {
 	}
 }
 
+func TestBouncerHandlerLicense(t *testing.T) {
+	handler := &BouncerHandler{
+		db:                 bouncerHandler.db,
+		LicenseURLTemplate: "https://www.mozilla.org/{lang}/about/legal/eula/firefox-{version}/",
+		ProductRenames:     map[string]string{"firefox-old": "firefox-sha1"},
+	}
+
+	testRequests := []struct {
+		URL              string
+		ExpectedCode     int
+		ExpectedLocation string
+	}{
+		{"http://test/?product=firefox-sha1&os=win&lang=en-US&view=license", 302, "https://www.mozilla.org/en-US/about/legal/eula/firefox-43.0.1/"},
+		{"http://test/?product=Firefox-43.0.1-SSL&os=win&lang=en-GB&view=license", 302, "https://www.mozilla.org/en-GB/about/legal/eula/firefox-43.0.1/"},
+		// aliases of products which don't name a version are versioned by their location
+		{"http://test/?product=firefox-latest&os=win&lang=en-US&view=license", 302, "https://www.mozilla.org/en-US/about/legal/eula/firefox-39.0/"},
+		{"http://test/?product=firefox-old&os=win&lang=en-US&view=license", 302, "https://www.mozilla.org/en-US/about/legal/eula/firefox-43.0.1/"},
+		{"http://test/?product=firefox-sha1&os=win&lang=fr&view=license", 404, ""},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedCode, w.Code, "url: %v", testRequest.URL)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v", testRequest.URL)
+	}
+
+	// Without a template, license requests 404
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-sha1&os=win&lang=en-US&view=license", nil)
+	assert.NoError(t, err)
+	bouncerHandler.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestProductVersion(t *testing.T) {
+	assert.Equal(t, "43.0.1", productVersion("Firefox-43.0.1-SSL"))
+	assert.Equal(t, "48.0", productVersion("firefox-48.0"))
+	assert.Equal(t, "48.0", productVersion("firefox-48.0build1"))
+	assert.Equal(t, "49.0b8", productVersion("firefox-49.0b8-stub"))
+	assert.Equal(t, "45.3.0esr", productVersion("firefox-45.3.0esr"))
+	assert.Equal(t, "", productVersion("Firefox"))
+	assert.Equal(t, "", productVersion("firefox-beta-latest"))
+}
+
+func TestLocationVersion(t *testing.T) {
+	assert.Equal(t, "39.0", locationVersion("/firefox/releases/39.0/mac/:lang/Firefox 39.0.dmg"))
+	assert.Equal(t, "45.3.0esr", locationVersion("/firefox/releases/45.3.0esr/win32/:lang/Firefox Setup 45.3.0esr.exe"))
+	assert.Equal(t, "", locationVersion("/firefox/nightly/latest-mozilla-central/firefox.dmg"))
+}
+
+func TestOSFromUserAgent(t *testing.T) {
+	uas := []struct {
+		UA string
+		OS string
+	}{
+		{"Mozilla/5.0 (Windows NT 5.1; rv:31.0) Gecko/20100101 Firefox/31.0", "win"},
+		{"Mozilla/5.0 (Windows NT 6.1; WOW64; rv:31.0) Gecko/20130401 Firefox/31.0", "win64"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/80.0.3987.132 Safari/537.36", "win64"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:78.0) Gecko/20100101 Firefox/78.0", "osx"},
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:78.0) Gecko/20100101 Firefox/78.0", "linux64"},
+		{"Mozilla/5.0 (X11; Linux i686; rv:78.0) Gecko/20100101 Firefox/78.0", "linux"},
+		{"Mozilla/5.0 (Linux; Android 10; SM-G973F) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/83.0.4103.106 Mobile Safari/537.36", ""},
+		{"curl/7.64.1", ""},
+		{"", ""},
+	}
+	for _, ua := range uas {
+		assert.Equal(t, ua.OS, osFromUserAgent(ua.UA), "ua: %v", ua.UA)
+	}
+}
+
+func TestBouncerHandlerDefaultOS(t *testing.T) {
+	macUA := "Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:78.0) Gecko/20100101 Firefox/78.0"
+	win64UA := "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:78.0) Gecko/20100101 Firefox/78.0"
+	testRequests := []struct {
+		URL              string
+		ExpectedLocation string
+		UserAgent        string
+		InferOS          bool
+	}{
+		{"http://test/?product=firefox-latest&os=default&lang=en-US", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win32/en-US/Firefox%20Setup%2039.0.exe", macUA, false},
+		{"http://test/?product=firefox-latest&os=default&lang=en-US", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", macUA, true},
+		{"http://test/?product=firefox-latest&os=DEFAULT&lang=en-US", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win64/en-US/Firefox%20Setup%2039.0.exe", win64UA, true},
+		{"http://test/?product=firefox-latest&lang=en-US", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win64/en-US/Firefox%20Setup%2039.0.exe", win64UA, true},
+		{"http://test/?product=firefox-latest&os=default&lang=en-US", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win32/en-US/Firefox%20Setup%2039.0.exe", "curl/7.64.1", true},
+		{"http://test/?product=firefox-latest&os=osx&lang=en-US", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", win64UA, true},
+	}
+
+	for _, testRequest := range testRequests {
+		handler := &BouncerHandler{
+			db:      bouncerHandler.db,
+			InferOS: testRequest.InferOS,
+		}
+
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v ua: %v", testRequest.URL, testRequest.UserAgent)
+
+		req.Header.Set("User-Agent", testRequest.UserAgent)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, 302, w.Code, "url: %v ua: %v", testRequest.URL, testRequest.UserAgent)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v ua: %v", testRequest.URL, testRequest.UserAgent)
+	}
+}
+
 func TestSha1Product(t *testing.T) {
 	// Ignore products ending with sha1
 	assert.Equal(t, "firefox-something-sha1", sha1Product("firefox-something-sha1"))
@@ -384,6 +607,52 @@ func TestSha1Product(t *testing.T) {
 	assert.Equal(t, "thunderbird-43.0b1", sha1Product("thunderbird-44.0b1"))
 
 	assert.Equal(t, "thunderbird-42.0b1", sha1Product("thunderbird-42.0b1"))
+
+	assert.Equal(t, "thunderbird-38.5.0", sha1Product("thunderbird-latest"))
+	assert.Equal(t, "thunderbird-38.5.0-ssl", sha1Product("thunderbird-latest-ssl"))
+	assert.Equal(t, "thunderbird-43.0b1", sha1Product("thunderbird-beta-latest"))
+	assert.Equal(t, "thunderbird-43.0b1-ssl", sha1Product("thunderbird-beta-ssl"))
+	assert.Equal(t, "thunderbird-43.0b1-ssl", sha1Product("thunderbird-beta-latest-ssl"))
+	assert.Equal(t, "thunderbird-38.5.0", sha1Product("thunderbird-esr-latest"))
+	assert.Equal(t, "thunderbird-38.5.0-ssl", sha1Product("thunderbird-esr-latest-ssl"))
+
+	// Build numbers
+	assert.Equal(t, "firefox-sha1", sha1Product("firefox-48.0build1"))
+	assert.Equal(t, "firefox-sha1", sha1Product("firefox-48.0build1-ssl"))
+	assert.Equal(t, "firefox-sha1", sha1Product("firefox-49.0b8build2"))
+	assert.Equal(t, "firefox-48.0build1-complete", sha1Product("firefox-48.0build1-complete"))
+	assert.Equal(t, "firefox-48.0build1-partial-47.0build3", sha1Product("firefox-48.0build1-partial-47.0build3"))
+	assert.Equal(t, "thunderbird-38.5.0", sha1Product("thunderbird-38.6.0build1"))
+	assert.Equal(t, "thunderbird-38.5.0-ssl", sha1Product("thunderbird-38.6.0build1-ssl"))
+	assert.Equal(t, "thunderbird-38.4.0build2", sha1Product("thunderbird-38.4.0build2"))
+}
+
+func TestSha1ProductAliasSuffixes(t *testing.T) {
+	suffixes := []string{"sha1", "WinSHA1"}
+	// products ending with any of the suffixes are left untouched
+	assert.Equal(t, "firefox-esr-sha1", sha1ProductWithSuffixes("firefox-esr-sha1", suffixes))
+	assert.Equal(t, "firefox-winsha1", sha1ProductWithSuffixes("firefox-winsha1", suffixes))
+	assert.Equal(t, "firefox-45.0esr-winsha1", sha1ProductWithSuffixes("firefox-45.0esr-winsha1", suffixes))
+	assert.Equal(t, "firefox-sha1", sha1ProductWithSuffixes("firefox-latest", suffixes))
+
+	// by default only sha1 is
+	assert.Equal(t, "firefox-sha1", sha1Product("firefox-winsha1"))
+
+	// without sha1, legacy sha1 aliases are rewritten to firefox-sha1
+	assert.Equal(t, "firefox-sha1", sha1ProductWithSuffixes("firefox-esr-sha1", []string{"winsha1"}))
+	assert.Equal(t, "firefox-sha1", sha1ProductWithSuffixes("firefox-45.0esr-sha1", []string{}))
+
+	// thunderbird products aren't affected
+	assert.Equal(t, "thunderbird-38.5.0", sha1ProductWithSuffixes("thunderbird-latest", suffixes))
+}
+
+func TestStripBuildNumber(t *testing.T) {
+	assert.Equal(t, "firefox-48.0", stripBuildNumber("firefox-48.0build1"))
+	assert.Equal(t, "firefox-48.0-ssl", stripBuildNumber("firefox-48.0build1-ssl"))
+	assert.Equal(t, "firefox-48.0b10", stripBuildNumber("firefox-48.0b10build12"))
+	assert.Equal(t, "firefox-48.0-partial-47.0", stripBuildNumber("firefox-48.0build1-partial-47.0build3"))
+	assert.Equal(t, "firefox-48.0", stripBuildNumber("firefox-48.0"))
+	assert.Equal(t, "firefox-latest", stripBuildNumber("firefox-latest"))
 }
 
 func TestOsxEsrProduct(t *testing.T) {
@@ -397,3 +666,1627 @@ func BenchmarkSha1Product(b *testing.B) {
 		sha1Product("firefox-44.0b1")
 	}
 }
+
+func TestBouncerHandlerMirrorDefaultScheme(t *testing.T) {
+	testRequests := []struct {
+		URL              string
+		ExpectedLocation string
+		Scheme           string
+	}{
+		{"http://test/?product=firefox-latest&os=osx&lang=en-US", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", "https"},
+		{"http://test/?product=firefox-latest&os=osx&lang=en-US", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", "http"},
+		{"http://test/?product=Firefox-SSL&os=osx&lang=en-US", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", "http"},
+	}
+
+	for _, testRequest := range testRequests {
+		handler := &BouncerHandler{
+			db: bouncerHandler.db,
+			MirrorDefaultSchemes: map[string]string{
+				"download-installer.cdn.mozilla.net": testRequest.Scheme,
+			},
+		}
+
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v scheme: %v", testRequest.URL, testRequest.Scheme)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, 302, w.Code, "url: %v scheme: %v", testRequest.URL, testRequest.Scheme)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v scheme: %v", testRequest.URL, testRequest.Scheme)
+	}
+}
+
+func TestBouncerHandlerBuildNumber(t *testing.T) {
+	defaultUA := "Mozilla/5.0 (Windows NT 7.0; rv:10.0) Gecko/20100101 Firefox/43.0"
+	xpUA := "Mozilla/5.0 (Windows; U; MSIE 6.0; Windows NT 5.1; SV1; .NET CLR 2.0.50727)"
+	testRequests := []struct {
+		URL              string
+		ExpectedLocation string
+		UserAgent        string
+	}{
+		{"http://test/?product=Firefox-43.0.1build1-SSL&os=win&lang=en-US", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/43.0.1/win32/en-US/Firefox%20Setup%2043.0.1.exe", defaultUA},
+		{"http://test/?product=Firefox-43.0.1build1-SSL&os=osx&lang=en-US", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/43.0.1/mac/en-US/Firefox%2043.0.1.dmg", defaultUA},
+		{"http://test/?product=Firefox-48.0build1&os=win&lang=en-US", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/43.0.1/win32/en-US/Firefox%20Setup%2043.0.1.exe", xpUA}, // Windows XP
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v ua: %v", testRequest.URL, testRequest.UserAgent)
+
+		req.Header.Set("User-Agent", testRequest.UserAgent)
+
+		bouncerHandler.ServeHTTP(w, req)
+		assert.Equal(t, 302, w.Code, "url: %v ua: %v", testRequest.URL, testRequest.UserAgent)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v ua: %v", testRequest.URL, testRequest.UserAgent)
+	}
+}
+
+func TestBouncerHandlerMultipleChoices(t *testing.T) {
+	handler := &BouncerHandler{
+		db:              bouncerHandler.db,
+		InferOS:         true,
+		MultipleChoices: true,
+	}
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=all&lang=en-US", nil)
+	assert.NoError(t, err)
+
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 300, w.Code)
+	assert.Equal(t, "application/json", w.HeaderMap.Get("Content-Type"))
+
+	var result struct {
+		Choices []osChoice
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, []osChoice{
+		{"osx", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{"win", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win32/en-US/Firefox%20Setup%2039.0.exe"},
+		{"win64", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win64/en-US/Firefox%20Setup%2039.0.exe"},
+	}, result.Choices)
+
+	// An inferable user agent isn't ambiguous
+	w = httptest.NewRecorder()
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:78.0) Gecko/20100101 Firefox/78.0")
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 302, w.Code)
+	assert.Equal(t, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", w.HeaderMap.Get("Location"))
+
+	// Neither is an explicit os
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "http://test/?product=firefox-latest&os=win64&lang=en-US", nil)
+	assert.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 302, w.Code)
+	assert.Equal(t, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win64/en-US/Firefox%20Setup%2039.0.exe", w.HeaderMap.Get("Location"))
+
+	// Without MultipleChoices, os=all is an unknown os
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "http://test/?product=firefox-latest&os=all&lang=en-US", nil)
+	assert.NoError(t, err)
+	bouncerHandler.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestBouncerHandlerMultipleChoicesCanonicalOSKeys(t *testing.T) {
+	choices := func(handler *BouncerHandler) []string {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=all&lang=en-US", nil)
+		assert.NoError(t, err)
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, 300, w.Code)
+
+		var result struct {
+			Choices []osChoice
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		oses := []string{}
+		for _, c := range result.Choices {
+			oses = append(oses, c.OS)
+		}
+		return oses
+	}
+
+	handler := &BouncerHandler{
+		db:              bouncerHandler.db,
+		MultipleChoices: true,
+	}
+	assert.Equal(t, []string{"osx", "win", "win64"}, choices(handler))
+
+	handler.CanonicalOSKeys = true
+	assert.Equal(t, []string{"mac", "win32", "win64"}, choices(handler))
+
+	handler.OSCanonicalNames = map[string]string{"win64": "windows-x64"}
+	assert.Equal(t, []string{"osx", "win", "windows-x64"}, choices(handler))
+}
+
+func TestBouncerHandlerResolvedLang(t *testing.T) {
+	testRequests := []struct {
+		URL              string
+		ExpectedLocation string
+		ExpectedLang     string
+	}{
+		{"http://test/?product=firefox-latest&os=osx&lang=en-US", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", "en-US"},
+		{"http://test/?product=firefox-latest&os=osx&lang=en-gb", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-GB/Firefox%2039.0.dmg", "en-GB"},
+		{"http://test/?product=firefox-latest&os=osx&lang=EN-gb&print=yes", "", "en-GB"},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+
+		bouncerHandler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v", testRequest.URL)
+		assert.Equal(t, testRequest.ExpectedLang, w.HeaderMap.Get("X-Bouncer-Resolved-Lang"), "url: %v", testRequest.URL)
+	}
+
+	// Requests that don't resolve have no lang
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=fr", nil)
+	assert.NoError(t, err)
+	bouncerHandler.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+	assert.Equal(t, "", w.HeaderMap.Get("X-Bouncer-Resolved-Lang"))
+}
+
+func TestBouncerHandlerClientOSHeader(t *testing.T) {
+	handler := &BouncerHandler{
+		db:                 bouncerHandler.db,
+		InferOS:            true,
+		ClientOSHeaderName: "X-Client-OS",
+	}
+
+	macUA := "Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:78.0) Gecko/20100101 Firefox/78.0"
+	testRequests := []struct {
+		URL              string
+		ExpectedLocation string
+		UserAgent        string
+		ClientOS         string
+	}{
+		{"http://test/?product=firefox-latest&lang=en-US", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", "", "osx"},
+		{"http://test/?product=firefox-latest&os=default&lang=en-US", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win64/en-US/Firefox%20Setup%2039.0.exe", "Java/1.8.0", "Win64"},
+		{"http://test/?product=firefox-latest&lang=en-US", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win32/en-US/Firefox%20Setup%2039.0.exe", "", ""},
+		{"http://test/?product=firefox-latest&lang=en-US", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", macUA, "win64"},
+		{"http://test/?product=firefox-latest&os=win&lang=en-US", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win32/en-US/Firefox%20Setup%2039.0.exe", "", "osx"},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v os: %v", testRequest.URL, testRequest.ClientOS)
+
+		req.Header.Set("User-Agent", testRequest.UserAgent)
+		req.Header.Set("X-Client-OS", testRequest.ClientOS)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, 302, w.Code, "url: %v os: %v", testRequest.URL, testRequest.ClientOS)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v os: %v", testRequest.URL, testRequest.ClientOS)
+	}
+}
+
+func TestSplitProductLocale(t *testing.T) {
+	tests := []struct {
+		In      string
+		Product string
+		Lang    string
+	}{
+		{"firefox-48.0-fr", "firefox-48.0", "fr"},
+		{"firefox-48.0-pt-br", "firefox-48.0", "pt-BR"},
+		{"firefox-latest-ach", "firefox-latest", "ach"},
+		{"firefox-48.0", "firefox-48.0", ""},
+		{"firefox-latest", "firefox-latest", ""},
+		{"firefox-beta-latest", "firefox-beta-latest", ""},
+		{"firefox-48.0-ssl", "firefox-48.0-ssl", ""},
+		{"firefox-esr", "firefox-esr", ""},
+		{"firefox-msi-latest-ssl", "firefox-msi-latest-ssl", ""},
+		{"firefox-48.0build1-partial-47.0build3", "firefox-48.0build1-partial-47.0build3", ""},
+		{"firefox", "firefox", ""},
+	}
+	for _, test := range tests {
+		product, lang := splitProductLocale(test.In)
+		assert.Equal(t, test.Product, product, "product: %v", test.In)
+		assert.Equal(t, test.Lang, lang, "product: %v", test.In)
+	}
+}
+
+func TestBouncerHandlerProductLocale(t *testing.T) {
+	handler := &BouncerHandler{
+		db:                 bouncerHandler.db,
+		ParseProductLocale: true,
+	}
+
+	testRequests := []struct {
+		URL              string
+		ExpectedLocation string
+	}{
+		{"http://test/?product=firefox-latest-en-gb&os=osx", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-GB/Firefox%2039.0.dmg"},
+		{"http://test/?product=firefox-latest-en-gb&os=osx&lang=en-US", ""},
+		{"http://test/?product=firefox-latest&os=osx", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{"http://test/?product=firefox-ssl&os=osx", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v", testRequest.URL)
+	}
+}
+
+func TestBouncerHandlerProductPathPrefix(t *testing.T) {
+	testRequests := []struct {
+		Prefixes         map[string]string
+		URL              string
+		ExpectedLocation string
+	}{
+		{nil, "http://test/?product=firefox-ssl&os=osx&lang=en-US", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{map[string]string{"firefox-ssl": "/legacy"}, "http://test/?product=firefox-ssl&os=osx&lang=en-US", "https://download-installer.cdn.mozilla.net/pub/legacy/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{map[string]string{"firefox-ssl": "/legacy"}, "http://test/?product=firefox-latest&os=osx&lang=en-US", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{map[string]string{"firefox": "/legacy"}, "http://test/?product=firefox-ssl&os=osx&lang=en-US", "https://download-installer.cdn.mozilla.net/pub/legacy/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{map[string]string{"firefox": "/legacy", "firefox-ssl": ""}, "http://test/?product=firefox-ssl&os=osx&lang=en-US", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{map[string]string{"thunderbird": "/legacy"}, "http://test/?product=firefox-ssl&os=osx&lang=en-US", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+	}
+
+	for _, testRequest := range testRequests {
+		handler := &BouncerHandler{
+			db:                  bouncerHandler.db,
+			ProductPathPrefixes: testRequest.Prefixes,
+		}
+
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v prefixes: %v", testRequest.URL, testRequest.Prefixes)
+	}
+}
+
+func TestRobotsHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/robots.txt", nil)
+	assert.NoError(t, err)
+
+	(&RobotsHandler{}).ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, DisallowAllRobotsTxt, w.Body.String())
+	assert.Equal(t, "", w.HeaderMap.Get("Location"))
+
+	w = httptest.NewRecorder()
+	(&RobotsHandler{Body: "User-agent: *\nDisallow:\n", CacheTime: time.Minute}).ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "User-agent: *\nDisallow:\n", w.Body.String())
+	assert.Equal(t, "max-age=60", w.HeaderMap.Get("Cache-Control"))
+}
+
+func TestFaviconHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/favicon.ico", nil)
+	assert.NoError(t, err)
+
+	(&FaviconHandler{}).ServeHTTP(w, req)
+	assert.Equal(t, 204, w.Code)
+	assert.Equal(t, "", w.HeaderMap.Get("Location"))
+
+	w = httptest.NewRecorder()
+	(&FaviconHandler{RedirectURL: "https://www.mozilla.org/favicon.ico"}).ServeHTTP(w, req)
+	assert.Equal(t, 302, w.Code)
+	assert.Equal(t, "https://www.mozilla.org/favicon.ico", w.HeaderMap.Get("Location"))
+
+	// the bouncer handler redirects the same path to mozilla.org
+	w = httptest.NewRecorder()
+	bouncerHandler.ServeHTTP(w, req)
+	assert.Equal(t, "https://www.mozilla.org/", w.HeaderMap.Get("Location"))
+}
+
+func TestBouncerHandlerSha1RewriteProducts(t *testing.T) {
+	xpUA := "Mozilla/5.0 (Windows; U; MSIE 6.0; Windows NT 5.1; SV1; .NET CLR 2.0.50727)"
+	testRequests := []struct {
+		Products         []string
+		URL              string
+		ExpectedLocation string
+	}{
+		{nil, "http://test/?product=Firefox-SSL&os=win&lang=en-US", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/43.0.1/win32/en-US/Firefox%20Setup%2043.0.1.exe"},
+		{[]string{"firefox-ssl"}, "http://test/?product=Firefox-SSL&os=win&lang=en-US", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/43.0.1/win32/en-US/Firefox%20Setup%2043.0.1.exe"},
+		{[]string{"firefox-stub"}, "http://test/?product=Firefox-SSL&os=win&lang=en-US", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win32/en-US/Firefox%20Setup%2039.0.exe"},
+	}
+
+	for _, testRequest := range testRequests {
+		handler := &BouncerHandler{
+			db:                  bouncerHandler.db,
+			SHA1RewriteProducts: testRequest.Products,
+		}
+
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+		req.Header.Set("User-Agent", xpUA)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v products: %v", testRequest.URL, testRequest.Products)
+	}
+}
+
+func TestBouncerHandlerCacheTimeOverride(t *testing.T) {
+	_, trusted, err := net.ParseCIDR("192.0.2.0/24")
+	assert.NoError(t, err)
+
+	handler := &BouncerHandler{
+		db:           bouncerHandler.db,
+		CacheTime:    time.Hour,
+		TrustedCIDRs: []*net.IPNet{trusted},
+	}
+
+	testRequests := []struct {
+		RemoteAddr           string
+		CacheTime            string
+		ExpectedCacheControl string
+	}{
+		{"192.0.2.1:54321", "", "max-age=3600"},
+		{"192.0.2.1:54321", "60", "max-age=60"},
+		{"192.0.2.1:54321", "0", ""},
+		{"192.0.2.1:54321", "soon", "max-age=3600"},
+		{"192.0.2.1:54321", "-60", "max-age=3600"},
+		{"198.51.100.1:54321", "60", "max-age=3600"},
+		{"", "60", "max-age=3600"},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US", nil)
+		assert.NoError(t, err)
+		req.RemoteAddr = testRequest.RemoteAddr
+		if testRequest.CacheTime != "" {
+			req.Header.Set(CacheTimeHeaderName, testRequest.CacheTime)
+		}
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, 302, w.Code)
+		assert.Equal(t, testRequest.ExpectedCacheControl, w.HeaderMap.Get("Cache-Control"), "remote: %v cache time: %v", testRequest.RemoteAddr, testRequest.CacheTime)
+	}
+}
+
+func TestTorrentURL(t *testing.T) {
+	handler := &BouncerHandler{Torrents: true}
+	assert.Equal(t, "/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg.torrent", handler.torrentURL("/firefox/releases/39.0/mac/:lang/Firefox%2039.0.dmg", "en-US"))
+	assert.Equal(t, "/firefox/releases/39.0/linux-x86_64/fr/firefox-39.0.tar.bz2.torrent", handler.torrentURL("/firefox/releases/39.0/linux-x86_64/:lang/firefox-39.0.tar.bz2", "fr"))
+	assert.Equal(t, "", handler.torrentURL("/firefox/releases/39.0/update/win32/:lang/firefox-39.0.complete.mar", "en-US"))
+
+	handler.TorrentPathTemplate = "/torrents/{lang}{path}.torrent"
+	assert.Equal(t, "/torrents/de/firefox/releases/39.0/win32/de/Firefox%20Setup%2039.0.exe.torrent", handler.torrentURL("/firefox/releases/39.0/win32/:lang/Firefox%20Setup%2039.0.exe", "de"))
+
+	handler = &BouncerHandler{}
+	assert.Equal(t, "", handler.torrentURL("/firefox/releases/39.0/mac/:lang/Firefox%2039.0.dmg", "en-US"))
+}
+
+func TestBouncerHandlerTorrent(t *testing.T) {
+	handler := &BouncerHandler{
+		db:       bouncerHandler.db,
+		Torrents: true,
+	}
+
+	testRequests := []struct {
+		URL              string
+		ExpectedLocation string
+		ExpectedCode     int
+	}{
+		{"http://test/?product=firefox-latest&os=osx&lang=en-US&format=torrent", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg.torrent", 302},
+		{"http://test/?product=firefox-ssl&os=win&lang=en-GB&format=torrent", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win32/en-GB/Firefox%20Setup%2039.0.exe.torrent", 302},
+		{"http://test/?product=firefox-latest&os=osx&lang=en-US", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", 302},
+		{"http://test/?product=firefox-unknown&os=osx&lang=en-US&format=torrent", "", 404},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedCode, w.Code, "url: %v", testRequest.URL)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v", testRequest.URL)
+	}
+
+	// torrents are disabled by default
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US&format=torrent", nil)
+	assert.NoError(t, err)
+	bouncerHandler.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+}
+
+type stubHealthSource struct {
+	pingErr  error
+	products int
+	mirrors  int
+}
+
+func (s *stubHealthSource) Ping() error                      { return s.pingErr }
+func (s *stubHealthSource) ProductCount() (int, error)       { return s.products, nil }
+func (s *stubHealthSource) HealthyMirrorCount() (int, error) { return s.mirrors, nil }
+
+func TestHealthHandlerDetail(t *testing.T) {
+	handler := &HealthHandler{
+		db: &stubHealthSource{products: 12, mirrors: 3},
+	}
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/__heartbeat__?detail=1", nil)
+	assert.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	result := &HealthResult{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), result))
+	assert.True(t, result.Healthy)
+	if assert.NotNil(t, result.Detail) {
+		assert.True(t, result.Detail.DB.Healthy)
+		assert.True(t, result.Detail.DB.LatencyMS >= 0)
+		assert.Equal(t, 12, result.Detail.Catalog.Products)
+		assert.Equal(t, 3, result.Detail.Mirrors.Healthy)
+	}
+
+	// without detail=1 only the aggregate is returned
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "http://test/__heartbeat__", nil)
+	assert.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.NotContains(t, w.Body.String(), "detail")
+}
+
+func TestHealthHandlerDetailDBDown(t *testing.T) {
+	handler := &HealthHandler{
+		db: &stubHealthSource{pingErr: fmt.Errorf("connection refused"), products: 12, mirrors: 3},
+	}
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/__heartbeat__?detail=1", nil)
+	assert.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 500, w.Code)
+
+	result := &HealthResult{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), result))
+	assert.False(t, result.Healthy)
+	assert.False(t, result.DB)
+	if assert.NotNil(t, result.Detail) {
+		assert.False(t, result.Detail.DB.Healthy)
+		assert.Equal(t, 0, result.Detail.Catalog.Products)
+		assert.Equal(t, 0, result.Detail.Mirrors.Healthy)
+	}
+}
+
+func TestHealthHandlerDetailDB(t *testing.T) {
+	handler := &HealthHandler{db: bouncerHandler.db.(*bouncer.DB)}
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/__heartbeat__?detail=1", nil)
+	assert.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	result := &HealthResult{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), result))
+	if assert.NotNil(t, result.Detail) {
+		assert.Equal(t, 3, result.Detail.Catalog.Products)
+		assert.Equal(t, 2, result.Detail.Mirrors.Healthy)
+	}
+}
+
+func TestBouncerHandlerEmptyProductPolicy(t *testing.T) {
+	testRequests := []struct {
+		Policy           EmptyProductPolicy
+		URL              string
+		ExpectedCode     int
+		ExpectedLocation string
+	}{
+		{"", "http://test/", 302, "https://www.mozilla.org/"},
+		{"", "http://test/?os=osx&lang=en-US", 302, "https://www.mozilla.org/"},
+		{EmptyProductRedirect, "http://test/?os=osx", 302, "https://www.mozilla.org/"},
+		{EmptyProductBadRequest, "http://test/", 302, "https://www.mozilla.org/"},
+		{EmptyProductBadRequest, "http://test/?os=osx&lang=en-US", 400, ""},
+		{EmptyProductBadRequest, "http://test/?product=", 400, ""},
+		{EmptyProductBadRequest, "http://test/?product=firefox-latest&os=osx&lang=en-US", 302, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+	}
+
+	for _, testRequest := range testRequests {
+		handler := &BouncerHandler{
+			db:                 bouncerHandler.db,
+			EmptyProductPolicy: testRequest.Policy,
+		}
+
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedCode, w.Code, "url: %v policy: %v", testRequest.URL, testRequest.Policy)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v policy: %v", testRequest.URL, testRequest.Policy)
+	}
+}
+
+func TestBouncerHandlerBundle(t *testing.T) {
+	handler := &BouncerHandler{
+		db: bouncerHandler.db,
+		Bundles: map[string][]string{
+			"firefox-suite":  {"firefox-latest", "firefox-ssl", "firefox-unknown"},
+			"unknown-bundle": {"firefox-unknown"},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-suite&os=osx&lang=en-US&format=json", nil)
+	assert.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "application/json", w.HeaderMap.Get("Content-Type"))
+
+	bundle := struct {
+		Products []bundleProduct `json:"products"`
+	}{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &bundle))
+	assert.Equal(t, []bundleProduct{
+		{Product: "firefox-latest", URL: "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{Product: "firefox-ssl", URL: "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+	}, bundle.Products)
+
+	// plain requests are redirected to the primary product
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "http://test/?product=firefox-suite&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 302, w.Code)
+	assert.Equal(t, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", w.HeaderMap.Get("Location"))
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "http://test/?product=unknown-bundle&os=osx&lang=en-US&format=json", nil)
+	assert.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestBouncerHandlerShortCode(t *testing.T) {
+	handler := &BouncerHandler{
+		db: bouncerHandler.db,
+		ShortCodes: map[string]ResolveTuple{
+			"ff-mac":    {Product: "firefox-latest", OS: "osx"},
+			"ff-mac-gb": {Product: "firefox-latest", OS: "osx", Lang: "en-GB"},
+		},
+	}
+
+	testRequests := []struct {
+		URL              string
+		ExpectedLocation string
+	}{
+		{"http://test/?c=ff-mac", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{"http://test/?c=FF-MAC-GB", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-GB/Firefox%2039.0.dmg"},
+		{"http://test/?c=ff-mac-gb&lang=en-US", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{"http://test/?c=ff-mac&os=win64", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win64/en-US/Firefox%20Setup%2039.0.exe"},
+		{"http://test/?c=ff-mac&product=firefox-ssl", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{"http://test/?c=unknown", "https://www.mozilla.org/"},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v", testRequest.URL)
+	}
+}
+
+func TestBouncerHandlerCleanOSLang(t *testing.T) {
+	const location = "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"
+	handler := &BouncerHandler{db: bouncerHandler.db, CleanOSLang: true}
+
+	testRequests := []struct {
+		Handler          *BouncerHandler
+		URL              string
+		ExpectedCode     int
+		ExpectedLocation string
+	}{
+		{handler, "http://test/?product=firefox-latest&os=osx.&lang=en-US", 302, location},
+		{handler, "http://test/?product=firefox-latest&os=osx&lang=en-US.", 302, location},
+		{handler, "http://test/?product=firefox-latest&os=osx&lang=en--US", 302, location},
+		{handler, "http://test/?product=firefox-latest&os=osx..&lang=en--US.", 302, location},
+		// without cleaning, the os doesn't exist
+		{&BouncerHandler{db: bouncerHandler.db}, "http://test/?product=firefox-latest&os=osx.&lang=en-US", 404, ""},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+
+		testRequest.Handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedCode, w.Code, "url: %v", testRequest.URL)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v", testRequest.URL)
+	}
+}
+
+func TestBouncerHandlerSpec(t *testing.T) {
+	testRequests := []struct {
+		URL              string
+		ExpectedCode     int
+		ExpectedLocation string
+	}{
+		// firefox-latest|win64|en-US
+		{"http://test/?spec=ZmlyZWZveC1sYXRlc3R8d2luNjR8ZW4tVVM", 302, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win64/en-US/Firefox%20Setup%2039.0.exe"},
+		// padded
+		{"http://test/?spec=ZmlyZWZveC1sYXRlc3R8d2luNjR8ZW4tVVM%3D", 302, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win64/en-US/Firefox%20Setup%2039.0.exe"},
+		// Firefox-SSL|win64|en-GB
+		{"http://test/?spec=RmlyZWZveC1TU0x8d2luNjR8ZW4tR0I", 302, "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win64/en-GB/Firefox%20Setup%2039.0.exe"},
+		// firefox-latest|osx|, explicit params take precedence
+		{"http://test/?spec=ZmlyZWZveC1sYXRlc3R8b3N4fA&lang=en-GB", 302, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-GB/Firefox%2039.0.dmg"},
+		// malformed
+		{"http://test/?spec=not%20base64", 400, ""},
+		// firefox-latest|osx
+		{"http://test/?spec=ZmlyZWZveC1sYXRlc3R8b3N4", 400, ""},
+		// firefox latest|osx|en-US
+		{"http://test/?spec=ZmlyZWZveCBsYXRlc3R8b3N4fGVuLVVT", 400, ""},
+		// |osx|en-US
+		{"http://test/?spec=fG9zeHxlbi1VUw", 400, ""},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+
+		bouncerHandler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedCode, w.Code, "url: %v", testRequest.URL)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v", testRequest.URL)
+	}
+}
+
+// recordingMetrics counts the metrics it receives
+type recordingMetrics struct {
+	counts map[string]int
+}
+
+func (r *recordingMetrics) Incr(name string) {
+	if r.counts == nil {
+		r.counts = map[string]int{}
+	}
+	r.counts[name]++
+}
+
+func (r *recordingMetrics) Timing(name string, d time.Duration) {
+	r.Incr(name)
+}
+
+// failingMirrorsCatalog is a catalog whose mirrors can't be listed
+type failingMirrorsCatalog struct {
+	Catalog
+}
+
+func (f *failingMirrorsCatalog) Mirrors(sslOnly bool) ([]bouncer.MirrorsResult, error) {
+	return nil, fmt.Errorf("mirrors unavailable")
+}
+
+func TestBouncerHandlerMirrorFallback(t *testing.T) {
+	metrics := &recordingMetrics{}
+	handler := &BouncerHandler{
+		db:                &failingMirrorsCatalog{bouncerHandler.db},
+		MirrorFallbackURL: "https://static.cdn.mozilla.net/pub",
+		Metrics:           metrics,
+	}
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 302, w.Code)
+	assert.Equal(t, "https://static.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", w.HeaderMap.Get("Location"))
+	assert.Equal(t, 1, metrics.counts["mirror.fallback"])
+
+	// without a fallback the request fails
+	handler.MirrorFallbackURL = ""
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 500, w.Code)
+	assert.Equal(t, "", w.HeaderMap.Get("Location"))
+	assert.Equal(t, 1, metrics.counts["mirror.fallback"])
+}
+
+func TestBouncerHandlerScheme(t *testing.T) {
+	testRequests := []struct {
+		URL              string
+		PinHeader        string
+		ExpectedLocation string
+	}{
+		{"http://test/?product=firefox-latest&os=osx&lang=en-US&scheme=https", "", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{"http://test/?product=firefox-latest&os=osx&lang=en-US&scheme=HTTPS", "", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{"http://test/?product=firefox-latest&os=osx&lang=en-US&scheme=http", "https", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{"http://test/?product=firefox-latest&os=osx&lang=en-US&scheme=ftp", "", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{"http://test/?product=firefox-latest&os=osx&lang=en-US&scheme=ftp", "https", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{"http://test/?product=firefox-ssl&os=osx&lang=en-US&scheme=http", "", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+		if testRequest.PinHeader != "" {
+			req.Header.Set("X-Forwarded-Proto", testRequest.PinHeader)
+		}
+
+		bouncerHandler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v pin: %v", testRequest.URL, testRequest.PinHeader)
+	}
+}
+
+func TestBouncerHandlerAdminCacheTimeOverride(t *testing.T) {
+	_, admin, err := net.ParseCIDR("203.0.113.0/24")
+	assert.NoError(t, err)
+
+	handler := &BouncerHandler{
+		db:         bouncerHandler.db,
+		CacheTime:  time.Hour,
+		AdminCIDRs: []*net.IPNet{admin},
+	}
+
+	testRequests := []struct {
+		RemoteAddr           string
+		ExpectedCacheControl string
+	}{
+		{"203.0.113.7:54321", "max-age=60"},
+		{"198.51.100.1:54321", "max-age=3600"},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US", nil)
+		assert.NoError(t, err)
+		req.RemoteAddr = testRequest.RemoteAddr
+		req.Header.Set(CacheTimeHeaderName, "60")
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedCacheControl, w.HeaderMap.Get("Cache-Control"), "remote: %v", testRequest.RemoteAddr)
+	}
+}
+
+func TestXPSupported(t *testing.T) {
+	handler := &BouncerHandler{
+		XPSupportedUntil: map[string]string{
+			"firefox":        "52.9.0",
+			"firefox-49.0b8": "49.0",
+		},
+	}
+
+	assert.True(t, handler.xpSupported("firefox-48.0-ssl"))
+	assert.True(t, handler.xpSupported("firefox-52.9.0"))
+	assert.True(t, handler.xpSupported("firefox-52.0build1-stub"))
+	assert.False(t, handler.xpSupported("firefox-53.0"))
+	assert.False(t, handler.xpSupported("firefox-60.0esr"))
+	assert.True(t, handler.xpSupported("firefox-latest"))
+	assert.True(t, handler.xpSupported("thunderbird-60.0"))
+	assert.True(t, (&BouncerHandler{}).xpSupported("firefox-60.0"))
+}
+
+func TestBouncerHandlerXPSupportedUntil(t *testing.T) {
+	xpUA := "Mozilla/5.0 (Windows; U; MSIE 6.0; Windows NT 5.1; SV1; .NET CLR 2.0.50727)"
+	testRequests := []struct {
+		Until            string
+		URL              string
+		ExpectedLocation string
+	}{
+		{"52.9.0", "http://test/?product=firefox-48.0-ssl&os=win&lang=en-US", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/43.0.1/win32/en-US/Firefox%20Setup%2043.0.1.exe"},
+		{"47.0", "http://test/?product=firefox-48.0-ssl&os=win&lang=en-US", ""},
+		{"47.0", "http://test/?product=firefox-ssl&os=win&lang=en-US", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/43.0.1/win32/en-US/Firefox%20Setup%2043.0.1.exe"},
+	}
+
+	for _, testRequest := range testRequests {
+		handler := &BouncerHandler{
+			db:               bouncerHandler.db,
+			XPSupportedUntil: map[string]string{"firefox": testRequest.Until},
+		}
+
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+		req.Header.Set("User-Agent", xpUA)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v until: %v", testRequest.URL, testRequest.Until)
+	}
+}
+
+func TestBouncerHandlerChannelParam(t *testing.T) {
+	handler := &BouncerHandler{db: betaCatalog()}
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&channel=beta&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", w.HeaderMap.Get("Location"))
+}
+
+func TestBouncerHandlerChannelCasing(t *testing.T) {
+	handler := &BouncerHandler{db: betaCatalog()}
+
+	expected := "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"
+	for _, product := range []string{"firefox-beta-latest", "Firefox-Beta-Latest", "FIREFOX-BETA-LATEST"} {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://test/?os=osx&lang=en-US&product="+product, nil)
+		assert.NoError(t, err)
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, 302, w.Code, "product: %v", product)
+		assert.Equal(t, expected, w.HeaderMap.Get("Location"), "product: %v", product)
+	}
+}
+
+func TestBouncerHandlerLanguageRegion(t *testing.T) {
+	testRequests := []struct {
+		Regions          map[string]string
+		URL              string
+		ExpectedLocation string
+	}{
+		{nil, "http://test/?product=firefox-latest&os=osx&lang=en", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-GB/Firefox%2039.0.dmg"},
+		{map[string]string{"en": "US"}, "http://test/?product=firefox-latest&os=osx&lang=en", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{map[string]string{"en": "AU"}, "http://test/?product=firefox-latest&os=osx&lang=en", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-GB/Firefox%2039.0.dmg"},
+		{map[string]string{"en": "US"}, "http://test/?product=firefox-latest&os=osx&lang=en-GB", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-GB/Firefox%2039.0.dmg"},
+		{nil, "http://test/?product=firefox-latest&os=osx&lang=fr", ""},
+	}
+
+	for _, testRequest := range testRequests {
+		handler := &BouncerHandler{
+			db:              bouncerHandler.db,
+			LanguageRegions: testRequest.Regions,
+		}
+
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v regions: %v", testRequest.URL, testRequest.Regions)
+	}
+}
+
+func TestBouncerHandlerLocaleFallbackTable(t *testing.T) {
+	handler := &BouncerHandler{
+		db: bouncerHandler.db,
+		LocaleFallbackTable: map[string]string{
+			"en-au": "en-US",
+			"en-nz": "en",
+			"en-ie": "fr-FR",
+		},
+	}
+
+	testRequests := []struct {
+		URL              string
+		ExpectedLocation string
+	}{
+		{"http://test/?product=firefox-latest&os=osx&lang=en-AU", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{"http://test/?product=firefox-latest&os=osx&lang=En-au", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		// fallbacks without a region get a region of the language
+		{"http://test/?product=firefox-latest&os=osx&lang=en-NZ", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-GB/Firefox%2039.0.dmg"},
+		// langs the product has aren't replaced
+		{"http://test/?product=firefox-latest&os=osx&lang=en-GB", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-GB/Firefox%2039.0.dmg"},
+		// unconfigured langs use the generic chain
+		{"http://test/?product=firefox-latest&os=osx&lang=en", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-GB/Firefox%2039.0.dmg"},
+		{"http://test/?product=firefox-latest&os=osx&lang=en-CA", ""},
+		// fallbacks the product doesn't have
+		{"http://test/?product=firefox-latest&os=osx&lang=en-IE", ""},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v", testRequest.URL)
+	}
+}
+
+func TestBouncerHandlerUpgradeWin64(t *testing.T) {
+	const (
+		win64UA = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:68.0) Gecko/20100101 Firefox/68.0"
+		win32UA = "Mozilla/5.0 (Windows NT 10.0; rv:68.0) Gecko/20100101 Firefox/68.0"
+	)
+
+	handler := &BouncerHandler{
+		db:           bouncerHandler.db,
+		UpgradeWin64: true,
+	}
+
+	testRequests := []struct {
+		URL              string
+		UserAgent        string
+		ExpectedLocation string
+	}{
+		{"http://test/?product=firefox-latest&os=win&lang=en-US", win64UA, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win64/en-US/Firefox%20Setup%2039.0.exe"},
+		{"http://test/?product=firefox-latest&os=win&lang=en-US", win32UA, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win32/en-US/Firefox%20Setup%2039.0.exe"},
+		{"http://test/?product=firefox-latest&os=win&lang=en-US&arch=x86", win64UA, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win32/en-US/Firefox%20Setup%2039.0.exe"},
+		{"http://test/?product=firefox-latest&os=osx&lang=en-US", win64UA, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+		req.Header.Set("User-Agent", testRequest.UserAgent)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v ua: %v", testRequest.URL, testRequest.UserAgent)
+	}
+
+	// Without UpgradeWin64, 64-bit clients get what they ask for
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=win&lang=en-US", nil)
+	assert.NoError(t, err)
+	req.Header.Set("User-Agent", win64UA)
+	bouncerHandler.ServeHTTP(w, req)
+	assert.Equal(t, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win32/en-US/Firefox%20Setup%2039.0.exe", w.HeaderMap.Get("Location"))
+}
+
+func TestBouncerHandlerTimingAllowOrigin(t *testing.T) {
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+	bouncerHandler.ServeHTTP(w, req)
+	assert.Equal(t, "", w.HeaderMap.Get("Timing-Allow-Origin"))
+
+	handler := &BouncerHandler{
+		db:                bouncerHandler.db,
+		TimingAllowOrigin: "https://www.mozilla.org",
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 302, w.Code)
+	assert.Equal(t, "https://www.mozilla.org", w.HeaderMap.Get("Timing-Allow-Origin"))
+}
+
+func TestBouncerHandlerContentSecurityPolicy(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	bouncerHandler.ServeHTTP(w, req)
+	assert.Equal(t, 302, w.Code)
+	assert.Contains(t, w.HeaderMap.Get("Content-Type"), "text/html")
+	assert.Equal(t, DefaultContentSecurityPolicy, w.HeaderMap.Get("Content-Security-Policy"))
+
+	handler := &BouncerHandler{
+		db:                    bouncerHandler.db,
+		ContentSecurityPolicy: "default-src 'none'",
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 302, w.Code)
+	assert.Equal(t, "default-src 'none'", w.HeaderMap.Get("Content-Security-Policy"))
+
+	req, err = http.NewRequest("GET", "http://test/", nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 302, w.Code)
+	assert.Equal(t, "default-src 'none'", w.HeaderMap.Get("Content-Security-Policy"))
+}
+
+func TestBouncerHandlerArchive(t *testing.T) {
+	testRequests := []struct {
+		ArchiveProducts  map[string]string
+		URL              string
+		ExpectedLocation string
+	}{
+		{map[string]string{"firefox": "44.0"}, "http://test/?product=firefox-43.0.1-ssl&os=osx&lang=en-US", "https://archive.mozilla.org/pub/firefox/releases/43.0.1/mac/en-US/Firefox%2043.0.1.dmg"},
+		{map[string]string{"firefox": "43.0"}, "http://test/?product=firefox-43.0.1-ssl&os=osx&lang=en-US", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/43.0.1/mac/en-US/Firefox%2043.0.1.dmg"},
+		{map[string]string{"firefox": "44.0"}, "http://test/?product=firefox-latest&os=osx&lang=en-US", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{map[string]string{"firefox": "*"}, "http://test/?product=firefox-latest&os=osx&lang=en-US", "http://archive.mozilla.org/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{map[string]string{"firefox": "*"}, "http://test/?product=firefox-latest&os=osx&lang=en-US&scheme=https", "https://archive.mozilla.org/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{nil, "http://test/?product=firefox-43.0.1-ssl&os=osx&lang=en-US", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/43.0.1/mac/en-US/Firefox%2043.0.1.dmg"},
+	}
+
+	for _, testRequest := range testRequests {
+		handler := &BouncerHandler{
+			db:              bouncerHandler.db,
+			ArchiveBaseURL:  "archive.mozilla.org/pub",
+			ArchiveProducts: testRequest.ArchiveProducts,
+		}
+
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v archive: %v", testRequest.URL, testRequest.ArchiveProducts)
+	}
+}
+
+func TestBouncerHandlerUniversalOS(t *testing.T) {
+	const macUA = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10.14; rv:68.0) Gecko/20100101 Firefox/68.0"
+
+	testRequests := []struct {
+		UniversalOS      map[string]string
+		URL              string
+		UserAgent        string
+		ExpectedLocation string
+	}{
+		// osx stands in for a universal installer
+		{map[string]string{"firefox": "osx"}, "http://test/?product=firefox-latest&lang=en-US", "curl/7.54.0", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{map[string]string{"firefox": "osx"}, "http://test/?product=firefox-latest&os=default&lang=en-US", "", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{map[string]string{"firefox": "web"}, "http://test/?product=firefox-latest&lang=en-US", "curl/7.54.0", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win32/en-US/Firefox%20Setup%2039.0.exe"},
+		{map[string]string{"firefox": "osx"}, "http://test/?product=firefox-latest&os=win64&lang=en-US", "curl/7.54.0", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win64/en-US/Firefox%20Setup%2039.0.exe"},
+		{map[string]string{"firefox": "win64"}, "http://test/?product=firefox-latest&lang=en-US", macUA, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{nil, "http://test/?product=firefox-latest&lang=en-US", "curl/7.54.0", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win32/en-US/Firefox%20Setup%2039.0.exe"},
+	}
+
+	for _, testRequest := range testRequests {
+		handler := &BouncerHandler{
+			db:          bouncerHandler.db,
+			InferOS:     true,
+			UniversalOS: testRequest.UniversalOS,
+		}
+
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+		req.Header.Set("User-Agent", testRequest.UserAgent)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v universal: %v", testRequest.URL, testRequest.UniversalOS)
+	}
+}
+
+func TestBouncerHandlerProductFeatureFlags(t *testing.T) {
+	handler := &BouncerHandler{
+		db:                  betaCatalog(),
+		ProductFeatureFlags: map[string]string{"firefox-ssl": "experiment"},
+	}
+
+	testRequests := []struct {
+		URL              string
+		Header           string
+		Cookie           string
+		ExpectedLocation string
+	}{
+		{"http://test/?product=firefox-beta-latest&os=osx&lang=en-US", "experiment", "", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{"http://test/?product=firefox-ssl&os=osx&lang=en-US", "other, Experiment", "", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{"http://test/?product=firefox-ssl&os=osx&lang=en-US", "", "experiment", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{"http://test/?product=firefox-ssl&os=osx&lang=en-US", "", "", ""},
+		{"http://test/?product=firefox-beta-latest&os=osx&lang=en-US", "other", "", ""},
+		{"http://test/?product=firefox-latest&os=osx&lang=en-US", "", "", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+		if testRequest.Header != "" {
+			req.Header.Set(FeatureFlagsHeaderName, testRequest.Header)
+		}
+		if testRequest.Cookie != "" {
+			req.AddCookie(&http.Cookie{Name: FeatureFlagsCookieName, Value: testRequest.Cookie})
+		}
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v flags: %v", testRequest.URL, testRequest.Header)
+		if testRequest.ExpectedLocation == "" {
+			assert.Equal(t, 404, w.Code, "url: %v", testRequest.URL)
+		}
+	}
+}
+
+func TestBouncerHandlerProductRenames(t *testing.T) {
+	renames := map[string]string{
+		"firefox-old":     "firefox-latest",
+		"firefox-ssl-old": "Firefox-SSL",
+	}
+
+	testRequests := []struct {
+		Canonicalize     bool
+		URL              string
+		ExpectedCode     int
+		ExpectedLocation string
+	}{
+		{false, "http://test/?product=firefox-old&os=osx&lang=en-US", 302, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{false, "http://test/?product=firefox-ssl-old&os=osx&lang=en-US", 302, "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{false, "http://test/?product=firefox-latest&os=osx&lang=en-US", 302, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{true, "http://test/?product=firefox-old&os=osx&lang=en-US", 301, "http://test/?lang=en-US&os=osx&product=firefox-latest"},
+		{true, "http://test/?product=firefox-latest&os=osx&lang=en-US", 302, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+	}
+
+	for _, testRequest := range testRequests {
+		handler := &BouncerHandler{
+			db:                  bouncerHandler.db,
+			ProductRenames:      renames,
+			CanonicalizeRenames: testRequest.Canonicalize,
+		}
+
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedCode, w.Code, "url: %v", testRequest.URL)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v", testRequest.URL)
+	}
+}
+
+func TestBouncerHandlerSSLOnlyDowngrade(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	metrics := &recordingMetrics{}
+	handler := &BouncerHandler{
+		db:                 bouncerHandler.db,
+		PinHttpsHeaderName: "X-Forwarded-Proto",
+		Metrics:            metrics,
+	}
+
+	testRequests := []struct {
+		URL              string
+		PinHeader        string
+		ExpectedLocation string
+		ExpectedBlocked  int
+	}{
+		{"http://test/?product=firefox-ssl&os=osx&lang=en-US&scheme=http", "", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", 1},
+		{"http://test/?product=firefox-ssl&os=osx&lang=en-US&scheme=http", "https", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", 2},
+		{"http://test/?product=firefox-ssl&os=osx&lang=en-US", "", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", 2},
+		{"http://test/?product=firefox-latest&os=osx&lang=en-US&scheme=http", "", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", 2},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+		req.Header.Set("X-Forwarded-Proto", testRequest.PinHeader)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v", testRequest.URL)
+		assert.Equal(t, testRequest.ExpectedBlocked, metrics.counts["scheme.downgrade_blocked"], "url: %v", testRequest.URL)
+	}
+	assert.Contains(t, logs.String(), "Blocked http downgrade of ssl only product firefox-ssl")
+
+	// An http fallback mirror is served over https too
+	handler = &BouncerHandler{
+		db:                &noMirrorsCatalog{bouncerHandler.db},
+		MirrorFallbackURL: "http://static.cdn.mozilla.net/pub",
+	}
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-ssl&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, "https://static.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", w.HeaderMap.Get("Location"))
+}
+
+// winOnlyCatalog is a catalog whose products have no win64 builds
+type winOnlyCatalog struct {
+	Catalog
+}
+
+func (c *winOnlyCatalog) Location(productID, osID string) (string, string, error) {
+	if osID == "1" {
+		return "", "", sql.ErrNoRows
+	}
+	return c.Catalog.Location(productID, osID)
+}
+
+func (c *winOnlyCatalog) ProductLocations(productID string) ([]*bouncer.ProductLocationsResult, error) {
+	locations, err := c.Catalog.ProductLocations(productID)
+	if err != nil {
+		return nil, err
+	}
+	winOnly := make([]*bouncer.ProductLocationsResult, 0, len(locations))
+	for _, location := range locations {
+		if location.OS != "win64" {
+			winOnly = append(winOnly, location)
+		}
+	}
+	return winOnly, nil
+}
+
+func TestBouncerHandlerOSFallback(t *testing.T) {
+	testRequests := []struct {
+		OSFallback       map[string][]string
+		URL              string
+		ExpectedLocation string
+	}{
+		{map[string][]string{"win64": {"win"}}, "http://test/?product=firefox-latest&os=win64&lang=en-US", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win32/en-US/Firefox%20Setup%2039.0.exe"},
+		{map[string][]string{"win64": {"win64-aarch64", "win"}}, "http://test/?product=firefox-latest&os=win64&lang=en-US", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win32/en-US/Firefox%20Setup%2039.0.exe"},
+		{map[string][]string{"osx": {"win"}}, "http://test/?product=firefox-latest&os=osx&lang=en-US", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{nil, "http://test/?product=firefox-latest&os=win64&lang=en-US", ""},
+	}
+
+	for _, testRequest := range testRequests {
+		handler := &BouncerHandler{
+			db:         &winOnlyCatalog{bouncerHandler.db},
+			OSFallback: testRequest.OSFallback,
+		}
+
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v fallback: %v", testRequest.URL, testRequest.OSFallback)
+	}
+
+	// the requested os is served when the product is available on it
+	handler := &BouncerHandler{
+		db:         bouncerHandler.db,
+		OSFallback: map[string][]string{"win64": {"win"}},
+	}
+	url, err := handler.URL(false, "en-US", "win64", "firefox-latest")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win64/en-US/Firefox%20Setup%2039.0.exe", url)
+}
+
+func TestHealthHandlerDependencies(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer stub.Close()
+
+	testHandlers := []struct {
+		Handler         *HealthHandler
+		ExpectedCode    int
+		ExpectedHealthy map[string]bool
+	}{
+		{
+			&HealthHandler{
+				db: &stubHealthSource{mirrors: 0},
+				Dependencies: []DependencyCheck{
+					{Name: "mirrors", Check: MirrorsCheck(&stubHealthSource{mirrors: 0})},
+					{Name: "stub", Check: URLCheck(stub.Client(), stub.URL)},
+				},
+			},
+			200,
+			map[string]bool{"db": true, "mirrors": false, "stub": false},
+		},
+		{
+			&HealthHandler{
+				db: &stubHealthSource{mirrors: 2},
+				Dependencies: []DependencyCheck{
+					{Name: "mirrors", Required: true, Check: MirrorsCheck(&stubHealthSource{mirrors: 2})},
+					{Name: "stub", Required: true, Check: URLCheck(stub.Client(), stub.URL)},
+				},
+			},
+			500,
+			map[string]bool{"db": true, "mirrors": true, "stub": false},
+		},
+		{
+			&HealthHandler{db: &stubHealthSource{pingErr: fmt.Errorf("connection refused")}},
+			500,
+			map[string]bool{"db": false},
+		},
+	}
+
+	for i, testHandler := range testHandlers {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://test/__heartbeat__", nil)
+		assert.NoError(t, err)
+		testHandler.Handler.ServeHTTP(w, req)
+		assert.Equal(t, testHandler.ExpectedCode, w.Code, "handler: %d", i)
+
+		result := &HealthResult{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), result), "handler: %d", i)
+		assert.Equal(t, testHandler.ExpectedCode == 200, result.Healthy, "handler: %d", i)
+		assert.Len(t, result.Dependencies, len(testHandler.ExpectedHealthy), "handler: %d", i)
+		for name, healthy := range testHandler.ExpectedHealthy {
+			assert.Equal(t, healthy, result.Dependencies[name].Healthy, "handler: %d dependency: %v", i, name)
+			assert.True(t, result.Dependencies[name].LatencyMS >= 0, "handler: %d dependency: %v", i, name)
+		}
+		assert.True(t, result.Dependencies["db"].Required, "handler: %d", i)
+	}
+}
+
+func TestBouncerHandlerCacheBusting(t *testing.T) {
+	handler := &BouncerHandler{
+		db:           bouncerHandler.db,
+		CacheTime:    60 * time.Second,
+		CacheBusting: true,
+	}
+
+	const expectedURL = "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"
+
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US&nocache=1", nil)
+	assert.NoError(t, err)
+
+	locations := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, 302, w.Code)
+		assert.Equal(t, "no-store", w.HeaderMap.Get("Cache-Control"))
+
+		location := w.HeaderMap.Get("Location")
+		assert.True(t, strings.HasPrefix(location, expectedURL+"?"+CacheBustingParam+"="), "location: %v", location)
+		locations[location] = true
+	}
+	assert.Len(t, locations, 3)
+
+	// without nocache=1, or with cache busting disabled, urls are unchanged
+	for _, testRequest := range []struct {
+		Handler *BouncerHandler
+		URL     string
+	}{
+		{handler, "http://test/?product=firefox-latest&os=osx&lang=en-US"},
+		{&BouncerHandler{db: bouncerHandler.db, CacheTime: 60 * time.Second}, "http://test/?product=firefox-latest&os=osx&lang=en-US&nocache=1"},
+	} {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err)
+		testRequest.Handler.ServeHTTP(w, req)
+		assert.Equal(t, expectedURL, w.HeaderMap.Get("Location"), "url: %v", testRequest.URL)
+		assert.Equal(t, "max-age=60", w.HeaderMap.Get("Cache-Control"), "url: %v", testRequest.URL)
+	}
+
+	assert.True(t, strings.HasPrefix(cacheBustedURL("http://example.com/a?b=1"), "http://example.com/a?b=1&"+CacheBustingParam+"="))
+}
+
+func TestBouncerHandlerCanonicalSchemeUpgrade(t *testing.T) {
+	handler := &BouncerHandler{
+		db:                     bouncerHandler.db,
+		PreferHTTPS:            true,
+		CanonicalSchemeUpgrade: true,
+	}
+
+	testRequests := []struct {
+		Handler          *BouncerHandler
+		URL              string
+		ExpectedCode     int
+		ExpectedLocation string
+	}{
+		{handler, "http://bouncer.test/?product=firefox-latest&os=osx&lang=en-US&scheme=http", 308, "https://bouncer.test/?lang=en-US&os=osx&product=firefox-latest"},
+		{handler, "http://bouncer.test/?product=firefox-latest&os=osx&lang=en-US", 302, "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		// ssl only products are already served over https
+		{handler, "http://bouncer.test/?product=firefox-ssl&os=osx&lang=en-US&scheme=http", 302, "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		// unknown products aren't upgraded
+		{handler, "http://bouncer.test/?product=firefox-unknown&os=osx&lang=en-US&scheme=http", 404, ""},
+		// without the upgrade, scheme=http is honored
+		{&BouncerHandler{db: bouncerHandler.db, PreferHTTPS: true}, "http://bouncer.test/?product=firefox-latest&os=osx&lang=en-US&scheme=http", 302, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{&BouncerHandler{db: bouncerHandler.db, CanonicalSchemeUpgrade: true}, "http://bouncer.test/?product=firefox-latest&os=osx&lang=en-US&scheme=http", 302, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+
+		testRequest.Handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedCode, w.Code, "url: %v", testRequest.URL)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v", testRequest.URL)
+	}
+}
+
+// httpOnlyMirrorsCatalog is a catalog without https mirrors
+type httpOnlyMirrorsCatalog struct {
+	Catalog
+}
+
+func (c *httpOnlyMirrorsCatalog) Mirrors(sslOnly bool) ([]bouncer.MirrorsResult, error) {
+	if sslOnly {
+		return []bouncer.MirrorsResult{}, nil
+	}
+	return c.Catalog.Mirrors(sslOnly)
+}
+
+func TestBouncerHandlerHTTPSFallback(t *testing.T) {
+	const httpLocation = "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"
+	const httpsLocation = "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"
+
+	handler := &BouncerHandler{db: bouncerHandler.db, HTTPSFallback: true}
+	httpOnlyHandler := &BouncerHandler{db: &httpOnlyMirrorsCatalog{bouncerHandler.db}, HTTPSFallback: true}
+
+	testRequests := []struct {
+		Handler          *BouncerHandler
+		URL              string
+		ExpectedCode     int
+		ExpectedLocation string
+	}{
+		// both bases present, https is chosen
+		{handler, "http://test/?product=firefox-latest&os=osx&lang=en-US", 302, httpsLocation},
+		// only http present, http is chosen
+		{httpOnlyHandler, "http://test/?product=firefox-latest&os=osx&lang=en-US", 302, httpLocation},
+		// the scheme param is honored
+		{handler, "http://test/?product=firefox-latest&os=osx&lang=en-US&scheme=http", 302, httpLocation},
+		{httpOnlyHandler, "http://test/?product=firefox-latest&os=osx&lang=en-US&scheme=https", 404, ""},
+		// ssl only products never fall back to http
+		{httpOnlyHandler, "http://test/?product=firefox-ssl&os=osx&lang=en-US", 404, ""},
+		// without the fallback, http is served
+		{&BouncerHandler{db: bouncerHandler.db}, "http://test/?product=firefox-latest&os=osx&lang=en-US", 302, httpLocation},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+
+		testRequest.Handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedCode, w.Code, "url: %v", testRequest.URL)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v", testRequest.URL)
+	}
+}
+
+func TestBouncerHandlerHTTPSLangs(t *testing.T) {
+	const base = "download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/"
+
+	handler := &BouncerHandler{db: bouncerHandler.db, HTTPSLangs: []string{"en-GB"}}
+	languageHandler := &BouncerHandler{db: bouncerHandler.db, HTTPSLangs: []string{"EN"}}
+	fallbackHandler := &BouncerHandler{db: &httpOnlyMirrorsCatalog{bouncerHandler.db}, HTTPSFallback: true, HTTPSLangs: []string{"en-GB"}}
+
+	testRequests := []struct {
+		Handler          *BouncerHandler
+		URL              string
+		ExpectedCode     int
+		ExpectedLocation string
+	}{
+		// the forced lang is served over https, the other lang over http
+		{handler, "http://test/?product=firefox-latest&os=osx&lang=en-GB", 302, "https://" + base + "en-GB/Firefox%2039.0.dmg"},
+		{handler, "http://test/?product=firefox-latest&os=osx&lang=en-US", 302, "http://" + base + "en-US/Firefox%2039.0.dmg"},
+		// even to scheme=http requests
+		{handler, "http://test/?product=firefox-latest&os=osx&lang=en-GB&scheme=http", 302, "https://" + base + "en-GB/Firefox%2039.0.dmg"},
+		// a language forces all its langs
+		{languageHandler, "http://test/?product=firefox-latest&os=osx&lang=en-US", 302, "https://" + base + "en-US/Firefox%2039.0.dmg"},
+		// forced langs never fall back to http
+		{fallbackHandler, "http://test/?product=firefox-latest&os=osx&lang=en-GB", 404, ""},
+		{fallbackHandler, "http://test/?product=firefox-latest&os=osx&lang=en-US", 302, "http://" + base + "en-US/Firefox%2039.0.dmg"},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+
+		testRequest.Handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedCode, w.Code, "url: %v", testRequest.URL)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v", testRequest.URL)
+	}
+}
+
+// httpsOnlyMirrorsCatalog is a catalog without http mirrors
+type httpsOnlyMirrorsCatalog struct {
+	Catalog
+}
+
+func (c *httpsOnlyMirrorsCatalog) Mirrors(sslOnly bool) ([]bouncer.MirrorsResult, error) {
+	if !sslOnly {
+		return []bouncer.MirrorsResult{}, nil
+	}
+	return c.Catalog.Mirrors(sslOnly)
+}
+
+func TestBouncerHandlerPinMismatch(t *testing.T) {
+	const httpLocation = "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"
+	const httpsLocation = "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"
+	catalog := memoizedCatalog()
+	httpsOnly := &httpsOnlyMirrorsCatalog{catalog}
+	httpOnly := &httpOnlyMirrorsCatalog{catalog}
+
+	testRequests := []struct {
+		Handler          *BouncerHandler
+		URL              string
+		PinHeader        string
+		ExpectedCode     int
+		ExpectedLocation string
+	}{
+		// http requests with only https mirrors
+		{&BouncerHandler{db: httpsOnly}, "http://test/?product=firefox-latest&os=osx&lang=en-US", "", 404, ""},
+		{&BouncerHandler{db: httpsOnly, HTTPPinMismatch: SchemeMismatchServe}, "http://test/?product=firefox-latest&os=osx&lang=en-US", "", 302, httpsLocation},
+		{&BouncerHandler{db: httpsOnly, HTTPPinMismatch: SchemeMismatchServe}, "http://test/?product=firefox-latest&os=osx&lang=en-US&scheme=http", "", 302, httpsLocation},
+		{&BouncerHandler{db: httpsOnly, HTTPPinMismatch: SchemeMismatchError}, "http://test/?product=firefox-latest&os=osx&lang=en-US", "", 500, ""},
+		// https pinned requests with only http mirrors
+		{&BouncerHandler{db: httpOnly}, "http://test/?product=firefox-latest&os=osx&lang=en-US&scheme=https", "", 404, ""},
+		{&BouncerHandler{db: httpOnly, HTTPSPinMismatch: SchemeMismatchServe}, "http://test/?product=firefox-latest&os=osx&lang=en-US&scheme=https", "", 302, httpLocation},
+		{&BouncerHandler{db: httpOnly, HTTPSPinMismatch: SchemeMismatchServe, PinHttpsHeaderName: "X-Forwarded-Proto"}, "http://test/?product=firefox-latest&os=osx&lang=en-US", "https", 302, httpLocation},
+		{&BouncerHandler{db: httpOnly, HTTPSPinMismatch: SchemeMismatchError}, "http://test/?product=firefox-latest&os=osx&lang=en-US&scheme=https", "", 500, ""},
+		// the policy of the other scheme doesn't apply
+		{&BouncerHandler{db: httpOnly, HTTPPinMismatch: SchemeMismatchServe}, "http://test/?product=firefox-latest&os=osx&lang=en-US&scheme=https", "", 404, ""},
+		// ssl only products are never served over http
+		{&BouncerHandler{db: httpOnly, HTTPSPinMismatch: SchemeMismatchServe}, "http://test/?product=firefox-ssl&os=osx&lang=en-US", "", 404, ""},
+		{&BouncerHandler{db: httpOnly, HTTPSPinMismatch: SchemeMismatchError}, "http://test/?product=firefox-ssl&os=osx&lang=en-US&scheme=https", "", 404, ""},
+	}
+
+	for i, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+		if testRequest.PinHeader != "" {
+			req.Header.Set("X-Forwarded-Proto", testRequest.PinHeader)
+		}
+
+		testRequest.Handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedCode, w.Code, "%d url: %v", i, testRequest.URL)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "%d url: %v", i, testRequest.URL)
+	}
+}
+
+// migratingCatalog is a newerCatalog where Firefox-SSL hasn't been
+// migrated yet
+type migratingCatalog struct {
+	Catalog
+}
+
+func (c *migratingCatalog) ProductForLanguage(product, lang string) (string, bool, string, error) {
+	if strings.EqualFold(product, "Firefox-SSL") {
+		return "", false, "", sql.ErrNoRows
+	}
+	return c.Catalog.ProductForLanguage(product, lang)
+}
+
+func (c *migratingCatalog) ProductExists(product string) (bool, error) {
+	if strings.EqualFold(product, "Firefox-SSL") {
+		return false, nil
+	}
+	return c.Catalog.ProductExists(product)
+}
+
+func TestBouncerHandlerFallbackCatalogs(t *testing.T) {
+	handler := &BouncerHandler{
+		db:               &migratingCatalog{newerCatalog()},
+		FallbackCatalogs: []Catalog{&noMirrorsCatalog{bouncerHandler.db}, bouncerHandler.db},
+	}
+
+	testRequests := []struct {
+		Handler          *BouncerHandler
+		URL              string
+		ExpectedCode     int
+		ExpectedLocation string
+	}{
+		// in both catalogs, the catalog wins
+		{handler, "http://test/?product=firefox-latest&os=osx&lang=en-US", 302, "https://download-installer.cdn.mozilla.net/pub/firefox/releases/43.0.1/mac/en-US/Firefox%2043.0.1.dmg"},
+		// only in the fallback catalogs, the first one with the product wins,
+		// even without mirrors
+		{handler, "http://test/?product=firefox-ssl&os=osx&lang=en-US", 404, ""},
+		{&BouncerHandler{db: handler.db, FallbackCatalogs: []Catalog{bouncerHandler.db}}, "http://test/?product=firefox-ssl&os=osx&lang=en-US", 302, "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		// products in a catalog without the lang don't fall back
+		{&BouncerHandler{db: handler.db, FallbackCatalogs: []Catalog{bouncerHandler.db}}, "http://test/?product=firefox-latest&os=osx&lang=xx-YY", 404, ""},
+		{handler, "http://test/?product=firefox-unknown&os=osx&lang=en-US", 404, ""},
+		// without fallback catalogs, unmigrated products aren't found
+		{&BouncerHandler{db: handler.db}, "http://test/?product=firefox-ssl&os=osx&lang=en-US", 404, ""},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+
+		testRequest.Handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedCode, w.Code, "url: %v", testRequest.URL)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v", testRequest.URL)
+	}
+}
+
+func TestBouncerHandlerESRCycles(t *testing.T) {
+	handler := &BouncerHandler{
+		db: betaCatalog(),
+		// firefox-sha1 and firefox-latest stand in for two ESR cycles
+		ESRCycles: map[string]string{"60": "firefox-sha1", "68": "firefox-latest", "70": "thunderbird-latest"},
+	}
+
+	testRequests := []struct {
+		URL              string
+		ExpectedLocation string
+	}{
+		{"http://test/?product=firefox-esr-latest&os=osx&lang=en-US&version=60.9.0", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/43.0.1/mac/en-US/Firefox%2043.0.1.dmg"},
+		{"http://test/?product=firefox-esr-latest&os=osx&lang=en-US&version=67.0", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/43.0.1/mac/en-US/Firefox%2043.0.1.dmg"},
+		{"http://test/?product=firefox-esr-latest&os=osx&lang=en-US&version=68.4.1esr", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{"http://test/?product=firefox-esr-latest&os=osx&lang=en-US&version=78.0", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		// clients older than every cycle, or without a version, get the latest ESR
+		{"http://test/?product=firefox-esr-latest&os=osx&lang=en-US&version=52.0", ""},
+		{"http://test/?product=firefox-esr-latest&os=osx&lang=en-US", ""},
+		// only latest ESR requests are mapped
+		{"http://test/?product=firefox-beta-latest&os=osx&lang=en-US&version=60.0", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v", testRequest.URL)
+	}
+}
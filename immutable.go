@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+)
+
+// immutableParams are the params of a request which don't carry over to its
+// immutable url: they select the product, os and lang the url names, or
+// would make it resolve again
+var immutableParams = []string{"immutable", "channel", "c", SpecParam, "print", "version"}
+
+// immutableURL returns the absolute bouncer url of the catalog product, os
+// and lang req was resolved to, e.g. product=Firefox-39.0-SSL for
+// product=firefox-latest, so the url keeps its meaning when aliases move to a
+// newer release. It returns "" if the catalog product names no version, as
+// the url could then change meaning too.
+func (b *BouncerHandler) immutableURL(req *http.Request, res *resolution) string {
+	if productVersion(res.Product) == "" {
+		return ""
+	}
+	query := req.URL.Query()
+	for _, param := range immutableParams {
+		query.Del(param)
+	}
+	query.Set("product", res.Product)
+	query.Set("os", res.OS)
+	query.Set("lang", res.Lang)
+
+	u := *req.URL
+	u.Scheme = "http"
+	if req.TLS != nil || b.shouldPinHttps(req) {
+		u.Scheme = "https"
+	}
+	u.Host = req.Host
+	u.RawQuery = query.Encode()
+	return u.String()
+}
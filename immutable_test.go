@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBouncerHandlerImmutable(t *testing.T) {
+	handler := &BouncerHandler{db: bouncerHandler.db, PinHttpsHeaderName: "X-Forwarded-Proto"}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	testRequests := []struct {
+		Query            string
+		ExpectedLocation string
+	}{
+		{"?product=firefox-sha1&os=win&lang=en-US&immutable=yes", server.URL + "/?lang=en-US&os=win&product=Firefox-43.0.1-SSL"},
+		// other params carry over
+		{"?product=firefox-sha1&os=win&lang=en-US&immutable=yes&scheme=https", server.URL + "/?lang=en-US&os=win&product=Firefox-43.0.1-SSL&scheme=https"},
+		// products naming no version are served their mirror url
+		{"?product=firefox-latest&os=osx&lang=en-US&immutable=yes", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		// failed resolutions aren't redirected
+		{"?product=firefox-sha1&os=win&lang=fr&immutable=yes", ""},
+		{"?product=firefox-sha1&os=win&lang=en-US&immutable=no", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/43.0.1/win32/en-US/Firefox%20Setup%2043.0.1.exe"},
+	}
+
+	for _, testRequest := range testRequests {
+		resp, err := client.Get(server.URL + "/" + testRequest.Query)
+		assert.NoError(t, err, "query: %v", testRequest.Query)
+		resp.Body.Close()
+		assert.Equal(t, testRequest.ExpectedLocation, resp.Header.Get("Location"), "query: %v", testRequest.Query)
+	}
+
+	// requests pinned to https get an https url
+	req, err := http.NewRequest("GET", server.URL+"/?product=firefox-sha1&os=win&lang=en-US&immutable=yes", nil)
+	assert.NoError(t, err)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	resp, err := client.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "https"+server.URL[len("http"):]+"/?lang=en-US&os=win&product=Firefox-43.0.1-SSL", resp.Header.Get("Location"))
+
+	// the immutable url resolves to the same file as the alias
+	resp, err = client.Get(server.URL + "/?lang=en-US&os=win&product=Firefox-43.0.1-SSL")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "https://download-installer.cdn.mozilla.net/pub/firefox/releases/43.0.1/win32/en-US/Firefox%20Setup%2043.0.1.exe", resp.Header.Get("Location"))
+
+	// print=yes prints it
+	resp, err = client.Get(server.URL + "/?product=firefox-sha1&os=win&lang=en-US&immutable=yes&print=yes")
+	assert.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, server.URL+"/?lang=en-US&os=win&product=Firefox-43.0.1-SSL", string(body))
+}
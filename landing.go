@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// langLandingURL returns the landing page a request for a lang the product
+// isn't available in is redirected to, or "" if the request 404s. Clients
+// that want json get the error instead.
+func (b *BouncerHandler) langLandingURL(req *http.Request, reqParams *BouncerParams, resErr *resolveError) string {
+	if b.LangLandingURL == "" || resErr.Reason != ReasonLangNotAvailable || wantsJSON(req) {
+		return ""
+	}
+
+	replacer := strings.NewReplacer(
+		"{product}", url.QueryEscape(reqParams.Product),
+		"{os}", url.QueryEscape(reqParams.OS),
+		"{lang}", url.QueryEscape(reqParams.Lang),
+	)
+	return replacer.Replace(b.LangLandingURL)
+}
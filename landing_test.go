@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBouncerHandlerLangLandingURL(t *testing.T) {
+	handler := &BouncerHandler{
+		db:             bouncerHandler.db,
+		LangLandingURL: "https://www.mozilla.org/{lang}/firefox/all/?product={product}&os={os}",
+	}
+
+	testRequests := []struct {
+		URL              string
+		ExpectedCode     int
+		ExpectedLocation string
+	}{
+		{"http://test/?product=firefox-latest&os=osx&lang=xx-YY", 302, "https://www.mozilla.org/xx-YY/firefox/all/?product=firefox-latest&os=osx"},
+		// other 404s aren't redirected
+		{"http://test/?product=firefox-unknown&os=osx&lang=en-US", 404, ""},
+		{"http://test/?product=firefox-latest&os=beos&lang=en-US", 404, ""},
+		// clients that want json get the error
+		{"http://test/?product=firefox-latest&os=osx&lang=xx-YY&format=json", 404, ""},
+		{"http://test/?product=firefox-latest&os=osx&lang=en-US", 302, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedCode, w.Code, "url: %v", testRequest.URL)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v", testRequest.URL)
+	}
+
+	// without a landing page, unknown langs 404
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=xx-YY", nil)
+	assert.NoError(t, err)
+	bouncerHandler.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+	assert.Equal(t, "", w.HeaderMap.Get("Location"))
+}
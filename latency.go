@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mozilla-services/go-bouncer/bouncer"
+)
+
+// latencySmoothing is the weight of a new observation in the smoothed
+// latency of a mirror
+const latencySmoothing = 0.3
+
+// MirrorLatencies keeps a smoothed latency per mirror, observed by mirror
+// health checks. It is safe for concurrent use.
+type MirrorLatencies struct {
+	mu        sync.RWMutex
+	latencies map[string]time.Duration
+}
+
+func NewMirrorLatencies() *MirrorLatencies {
+	return &MirrorLatencies{
+		latencies: make(map[string]time.Duration),
+	}
+}
+
+// Observe records a latency measured for the mirror with id mirrorID
+func (m *MirrorLatencies) Observe(mirrorID string, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	smoothed, ok := m.latencies[mirrorID]
+	if !ok {
+		m.latencies[mirrorID] = latency
+		return
+	}
+	m.latencies[mirrorID] = smoothed + time.Duration(latencySmoothing*float64(latency-smoothed))
+}
+
+// Forget drops the latency of the mirror with id mirrorID, e.g. when its
+// check fails, so Fastest doesn't pick it until it is observed again
+func (m *MirrorLatencies) Forget(mirrorID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.latencies, mirrorID)
+}
+
+// Latency returns the smoothed latency of a mirror, and false if none was
+// observed
+func (m *MirrorLatencies) Latency(mirrorID string) (time.Duration, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	latency, ok := m.latencies[mirrorID]
+	return latency, ok
+}
+
+// Fastest returns the healthy mirror with the lowest smoothed latency, or nil
+// if no latency was observed for any healthy mirror
+func (m *MirrorLatencies) Fastest(mirrors []bouncer.MirrorsResult) *bouncer.MirrorsResult {
+	var fastest *bouncer.MirrorsResult
+	var fastestLatency time.Duration
+	for i, mirror := range mirrors {
+		if mirror.Rating <= 0 {
+			continue
+		}
+		latency, ok := m.Latency(mirror.ID)
+		if !ok {
+			continue
+		}
+		if fastest == nil || latency < fastestLatency {
+			fastest, fastestLatency = &mirrors[i], latency
+		}
+	}
+	return fastest
+}
+
+// MirrorLatencyChecker is the background health check observing mirror
+// latencies: it times a HEAD request to the base url of every mirror of
+// Catalog, over http and https, every Interval
+type MirrorLatencyChecker struct {
+	Catalog   Catalog
+	Latencies *MirrorLatencies
+	Client    *http.Client
+	Interval  time.Duration
+	// Probes limits the checks running at the same time. May be nil.
+	Probes *ProbeLimiter
+}
+
+// Check times one request to every mirror, concurrently. The latencies of
+// mirrors which fail are forgotten, so they are picked by rating until they
+// recover. Checks which the Probes limit or ctx stop from running leave the
+// latency as it was.
+func (c *MirrorLatencyChecker) Check(ctx context.Context) {
+	var mirrors []bouncer.MirrorsResult
+	seen := make(map[string]bool)
+	for _, sslOnly := range []bool{false, true} {
+		m, err := c.Catalog.Mirrors(sslOnly)
+		if err != nil {
+			log.Printf("MirrorLatencyChecker err: %v", err)
+			return
+		}
+		for _, mirror := range m {
+			if !seen[mirror.ID] {
+				seen[mirror.ID] = true
+				mirrors = append(mirrors, mirror)
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, mirror := range mirrors {
+		wg.Add(1)
+		go func(mirror bouncer.MirrorsResult) {
+			defer wg.Done()
+			var latency time.Duration
+			checked := false
+			err := c.Probes.Do(ctx, func() error {
+				checked = true
+				start := time.Now()
+				err := URLCheck(c.Client, mirror.BaseURL)()
+				latency = time.Since(start)
+				return err
+			})
+			if err != nil {
+				log.Printf("MirrorLatencyChecker mirror %s err: %v", mirror.ID, err)
+				if checked {
+					c.Latencies.Forget(mirror.ID)
+				}
+				return
+			}
+			c.Latencies.Observe(mirror.ID, latency)
+		}(mirror)
+	}
+	wg.Wait()
+}
+
+// Run checks the mirrors every Interval, until ctx is done
+func (c *MirrorLatencyChecker) Run(ctx context.Context) {
+	for {
+		c.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.Interval):
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mozilla-services/go-bouncer/bouncer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMirrorLatenciesObserve(t *testing.T) {
+	latencies := NewMirrorLatencies()
+	_, ok := latencies.Latency("1")
+	assert.False(t, ok)
+
+	latencies.Observe("1", 100*time.Millisecond)
+	latency, ok := latencies.Latency("1")
+	assert.True(t, ok)
+	assert.Equal(t, 100*time.Millisecond, latency)
+
+	// one slow check only moves the smoothed latency part of the way
+	latencies.Observe("1", 200*time.Millisecond)
+	latency, _ = latencies.Latency("1")
+	assert.Equal(t, 130*time.Millisecond, latency)
+}
+
+func TestMirrorLatenciesFastest(t *testing.T) {
+	mirrors := []bouncer.MirrorsResult{
+		{ID: "1", BaseURL: "http://slow.example.com", Rating: 100000},
+		{ID: "2", BaseURL: "http://fast.example.com", Rating: 100},
+		{ID: "3", BaseURL: "http://unhealthy.example.com", Rating: 0},
+		{ID: "4", BaseURL: "http://unmeasured.example.com", Rating: 100},
+	}
+
+	latencies := NewMirrorLatencies()
+	assert.Nil(t, latencies.Fastest(mirrors))
+
+	latencies.Observe("1", 300*time.Millisecond)
+	latencies.Observe("2", 20*time.Millisecond)
+	latencies.Observe("3", 1*time.Millisecond)
+	if fastest := latencies.Fastest(mirrors); assert.NotNil(t, fastest) {
+		assert.Equal(t, "2", fastest.ID)
+	}
+}
+
+// seededMirrorsCatalog is a catalog with fixed mirrors
+type seededMirrorsCatalog struct {
+	Catalog
+	mirrors []bouncer.MirrorsResult
+}
+
+func (s *seededMirrorsCatalog) Mirrors(sslOnly bool) ([]bouncer.MirrorsResult, error) {
+	return s.mirrors, nil
+}
+
+func TestBouncerHandlerLowestLatencyMirror(t *testing.T) {
+	latencies := NewMirrorLatencies()
+	handler := &BouncerHandler{
+		db: &seededMirrorsCatalog{memoizedCatalog(), []bouncer.MirrorsResult{
+			{ID: "1", BaseURL: "http://slow.example.com", Rating: 1000000},
+			{ID: "2", BaseURL: "http://fast.example.com", Rating: 1},
+		}},
+		MirrorLatencies: latencies,
+	}
+
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+
+	latencies.Observe("1", 300*time.Millisecond)
+	latencies.Observe("2", 20*time.Millisecond)
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, "http://fast.example.com/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", w.HeaderMap.Get("Location"))
+	}
+
+	// the fast mirror slowing down moves traffic once its smoothed latency
+	// is higher
+	for i := 0; i < 10; i++ {
+		latencies.Observe("2", time.Second)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, "http://slow.example.com/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", w.HeaderMap.Get("Location"))
+}
+
+func TestMirrorLatencyChecker(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer fast.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	mirrors := []bouncer.MirrorsResult{
+		{ID: "1", BaseURL: slow.URL, Rating: 100},
+		{ID: "2", BaseURL: fast.URL, Rating: 100},
+		{ID: "3", BaseURL: failing.URL, Rating: 100},
+	}
+	checker := &MirrorLatencyChecker{
+		Catalog:   &seededMirrorsCatalog{mirrors: mirrors},
+		Latencies: NewMirrorLatencies(),
+		Client:    &http.Client{Timeout: time.Second},
+	}
+	checker.Check(context.Background())
+
+	slowLatency, ok := checker.Latencies.Latency("1")
+	assert.True(t, ok)
+	assert.True(t, slowLatency >= 50*time.Millisecond, "latency: %v", slowLatency)
+	_, ok = checker.Latencies.Latency("3")
+	assert.False(t, ok)
+	if fastest := checker.Latencies.Fastest(mirrors); assert.NotNil(t, fastest) {
+		assert.Equal(t, "2", fastest.ID)
+	}
+}
+
+func TestMirrorLatencyCheckerForgetsFailures(t *testing.T) {
+	var failing int32
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer mirror.Close()
+
+	mirrors := []bouncer.MirrorsResult{{ID: "1", BaseURL: mirror.URL, Rating: 100}}
+	checker := &MirrorLatencyChecker{
+		Catalog:   &seededMirrorsCatalog{mirrors: mirrors},
+		Latencies: NewMirrorLatencies(),
+		Client:    &http.Client{Timeout: time.Second},
+	}
+	checker.Check(context.Background())
+	assert.NotNil(t, checker.Latencies.Fastest(mirrors))
+
+	// the mirror failing isn't picked anymore
+	atomic.StoreInt32(&failing, 1)
+	checker.Check(context.Background())
+	_, ok := checker.Latencies.Latency("1")
+	assert.False(t, ok)
+	assert.Nil(t, checker.Latencies.Fastest(mirrors))
+}
+
+func TestMirrorLatencyCheckerRun(t *testing.T) {
+	checker := &MirrorLatencyChecker{
+		Catalog:   &seededMirrorsCatalog{},
+		Latencies: NewMirrorLatencies(),
+		Interval:  time.Millisecond,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		checker.Run(ctx)
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return once its context was done")
+	}
+}
+
+func TestMirrorLatencyCheckerProbes(t *testing.T) {
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer mirror.Close()
+
+	probes := NewProbeLimiter(1)
+	probes.FailFast = true
+	checker := &MirrorLatencyChecker{
+		Catalog:   &seededMirrorsCatalog{mirrors: []bouncer.MirrorsResult{{ID: "1", BaseURL: mirror.URL, Rating: 100}}},
+		Latencies: NewMirrorLatencies(),
+		Client:    &http.Client{Timeout: time.Second},
+		Probes:    probes,
+	}
+
+	// checks beyond the limit fail fast and aren't measured
+	probes.slots <- struct{}{}
+	checker.Check(context.Background())
+	_, ok := checker.Latencies.Latency("1")
+	assert.False(t, ok)
+
+	<-probes.slots
+	checker.Check(context.Background())
+	_, ok = checker.Latencies.Latency("1")
+	assert.True(t, ok)
+}
@@ -3,8 +3,15 @@ package main
 //go:generate ./version.sh
 
 import (
+	"context"
+	"expvar"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/codegangsta/cli"
@@ -29,6 +36,16 @@ func main() {
 			Usage:  "address on which to listen",
 			EnvVar: "BOUNCER_ADDR",
 		},
+		cli.StringFlag{
+			Name:   "admin-addr",
+			Usage:  "If this flag is set, the /__admin__/ endpoints are served on this address, e.g.,: 127.0.0.1:8889",
+			EnvVar: "BOUNCER_ADMIN_ADDR",
+		},
+		cli.StringSliceFlag{
+			Name:   "admin-cidrs",
+			Usage:  "Networks of admin clients. If set, only they may use the admin endpoints. They may also override the cache time with the X-Bouncer-Cache-Time header, e.g.,: 10.0.0.0/8",
+			EnvVar: "BOUNCER_ADMIN_CIDRS",
+		},
 		cli.StringFlag{
 			Name:   "db-dsn",
 			Value:  "user:password@tcp(localhost:3306)/bouncer",
@@ -57,10 +74,712 @@ func main() {
 			Usage:  "Root url of service used to service modified stub installers e.g., https://stubdownloader.services.mozilla.com/",
 			EnvVar: "BOUNCER_STUB_ROOT_URL",
 		},
+		cli.BoolFlag{
+			Name:   "stub-disabled",
+			Usage:  "Start with the stub installer switched off, serving full installers until it is switched on at /__admin__/stub",
+			EnvVar: "BOUNCER_STUB_DISABLED",
+		},
+		cli.StringSliceFlag{
+			Name:   "attribution-endpoint",
+			Usage:  "product=url pairs forwarding the attribution of requests for a product or product family to a campaign endpoint, e.g.,: focus=https://campaigns.example.com/",
+			EnvVar: "BOUNCER_ATTRIBUTION_ENDPOINTS",
+		},
+		cli.BoolFlag{
+			Name:   "infer-os",
+			Usage:  "If this flag is set, requests without an os (or with os=default) use the os inferred from the User-Agent",
+			EnvVar: "BOUNCER_INFER_OS",
+		},
+		cli.StringSliceFlag{
+			Name:   "mirror-pool",
+			Usage:  "host=mirror|mirror pairs setting the hosts of the mirrors requests sent to a host, by TLS server name or Host header, are served from, e.g.,: eu.download.example.com=eu1.cdn.example.com|eu2.cdn.example.com",
+			EnvVar: "BOUNCER_MIRROR_POOLS",
+		},
+		cli.StringSliceFlag{
+			Name:   "mirror-region",
+			Usage:  "region=mirror|mirror pairs setting the hosts of the mirrors requests with a region param are served from, e.g.,: eu=eu1.cdn.example.com|eu2.cdn.example.com",
+			EnvVar: "BOUNCER_MIRROR_REGIONS",
+		},
+		cli.BoolFlag{
+			Name:   "strict-regions",
+			Usage:  "Return 400 for requests with a region param not set by mirror-region, instead of ignoring it",
+			EnvVar: "BOUNCER_STRICT_REGIONS",
+		},
+		cli.BoolFlag{
+			Name:   "clean-os-lang",
+			Usage:  "If this flag is set, trailing dots and empty segments of the os and lang of requests are dropped, e.g. win. is win and en--US is en-US",
+			EnvVar: "BOUNCER_CLEAN_OS_LANG",
+		},
+		cli.StringSliceFlag{
+			Name:   "os-fallback",
+			Usage:  "os=fallback|fallback pairs setting the oses, in order, served when a product isn't available on an os, e.g.,: win64=win,linux64=linux",
+			EnvVar: "BOUNCER_OS_FALLBACK",
+		},
+		cli.IntFlag{
+			Name:   "max-fallback-attempts",
+			Usage:  "Maximum number of lang and os fallbacks tried for a request, after which it 404s. 0 is no maximum",
+			EnvVar: "BOUNCER_MAX_FALLBACK_ATTEMPTS",
+		},
+		cli.StringSliceFlag{
+			Name:   "universal-os",
+			Usage:  "product=os pairs setting the os of the cross platform installer of a product or product family, served when the client os can't be determined, e.g.,: firefox=web",
+			EnvVar: "BOUNCER_UNIVERSAL_OS",
+		},
+		cli.StringSliceFlag{
+			Name:   "os-version-name",
+			Usage:  "name=os pairs replacing the default names of oses qualified with a version and the catalog os they're looked up as, e.g.,: win=win,macos=osx",
+			EnvVar: "BOUNCER_OS_VERSION_NAMES",
+		},
+		cli.BoolFlag{
+			Name:   "upgrade-win64",
+			Usage:  "If this flag is set, 64-bit Windows clients asking for os=win are served win64 builds, unless they ask for arch=x86",
+			EnvVar: "BOUNCER_UPGRADE_WIN64",
+		},
+		cli.StringFlag{
+			Name:   "client-os-header-name",
+			Usage:  "If this flag is set, the header is used as the os of requests without an os when it can't be inferred from the User-Agent, e.g.,: X-Client-OS",
+			EnvVar: "BOUNCER_CLIENT_OS_HEADER_NAME",
+		},
+		cli.StringFlag{
+			Name:   "license-url-template",
+			Usage:  "License url served for ?view=license requests. {version} and {lang} are replaced with the product version and lang",
+			EnvVar: "BOUNCER_LICENSE_URL_TEMPLATE",
+		},
+		cli.BoolFlag{
+			Name:   "parse-product-locale",
+			Usage:  "If this flag is set, requests without a lang use the locale at the end of the product name, e.g.,: firefox-48.0-fr",
+			EnvVar: "BOUNCER_PARSE_PRODUCT_LOCALE",
+		},
+		cli.BoolFlag{
+			Name:   "accept-language",
+			Usage:  "If this flag is set, requests without a lang param get the most preferred lang of their Accept-Language header the product is available in, instead of en-US",
+			EnvVar: "BOUNCER_ACCEPT_LANGUAGE",
+		},
+		cli.StringFlag{
+			Name:   "access-log-format",
+			Usage:  "If this flag is set, an access log line is written to stdout for every request. Either json or clf (Combined Log Format)",
+			EnvVar: "BOUNCER_ACCESS_LOG_FORMAT",
+		},
+		cli.BoolTFlag{
+			Name:   "access-log-full-url",
+			Usage:  "Log the full resolved url in the access log. If false, only the url path is logged",
+			EnvVar: "BOUNCER_ACCESS_LOG_FULL_URL",
+		},
+		cli.BoolFlag{
+			Name:   "gzip",
+			Usage:  "If this flag is set, responses of at least gzip-min-size bytes are gzipped for clients accepting it",
+			EnvVar: "BOUNCER_GZIP",
+		},
+		cli.IntFlag{
+			Name:   "gzip-min-size",
+			Value:  DefaultGzipMinSize,
+			Usage:  "Size, in bytes, responses must reach to be gzipped",
+			EnvVar: "BOUNCER_GZIP_MIN_SIZE",
+		},
+		cli.BoolFlag{
+			Name:   "metrics-exemplars",
+			Usage:  "If this flag is set, resolve timing buckets keep the trace id of the traceparent header of their last request as an OpenMetrics exemplar",
+			EnvVar: "BOUNCER_METRICS_EXEMPLARS",
+		},
+		cli.StringFlag{
+			Name:   "statsd-addr",
+			Usage:  "If this flag is set, metrics are also sent to the StatsD server at this host:port over UDP, e.g.,: localhost:8125",
+			EnvVar: "BOUNCER_STATSD_ADDR",
+		},
+		cli.StringFlag{
+			Name:   "statsd-prefix",
+			Value:  "bouncer",
+			Usage:  "Prefix of the names of metrics sent to StatsD",
+			EnvVar: "BOUNCER_STATSD_PREFIX",
+		},
+		cli.StringSliceFlag{
+			Name:   "statsd-tag",
+			Usage:  "key:value tag sent with every StatsD metric, e.g.,: env:prod. May be repeated",
+			EnvVar: "BOUNCER_STATSD_TAGS",
+		},
+		cli.StringFlag{
+			Name:   "access-log-sample-rate",
+			Usage:  "Fraction, between 0 and 1, of successful requests written to the access log. Errors are always logged",
+			EnvVar: "BOUNCER_ACCESS_LOG_SAMPLE_RATE",
+		},
+		cli.BoolFlag{
+			Name:   "prefer-https",
+			Usage:  "If this flag is set, requests without a scheme param are served https urls",
+			EnvVar: "BOUNCER_PREFER_HTTPS",
+		},
+		cli.BoolFlag{
+			Name:   "https-fallback",
+			Usage:  "If this flag is set, requests without a scheme param are served https urls if an https mirror is available, and http urls otherwise",
+			EnvVar: "BOUNCER_HTTPS_FALLBACK",
+		},
+		cli.StringSliceFlag{
+			Name:   "https-lang",
+			Usage:  "Lang, or language such as fa for every fa-* lang, always served over https, like ssl only products. May be repeated",
+			EnvVar: "BOUNCER_HTTPS_LANGS",
+		},
+		cli.BoolFlag{
+			Name:   "canonical-scheme-upgrade",
+			Usage:  "If this flag and prefer-https are set, scheme=http requests are redirected to the https bouncer url with a 308",
+			EnvVar: "BOUNCER_CANONICAL_SCHEME_UPGRADE",
+		},
+		cli.BoolFlag{
+			Name:   "cache-busting",
+			Usage:  "If this flag is set, nocache=1 requests get a url with a unique query param bypassing caches, and Cache-Control: no-store",
+			EnvVar: "BOUNCER_CACHE_BUSTING",
+		},
+		cli.BoolFlag{
+			Name:   "print-json",
+			Usage:  "If this flag is set, print=yes requests with Accept: application/json get the url as a json string",
+			EnvVar: "BOUNCER_PRINT_JSON",
+		},
+		cli.StringSliceFlag{
+			Name:   "sunset-date",
+			Usage:  "product=date pairs setting the retirement date, YYYY-MM-DD or RFC 3339, of a product or product family sent in a Sunset header, e.g.,: firefox-esr60=2026-12-31",
+			EnvVar: "BOUNCER_SUNSET_DATES",
+		},
+		cli.StringFlag{
+			Name:   "sunset-policy-url",
+			Usage:  "Url of the retirement policy of products with a sunset date, sent as their Link rel=\"sunset\". {product} is replaced with the requested product",
+			EnvVar: "BOUNCER_SUNSET_POLICY_URL",
+		},
+		cli.StringSliceFlag{
+			Name:   "retry-after",
+			Usage:  "cause=seconds pairs overriding the Retry-After of 503 responses, by cause (maintenance, building, draining, timeout or rate_limited), e.g.,: maintenance=600",
+			EnvVar: "BOUNCER_RETRY_AFTER",
+		},
+		cli.StringFlag{
+			Name:   "timing-allow-origin",
+			Usage:  "Timing-Allow-Origin header of bouncer responses, e.g.,: https://www.mozilla.org. Not set if empty",
+			EnvVar: "BOUNCER_TIMING_ALLOW_ORIGIN",
+		},
+		cli.StringFlag{
+			Name:   "content-security-policy",
+			Value:  DefaultContentSecurityPolicy,
+			Usage:  "Content-Security-Policy header of redirects",
+			EnvVar: "BOUNCER_CONTENT_SECURITY_POLICY",
+		},
+		cli.BoolFlag{
+			Name:   "multiple-choices",
+			Usage:  "If this flag is set, os=all requests return 300 Multiple Choices with the url for every os the product is available on",
+			EnvVar: "BOUNCER_MULTIPLE_CHOICES",
+		},
+		cli.BoolFlag{
+			Name:   "canonical-os-keys",
+			Usage:  "If this flag is set, the oses of os=all choices are presented by their canonical names, e.g.,: win32 for win",
+			EnvVar: "BOUNCER_CANONICAL_OS_KEYS",
+		},
+		cli.StringSliceFlag{
+			Name:   "os-canonical-name",
+			Usage:  "os=name pairs overriding the canonical names of oses, e.g.,: win=windows,win64=windows64",
+			EnvVar: "BOUNCER_OS_CANONICAL_NAMES",
+		},
+		cli.StringSliceFlag{
+			Name:   "mirror-default-scheme",
+			Usage:  "host=scheme pairs setting the scheme a mirror is served over when https isn't required, e.g.,: download-installer.cdn.mozilla.net=https",
+			EnvVar: "BOUNCER_MIRROR_DEFAULT_SCHEME",
+		},
+		cli.StringSliceFlag{
+			Name:   "product-path-prefix",
+			Usage:  "product=prefix pairs setting a path prepended to the locations of a product or product family, e.g.,: thunderbird=/legacy",
+			EnvVar: "BOUNCER_PRODUCT_PATH_PREFIX",
+		},
+		cli.StringSliceFlag{
+			Name:   "product-methods",
+			Usage:  "product=methods pairs setting the | separated methods a product or product family is served with, e.g.,: firefox-update=GET|POST",
+			EnvVar: "BOUNCER_PRODUCT_METHODS",
+		},
+		cli.StringSliceFlag{
+			Name:   "esr-cycle",
+			Usage:  "major=product pairs setting the product of the ESR cycle started at a major version, served to latest ESR requests with a version param in the cycle, e.g.,: 60=firefox-esr60-latest,68=firefox-esr68-latest",
+			EnvVar: "BOUNCER_ESR_CYCLES",
+		},
+		cli.StringFlag{
+			Name:   "staging-dsn",
+			Usage:  "database DSN of the staging catalog, resolved against by requests with staging=1 if enable-staging is set",
+			EnvVar: "BOUNCER_STAGING_DSN",
+		},
+		cli.StringSliceFlag{
+			Name:   "fallback-dsn",
+			Usage:  "database DSNs of catalogs resolved against, in order, for products which aren't in the catalog, e.g.,: the old catalog during a migration",
+			EnvVar: "BOUNCER_FALLBACK_DSNS",
+		},
+		cli.BoolFlag{
+			Name:   "enable-staging",
+			Usage:  "If this flag is set, requests with staging=1 are resolved against the staging catalog. Don't set it in production",
+			EnvVar: "BOUNCER_ENABLE_STAGING",
+		},
+		cli.StringSliceFlag{
+			Name:   "building-products",
+			Usage:  "Products whose release is in progress, served a 503 with Retry-After and {\"status\": \"building\"}. Toggled at /__admin__/building, e.g.,: firefox-beta-latest",
+			EnvVar: "BOUNCER_BUILDING_PRODUCTS",
+		},
+		cli.StringSliceFlag{
+			Name:   "one-time-products",
+			Usage:  "Products or product families served with one-time urls, redeemable once at /redeem, e.g.,: firefox-internal",
+			EnvVar: "BOUNCER_ONE_TIME_PRODUCTS",
+		},
+		cli.IntFlag{
+			Name:   "one-time-ttl",
+			Value:  int(DefaultOneTimeTTL / time.Second),
+			Usage:  "Seconds a one-time url can be redeemed",
+			EnvVar: "BOUNCER_ONE_TIME_TTL",
+		},
+		cli.StringSliceFlag{
+			Name:   "eula-product",
+			Usage:  "product=url pairs of the EULA page a product or product family redirects to until it is accepted. {product}, {os} and {lang} are replaced, e.g.,: partner=https://partner.example.com/eula?product={product}",
+			EnvVar: "BOUNCER_EULA_PRODUCTS",
+		},
+		cli.StringFlag{
+			Name:   "eula-key",
+			Usage:  "HMAC-SHA256 key of eula_token params",
+			EnvVar: "BOUNCER_EULA_KEY",
+		},
+		cli.StringSliceFlag{
+			Name:   "partner-catalog",
+			Usage:  "partner=dsn pairs of the databases of partner catalogs, selected by requests with a partner param signed with partner-key",
+			EnvVar: "BOUNCER_PARTNER_CATALOGS",
+		},
+		cli.StringFlag{
+			Name:   "partner-key",
+			Usage:  "HMAC-SHA256 key of partner_sig params",
+			EnvVar: "BOUNCER_PARTNER_KEY",
+		},
+		cli.IntFlag{
+			Name:   "alias-cache-ttl",
+			Usage:  "Seconds what requested products resolve to, after rewrites and aliases, are cached. 0 disables the cache",
+			EnvVar: "BOUNCER_ALIAS_CACHE_TTL",
+		},
+		cli.IntFlag{
+			Name:   "alias-cache-size",
+			Value:  DefaultAliasCacheSize,
+			Usage:  "Number of requested products the alias cache holds",
+			EnvVar: "BOUNCER_ALIAS_CACHE_SIZE",
+		},
+		cli.StringSliceFlag{
+			Name:   "product-rewrite",
+			Usage:  "pattern=replacement rules rewriting the products fully matching a regexp before they are looked up. The first matching rule wins, e.g.,: firefox-(\\d+)\\.0-stub=firefox-stub",
+			EnvVar: "BOUNCER_PRODUCT_REWRITES",
+		},
+		cli.StringFlag{
+			Name:   "ua-rewrite-rules",
+			Usage:  "Path to a json list of rules rewriting products for matching user agents",
+			EnvVar: "BOUNCER_UA_REWRITE_RULES",
+		},
+		cli.StringFlag{
+			Name:   "checksums-file",
+			Usage:  "Path to a sha256sum or sha512sum file of catalog files. If set, /__checksum__?sha256= returns the product of a file and bundles list checksums",
+			EnvVar: "BOUNCER_CHECKSUMS_FILE",
+		},
+		cli.StringSliceFlag{
+			Name:   "product-rename",
+			Usage:  "old=new pairs of renamed products. Requests for the old name resolve the new one, e.g.,: firefox-nightly=firefox-nightly-latest-l10n",
+			EnvVar: "BOUNCER_PRODUCT_RENAMES",
+		},
+		cli.BoolFlag{
+			Name:   "canonicalize-renames",
+			Usage:  "If this flag is set, requests for the old name of a renamed product are redirected to the url with its new name, with a 301",
+			EnvVar: "BOUNCER_CANONICALIZE_RENAMES",
+		},
+		cli.BoolFlag{
+			Name:   "normalize-thunderbird",
+			Usage:  "If this flag is set, Thunderbird products requested by their channel alone are looked up by their catalog alias, e.g. thunderbird-beta as thunderbird-beta-latest",
+			EnvVar: "BOUNCER_NORMALIZE_THUNDERBIRD",
+		},
+		cli.StringSliceFlag{
+			Name:   "thunderbird-name",
+			Usage:  "product=alias pairs replacing the default Thunderbird names of normalize-thunderbird, e.g.,: thunderbird-beta=thunderbird-beta-latest",
+			EnvVar: "BOUNCER_THUNDERBIRD_NAMES",
+		},
+		cli.StringSliceFlag{
+			Name:   "product-feature-flag",
+			Usage:  "product=flag pairs gating a product behind a feature flag sent in the X-Bouncer-Flags header or bouncer_flags cookie, e.g.,: firefox-experiment-latest=experiment",
+			EnvVar: "BOUNCER_PRODUCT_FEATURE_FLAGS",
+		},
+		cli.StringSliceFlag{
+			Name:   "experiment",
+			Usage:  "feature=percentage pairs enabling a feature for a stable percentage of clients, by client ip. Enabled features are access logged and grant the product feature flag of the same name, e.g.,: experiment=10",
+			EnvVar: "BOUNCER_EXPERIMENTS",
+		},
+		cli.StringSliceFlag{
+			Name:   "language-region",
+			Usage:  "language=region pairs setting the region preferred when lang has no region, e.g.,: fr=FR,pt=BR",
+			EnvVar: "BOUNCER_LANGUAGE_REGIONS",
+		},
+		cli.StringSliceFlag{
+			Name:   "locale-fallback",
+			Usage:  "lang=lang pairs setting the lang served when a product isn't available in a lang, e.g.,: es-AR=es-ES,pt-PT=pt-BR",
+			EnvVar: "BOUNCER_LOCALE_FALLBACKS",
+		},
+		cli.StringFlag{
+			Name:   "lang-landing-url",
+			Usage:  "url template of the page requests for a lang the product isn't available in are redirected to, instead of 404ing, e.g.,: https://www.mozilla.org/{lang}/firefox/all/",
+			EnvVar: "BOUNCER_LANG_LANDING_URL",
+		},
+		cli.StringSliceFlag{
+			Name:   "short-code",
+			Usage:  "code=product/os/lang short codes, used for ?c=code requests. os and lang may be empty, e.g.,: ff-mac=firefox-latest/osx/",
+			EnvVar: "BOUNCER_SHORT_CODES",
+		},
+		cli.StringSliceFlag{
+			Name:   "bundle",
+			Usage:  "alias=product|product pairs defining bundle aliases. format=json requests list every product, other requests get the first one, e.g.,: firefox-suite=firefox-latest|thunderbird-latest",
+			EnvVar: "BOUNCER_BUNDLES",
+		},
+		cli.StringFlag{
+			Name:   "mirror-fallback-url",
+			Usage:  "If this flag is set, it is used as the mirror base url when no mirror can be selected, e.g.,: https://static-cdn.mozilla.net/pub",
+			EnvVar: "BOUNCER_MIRROR_FALLBACK_URL",
+		},
+		cli.IntFlag{
+			Name:   "recent-errors",
+			Value:  DefaultRecentErrorsSize,
+			Usage:  "Number of recent resolution errors kept for /__debug__/errors",
+			EnvVar: "BOUNCER_RECENT_ERRORS",
+		},
+		cli.IntFlag{
+			Name:   "missing-locales",
+			Value:  DefaultMissingLocalesSize,
+			Usage:  "Number of product, os and lang combinations requested in langs the product isn't available in tracked for /missing-locales. 0 disables the report",
+			EnvVar: "BOUNCER_MISSING_LOCALES",
+		},
+		cli.StringFlag{
+			Name:   "debug-token",
+			Usage:  "If this flag is set, /__debug__/errors serves the recent resolution errors, /missing-locales serves the missing locales report, and redirects include the mirror decision, to requests with an Authorization: Bearer <token> header",
+			EnvVar: "BOUNCER_DEBUG_TOKEN",
+		},
+		cli.BoolFlag{
+			Name:   "log-mirror-decisions",
+			Usage:  "Log how the mirror of every request was chosen",
+			EnvVar: "BOUNCER_LOG_MIRROR_DECISIONS",
+		},
+		cli.IntFlag{
+			Name:   "mirror-latency-interval",
+			Value:  0,
+			Usage:  "If set, mirrors are health checked every this many seconds and requests are served the healthy mirror with the lowest latency",
+			EnvVar: "BOUNCER_MIRROR_LATENCY_INTERVAL",
+		},
+		cli.IntFlag{
+			Name:   "max-concurrent-probes",
+			Value:  DefaultMaxConcurrentProbes,
+			Usage:  "Maximum number of mirror latency checks running at the same time",
+			EnvVar: "BOUNCER_MAX_CONCURRENT_PROBES",
+		},
+		cli.BoolFlag{
+			Name:   "probe-fail-fast",
+			Usage:  "If this flag is set, mirror latency checks fail when max-concurrent-probes are already running instead of waiting",
+			EnvVar: "BOUNCER_PROBE_FAIL_FAST",
+		},
+		cli.StringFlag{
+			Name:   "empty-product-policy",
+			Value:  string(EmptyProductRedirect),
+			Usage:  "How requests without a product are handled. redirect sends them to www.mozilla.org, badrequest returns 400 if they have other params",
+			EnvVar: "BOUNCER_EMPTY_PRODUCT_POLICY",
+		},
+		cli.StringFlag{
+			Name:   "http-pin-mismatch",
+			Value:  string(SchemeMismatchNoMirror),
+			Usage:  "How http requests are served when only https mirrors are available. no_mirror returns 404, serve serves https, error returns 500",
+			EnvVar: "BOUNCER_HTTP_PIN_MISMATCH",
+		},
+		cli.StringFlag{
+			Name:   "https-pin-mismatch",
+			Value:  string(SchemeMismatchNoMirror),
+			Usage:  "How https pinned requests for products which aren't ssl only are served when only http mirrors are available. no_mirror returns 404, serve serves http with a warning, error returns 500",
+			EnvVar: "BOUNCER_HTTPS_PIN_MISMATCH",
+		},
+		cli.BoolFlag{
+			Name:   "torrents",
+			Usage:  "If this flag is set, format=torrent requests redirect to the torrent of the installer",
+			EnvVar: "BOUNCER_TORRENTS",
+		},
+		cli.StringFlag{
+			Name:   "torrent-path-template",
+			Value:  DefaultTorrentPathTemplate,
+			Usage:  "Path of installer torrents. {path} and {lang} are replaced with the installer path and lang",
+			EnvVar: "BOUNCER_TORRENT_PATH_TEMPLATE",
+		},
+		cli.StringFlag{
+			Name:   "archive-baseurl",
+			Usage:  "Base url, without scheme, of the archive old builds are served from, e.g.,: archive.mozilla.org/pub",
+			EnvVar: "BOUNCER_ARCHIVE_BASEURL",
+		},
+		cli.StringSliceFlag{
+			Name:   "archive-product",
+			Usage:  "product=version pairs serving versions of a product or product family before version from the archive, or every version if version is *, e.g.,: firefox=3.6,seamonkey=*",
+			EnvVar: "BOUNCER_ARCHIVE_PRODUCTS",
+		},
+		cli.StringSliceFlag{
+			Name:   "xp-supported-until",
+			Usage:  "product=version pairs setting the last version of a product or product family built for Windows XP. Later versions aren't rewritten to sha1 signed products, e.g.,: firefox=52.9.0",
+			EnvVar: "BOUNCER_XP_SUPPORTED_UNTIL",
+		},
+		cli.StringSliceFlag{
+			Name:   "trusted-cidrs",
+			Usage:  "Networks whose requests may override the cache time with the X-Bouncer-Cache-Time header, e.g.,: 10.0.0.0/8,192.168.0.0/16",
+			EnvVar: "BOUNCER_TRUSTED_CIDRS",
+		},
+		cli.BoolFlag{
+			Name:   "trust-forwarded",
+			Usage:  "If this flag is set, the client ip and scheme of requests are taken from their RFC 7239 Forwarded header, over the pin-https-header-name header",
+			EnvVar: "BOUNCER_TRUST_FORWARDED",
+		},
+		cli.StringSliceFlag{
+			Name:   "sha1-rewrite-products",
+			Usage:  "If this flag is set, only these products are rewritten to sha1 signed products for Windows XP clients, e.g.,: firefox-latest,firefox-stub",
+			EnvVar: "BOUNCER_SHA1_REWRITE_PRODUCTS",
+		},
+		cli.StringSliceFlag{
+			Name:   "sha1-alias-suffix",
+			Usage:  "Suffix of legacy sha1 signed aliases, e.g. sha1 for firefox-sha1, served as is to Windows XP clients. May be repeated. Defaults to sha1",
+			EnvVar: "BOUNCER_SHA1_ALIAS_SUFFIXES",
+		},
+		cli.StringFlag{
+			Name:   "robots-txt",
+			Usage:  "Body served for /robots.txt. Defaults to disallowing all crawlers",
+			EnvVar: "BOUNCER_ROBOTS_TXT",
+		},
+		cli.StringFlag{
+			Name:   "favicon-url",
+			Usage:  "If this flag is set, /favicon.ico redirects to this url. Otherwise it returns 204 No Content",
+			EnvVar: "BOUNCER_FAVICON_URL",
+		},
+	}
+	app.Commands = []cli.Command{
+		{
+			Name:   "validate-catalog",
+			Usage:  "Compare how a candidate catalog resolves a list of checks against the live catalog",
+			Action: ValidateCatalog,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "candidate-dsn",
+					Usage:  "candidate catalog database DSN, e.g.,: a staging database",
+					EnvVar: "BOUNCER_CANDIDATE_DB_DSN",
+				},
+				cli.StringFlag{
+					Name:  "checks",
+					Usage: "json file listing the product, os and lang combinations to check",
+				},
+			},
+		},
 	}
 	app.RunAndExitOnError()
 }
 
+// parseKeyValues parses a list of key=value pairs into a map
+func parseKeyValues(values []string) (map[string]string, error) {
+	result := make(map[string]string, len(values))
+	for _, v := range values {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid key=value pair: %q", v)
+		}
+		result[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return result, nil
+}
+
+// parseBundles parses a list of alias=product|product bundles
+func parseBundles(values []string) (map[string][]string, error) {
+	aliases, err := parseKeyValues(values)
+	if err != nil {
+		return nil, err
+	}
+
+	bundles := make(map[string][]string, len(aliases))
+	for alias, products := range aliases {
+		for _, product := range strings.Split(products, "|") {
+			product = strings.TrimSpace(strings.ToLower(product))
+			if product == "" {
+				return nil, fmt.Errorf("empty product in bundle: %q", alias)
+			}
+			bundles[strings.ToLower(alias)] = append(bundles[strings.ToLower(alias)], product)
+		}
+	}
+	return bundles, nil
+}
+
+// parseProductRewrites parses a list of pattern=replacement product rewrite
+// rules, keeping their order
+func parseProductRewrites(values []string) ([]*ProductRewrite, error) {
+	if len(values) > MaxProductRewrites {
+		return nil, fmt.Errorf("more than %d product rewrites", MaxProductRewrites)
+	}
+
+	rewrites := make([]*ProductRewrite, 0, len(values))
+	for _, v := range values {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid pattern=replacement rule: %q", v)
+		}
+		rewrite, err := NewProductRewrite(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, err
+		}
+		rewrites = append(rewrites, rewrite)
+	}
+	return rewrites, nil
+}
+
+// parseOSFallback parses a list of os=fallback|fallback os fallbacks
+func parseOSFallback(values []string) (map[string][]string, error) {
+	oses, err := parseKeyValues(values)
+	if err != nil {
+		return nil, err
+	}
+
+	fallbacks := make(map[string][]string, len(oses))
+	for name, chain := range oses {
+		for _, fallback := range strings.Split(chain, "|") {
+			fallback = strings.TrimSpace(strings.ToLower(fallback))
+			if fallback == "" {
+				return nil, fmt.Errorf("empty fallback for os: %q", name)
+			}
+			fallbacks[strings.ToLower(name)] = append(fallbacks[strings.ToLower(name)], fallback)
+		}
+	}
+	return fallbacks, nil
+}
+
+// parseMirrorPools parses a list of host=mirror|mirror pairs
+func parseMirrorPools(values []string) (map[string][]string, error) {
+	hosts, err := parseKeyValues(values)
+	if err != nil {
+		return nil, err
+	}
+
+	pools := make(map[string][]string, len(hosts))
+	for host, mirrors := range hosts {
+		host = strings.TrimSpace(strings.ToLower(host))
+		for _, mirror := range strings.Split(mirrors, "|") {
+			mirror = strings.TrimSpace(strings.ToLower(mirror))
+			if mirror == "" {
+				return nil, fmt.Errorf("empty mirror for host: %q", host)
+			}
+			pools[host] = append(pools[host], mirror)
+		}
+	}
+	return pools, nil
+}
+
+// parseShortCodes parses a list of code=product/os/lang short codes
+func parseShortCodes(values []string) (map[string]ResolveTuple, error) {
+	codes, err := parseKeyValues(values)
+	if err != nil {
+		return nil, err
+	}
+
+	shortCodes := make(map[string]ResolveTuple, len(codes))
+	for code, tuple := range codes {
+		parts := strings.Split(tuple, "/")
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid short code, expected product/os/lang: %q", code)
+		}
+		shortCodes[strings.ToLower(code)] = ResolveTuple{
+			Product: parts[0],
+			OS:      parts[1],
+			Lang:    parts[2],
+		}
+	}
+	return shortCodes, nil
+}
+
+// parseCIDRs parses a list of CIDR networks
+func parseCIDRs(values []string) ([]*net.IPNet, error) {
+	cidrs := make([]*net.IPNet, 0, len(values))
+	for _, v := range values {
+		_, cidr, err := net.ParseCIDR(strings.TrimSpace(v))
+		if err != nil {
+			return nil, err
+		}
+		cidrs = append(cidrs, cidr)
+	}
+	return cidrs, nil
+}
+
+// parseRetryAfter parses a list of cause=seconds pairs, where cause is the
+// error code of a 503 response
+func parseRetryAfter(values []string) (map[ErrorCode]time.Duration, error) {
+	pairs, err := parseKeyValues(values)
+	if err != nil {
+		return nil, err
+	}
+
+	retryAfter := make(map[ErrorCode]time.Duration, len(pairs))
+	for cause, v := range pairs {
+		code := ErrorCode(strings.ToLower(cause))
+		if _, ok := DefaultRetryAfter[code]; !ok {
+			return nil, fmt.Errorf("unknown 503 cause: %q", cause)
+		}
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < 0 {
+			return nil, fmt.Errorf("invalid seconds for %s: %q", cause, v)
+		}
+		retryAfter[code] = time.Duration(seconds) * time.Second
+	}
+	return retryAfter, nil
+}
+
+// parseSunsetDates parses product=date pairs, with YYYY-MM-DD or RFC 3339
+// dates
+func parseSunsetDates(values []string) (map[string]time.Time, error) {
+	pairs, err := parseKeyValues(values)
+	if err != nil {
+		return nil, err
+	}
+
+	dates := make(map[string]time.Time, len(pairs))
+	for product, v := range pairs {
+		date, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			date, err = time.Parse(time.RFC3339, v)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid sunset date for %s: %q", product, v)
+		}
+		dates[strings.ToLower(product)] = date
+	}
+	return dates, nil
+}
+
+// parseExperiments parses feature=percentage pairs, with percentages from
+// 0 to 100
+func parseExperiments(values []string) (map[string]float64, error) {
+	pairs, err := parseKeyValues(values)
+	if err != nil {
+		return nil, err
+	}
+
+	experiments := make(map[string]float64, len(pairs))
+	for feature, v := range pairs {
+		percentage, err := strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64)
+		if err != nil || percentage < 0 || percentage > 100 {
+			return nil, fmt.Errorf("invalid percentage for %s: %q", feature, v)
+		}
+		experiments[strings.ToLower(feature)] = percentage
+	}
+	return experiments, nil
+}
+
+// parseSampleRate parses a fraction between 0 and 1. An empty value is 0
+func parseSampleRate(value string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil || rate < 0 || rate > 1 {
+		return 0, fmt.Errorf("invalid sample rate: %q", value)
+	}
+	return rate, nil
+}
+
+// lowerKeys returns a copy of m with lowercased keys
+func lowerKeys(m map[string]string) map[string]string {
+	result := make(map[string]string, len(m))
+	for k, v := range m {
+		result[strings.ToLower(k)] = v
+	}
+	return result
+}
+
 func Main(c *cli.Context) {
 	db, err := bouncer.NewDB(c.String("db-dsn"))
 	if err != nil {
@@ -68,6 +787,273 @@ func Main(c *cli.Context) {
 	}
 	defer db.Close()
 	db.SetConnMaxLifetime(300 * time.Second)
+	logCatalogWarnings(db)
+
+	mirrorDefaultSchemes, err := parseKeyValues(c.StringSlice("mirror-default-scheme"))
+	if err != nil {
+		log.Fatalf("Could not parse mirror-default-scheme: %v", err)
+	}
+
+	var osCanonicalNames map[string]string
+	if names := c.StringSlice("os-canonical-name"); len(names) > 0 {
+		osCanonicalNames, err = parseKeyValues(names)
+		if err != nil {
+			log.Fatalf("Could not parse os-canonical-name: %v", err)
+		}
+		osCanonicalNames = lowerKeys(osCanonicalNames)
+	}
+
+	productMethods, err := parseKeyValues(c.StringSlice("product-methods"))
+	if err != nil {
+		log.Fatalf("Could not parse product-methods: %v", err)
+	}
+
+	productPathPrefixes, err := parseKeyValues(c.StringSlice("product-path-prefix"))
+	if err != nil {
+		log.Fatalf("Could not parse product-path-prefix: %v", err)
+	}
+	productPathPrefixes = lowerKeys(productPathPrefixes)
+
+	partnerDSNs, err := parseKeyValues(c.StringSlice("partner-catalog"))
+	if err != nil {
+		log.Fatalf("Could not parse partner-catalog: %v", err)
+	}
+	if len(partnerDSNs) > 0 && c.String("partner-key") == "" {
+		log.Fatalf("partner-catalog requires partner-key")
+	}
+	partners := make(map[string]Catalog, len(partnerDSNs))
+	for partner, dsn := range partnerDSNs {
+		partnerDB, err := bouncer.NewDB(dsn)
+		if err != nil {
+			log.Fatalf("Could not open DB of partner %s: %v", partner, err)
+		}
+		defer partnerDB.Close()
+		partnerDB.SetConnMaxLifetime(300 * time.Second)
+		partners[strings.ToLower(partner)] = partnerDB
+	}
+
+	var staging Catalog
+	if dsn := c.String("staging-dsn"); dsn != "" && c.Bool("enable-staging") {
+		stagingDB, err := bouncer.NewDB(dsn)
+		if err != nil {
+			log.Fatalf("Could not open staging DB: %v", err)
+		}
+		defer stagingDB.Close()
+		stagingDB.SetConnMaxLifetime(300 * time.Second)
+		staging = stagingDB
+	}
+
+	fallbackCatalogs := []Catalog{}
+	for _, dsn := range c.StringSlice("fallback-dsn") {
+		fallbackDB, err := bouncer.NewDB(dsn)
+		if err != nil {
+			log.Fatalf("Could not open fallback DB: %v", err)
+		}
+		defer fallbackDB.Close()
+		fallbackDB.SetConnMaxLifetime(300 * time.Second)
+		fallbackCatalogs = append(fallbackCatalogs, fallbackDB)
+	}
+
+	attributionEndpoints, err := parseKeyValues(c.StringSlice("attribution-endpoint"))
+	if err != nil {
+		log.Fatalf("Could not parse attribution-endpoint: %v", err)
+	}
+
+	eulaProducts, err := parseKeyValues(c.StringSlice("eula-product"))
+	if err != nil {
+		log.Fatalf("Could not parse eula-product: %v", err)
+	}
+	if len(eulaProducts) > 0 && c.String("eula-key") == "" {
+		log.Fatalf("eula-product requires eula-key")
+	}
+
+	esrCycles, err := parseKeyValues(c.StringSlice("esr-cycle"))
+	if err != nil {
+		log.Fatalf("Could not parse esr-cycle: %v", err)
+	}
+
+	productRewrites, err := parseProductRewrites(c.StringSlice("product-rewrite"))
+	if err != nil {
+		log.Fatalf("Could not parse product-rewrite: %v", err)
+	}
+
+	var uaRewriteRules []*UARewriteRule
+	if path := c.String("ua-rewrite-rules"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("Could not open ua-rewrite-rules: %v", err)
+		}
+		uaRewriteRules, err = readUARewriteRules(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("Could not parse ua-rewrite-rules: %v", err)
+		}
+	}
+
+	var checksumIndex ChecksumIndex
+	if path := c.String("checksums-file"); path != "" {
+		data, err := db.LoadCatalogData()
+		if err != nil {
+			log.Fatalf("Could not load catalog for checksums-file: %v", err)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("Could not open checksums-file: %v", err)
+		}
+		checksumIndex, err = BuildChecksumIndex(data, f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("Could not parse checksums-file: %v", err)
+		}
+		log.Printf("Indexed %d checksums", len(checksumIndex))
+	}
+
+	var sha1AliasSuffixes []string
+	if suffixes := c.StringSlice("sha1-alias-suffix"); len(suffixes) > 0 {
+		sha1AliasSuffixes = suffixes
+	}
+
+	productRenames, err := parseKeyValues(c.StringSlice("product-rename"))
+	if err != nil {
+		log.Fatalf("Could not parse product-rename: %v", err)
+	}
+
+	var thunderbirdNames map[string]string
+	if names := c.StringSlice("thunderbird-name"); len(names) > 0 {
+		thunderbirdNames, err = parseKeyValues(names)
+		if err != nil {
+			log.Fatalf("Could not parse thunderbird-name: %v", err)
+		}
+		thunderbirdNames = lowerKeys(thunderbirdNames)
+	}
+
+	productFeatureFlags, err := parseKeyValues(c.StringSlice("product-feature-flag"))
+	if err != nil {
+		log.Fatalf("Could not parse product-feature-flag: %v", err)
+	}
+
+	experiments, err := parseExperiments(c.StringSlice("experiment"))
+	if err != nil {
+		log.Fatalf("Could not parse experiment: %v", err)
+	}
+
+	sunsetDates, err := parseSunsetDates(c.StringSlice("sunset-date"))
+	if err != nil {
+		log.Fatalf("Could not parse sunset-date: %v", err)
+	}
+
+	retryAfter, err := parseRetryAfter(c.StringSlice("retry-after"))
+	if err != nil {
+		log.Fatalf("Could not parse retry-after: %v", err)
+	}
+
+	mirrorPools, err := parseMirrorPools(c.StringSlice("mirror-pool"))
+	if err != nil {
+		log.Fatalf("Could not parse mirror-pool: %v", err)
+	}
+	mirrorRegions, err := parseMirrorPools(c.StringSlice("mirror-region"))
+	if err != nil {
+		log.Fatalf("Could not parse mirror-region: %v", err)
+	}
+
+	osFallback, err := parseOSFallback(c.StringSlice("os-fallback"))
+	if err != nil {
+		log.Fatalf("Could not parse os-fallback: %v", err)
+	}
+
+	universalOS, err := parseKeyValues(c.StringSlice("universal-os"))
+	if err != nil {
+		log.Fatalf("Could not parse universal-os: %v", err)
+	}
+
+	var osVersionNames map[string]string
+	if names := c.StringSlice("os-version-name"); len(names) > 0 {
+		osVersionNames, err = parseKeyValues(names)
+		if err != nil {
+			log.Fatalf("Could not parse os-version-name: %v", err)
+		}
+		osVersionNames = lowerKeys(osVersionNames)
+	}
+
+	languageRegions, err := parseKeyValues(c.StringSlice("language-region"))
+	if err != nil {
+		log.Fatalf("Could not parse language-region: %v", err)
+	}
+
+	localeFallbacks, err := parseKeyValues(c.StringSlice("locale-fallback"))
+	if err != nil {
+		log.Fatalf("Could not parse locale-fallback: %v", err)
+	}
+
+	shortCodes, err := parseShortCodes(c.StringSlice("short-code"))
+	if err != nil {
+		log.Fatalf("Could not parse short-code: %v", err)
+	}
+
+	bundles, err := parseBundles(c.StringSlice("bundle"))
+	if err != nil {
+		log.Fatalf("Could not parse bundle: %v", err)
+	}
+
+	archiveProducts, err := parseKeyValues(c.StringSlice("archive-product"))
+	if err != nil {
+		log.Fatalf("Could not parse archive-product: %v", err)
+	}
+
+	xpSupportedUntil, err := parseKeyValues(c.StringSlice("xp-supported-until"))
+	if err != nil {
+		log.Fatalf("Could not parse xp-supported-until: %v", err)
+	}
+
+	trustedCIDRs, err := parseCIDRs(c.StringSlice("trusted-cidrs"))
+	if err != nil {
+		log.Fatalf("Could not parse trusted-cidrs: %v", err)
+	}
+
+	emptyProductPolicy := EmptyProductPolicy(c.String("empty-product-policy"))
+	switch emptyProductPolicy {
+	case EmptyProductRedirect, EmptyProductBadRequest:
+	default:
+		log.Fatalf("Unknown empty product policy: %s", emptyProductPolicy)
+	}
+
+	httpPinMismatch := SchemeMismatchPolicy(c.String("http-pin-mismatch"))
+	httpsPinMismatch := SchemeMismatchPolicy(c.String("https-pin-mismatch"))
+	for _, policy := range []SchemeMismatchPolicy{httpPinMismatch, httpsPinMismatch} {
+		switch policy {
+		case SchemeMismatchNoMirror, SchemeMismatchServe, SchemeMismatchError:
+		default:
+			log.Fatalf("Unknown scheme mismatch policy: %s", policy)
+		}
+	}
+
+	adminCIDRs, err := parseCIDRs(c.StringSlice("admin-cidrs"))
+	if err != nil {
+		log.Fatalf("Could not parse admin-cidrs: %v", err)
+	}
+
+	recentErrors := NewRecentErrors(c.Int("recent-errors"))
+	var missingLocales *MissingLocales
+	if size := c.Int("missing-locales"); size > 0 {
+		missingLocales = NewMissingLocales(size)
+	}
+
+	maintenance := &Maintenance{}
+	stubSwitch := &StubSwitch{}
+	stubSwitch.SetEnabled(!c.Bool("stub-disabled"))
+	building := NewBuildingProducts(c.StringSlice("building-products")...)
+
+	metrics := NewExpvarMetrics()
+	metrics.Exemplars = c.Bool("metrics-exemplars")
+	var handlerMetrics Metrics = metrics
+	if addr := c.String("statsd-addr"); addr != "" {
+		statsd, err := NewStatsDSink(addr, c.String("statsd-prefix"), c.StringSlice("statsd-tag"))
+		if err != nil {
+			log.Fatalf("Could not open statsd-addr: %v", err)
+		}
+		defer statsd.Close()
+		handlerMetrics = MultiMetrics{metrics, statsd}
+	}
 
 	bouncerHandler := &BouncerHandler{
 		db:                 db,
@@ -76,22 +1062,223 @@ func Main(c *cli.Context) {
 		PinnedBaseURLHttp:  c.String("pinned-baseurl-http"),
 		PinnedBaseURLHttps: c.String("pinned-baseurl-https"),
 		StubRootURL:        c.String("stub-root-url"),
+		InferOS:            c.Bool("infer-os"),
+		CleanOSLang:        c.Bool("clean-os-lang"),
+		ClientOSHeaderName: c.String("client-os-header-name"),
+		UpgradeWin64:       c.Bool("upgrade-win64"),
+		LicenseURLTemplate: c.String("license-url-template"),
+		ParseProductLocale: c.Bool("parse-product-locale"),
+		MultipleChoices:    c.Bool("multiple-choices"),
+		TimingAllowOrigin:  c.String("timing-allow-origin"),
+		RetryAfter:         retryAfter,
+		CacheBusting:       c.Bool("cache-busting"),
+		PrintJSON:          c.Bool("print-json"),
+		PreferHTTPS:        c.Bool("prefer-https"),
+		HTTPSFallback:      c.Bool("https-fallback"),
+		HTTPSLangs:         c.StringSlice("https-lang"),
+		Maintenance:        maintenance,
+		StubSwitch:         stubSwitch,
+		Building:           building,
+		SunsetDates:        sunsetDates,
+		SunsetPolicyURL:    c.String("sunset-policy-url"),
+		EmptyProductPolicy: emptyProductPolicy,
+		HTTPPinMismatch:    httpPinMismatch,
+		HTTPSPinMismatch:   httpsPinMismatch,
+
+		CanonicalSchemeUpgrade: c.Bool("canonical-scheme-upgrade"),
+		EnableAcceptLanguage:   c.Bool("accept-language"),
+		ContentSecurityPolicy:  c.String("content-security-policy"),
+
+		CanonicalOSKeys:  c.Bool("canonical-os-keys"),
+		OSCanonicalNames: osCanonicalNames,
+
+		NormalizeThunderbird: c.Bool("normalize-thunderbird"),
+		ThunderbirdNames:     thunderbirdNames,
+
+		MirrorDefaultSchemes: mirrorDefaultSchemes,
+		ProductPathPrefixes:  productPathPrefixes,
+		ProductMethods:       lowerKeys(productMethods),
+		SHA1RewriteProducts:  c.StringSlice("sha1-rewrite-products"),
+		SHA1AliasSuffixes:    sha1AliasSuffixes,
+		XPSupportedUntil:     lowerKeys(xpSupportedUntil),
+		ArchiveBaseURL:       c.String("archive-baseurl"),
+		ArchiveProducts:      lowerKeys(archiveProducts),
+		TrustedCIDRs:         trustedCIDRs,
+		TrustForwarded:       c.Bool("trust-forwarded"),
+		AdminCIDRs:           adminCIDRs,
+		Bundles:              bundles,
+		Checksums:            checksumIndex.FileChecksums(),
+		ShortCodes:           shortCodes,
+		LanguageRegions:      lowerKeys(languageRegions),
+		LocaleFallbackTable:  lowerKeys(localeFallbacks),
+		LangLandingURL:       c.String("lang-landing-url"),
+		UniversalOS:          lowerKeys(universalOS),
+		OSVersionNames:       osVersionNames,
+		OSFallback:           osFallback,
+		MaxFallbackAttempts:  c.Int("max-fallback-attempts"),
+		ProductFeatureFlags:  lowerKeys(productFeatureFlags),
+		Experiments:          experiments,
+		ESRCycles:            esrCycles,
+		Partners:             partners,
+		PartnerKey:           []byte(c.String("partner-key")),
+		Staging:              staging,
+		EULAProducts:         lowerKeys(eulaProducts),
+		AttributionEndpoints: lowerKeys(attributionEndpoints),
+		EULAKey:              []byte(c.String("eula-key")),
+		EnableStaging:        c.Bool("enable-staging"),
+		FallbackCatalogs:     fallbackCatalogs,
+		ProductRewrites:      productRewrites,
+		UARewriteRules:       uaRewriteRules,
+		ProductRenames:       lowerKeys(productRenames),
+		CanonicalizeRenames:  c.Bool("canonicalize-renames"),
+		MirrorFallbackURL:    c.String("mirror-fallback-url"),
+		MirrorPools:          mirrorPools,
+		MirrorRegions:        mirrorRegions,
+		StrictRegions:        c.Bool("strict-regions"),
+		Metrics:              handlerMetrics,
+		ExplainLog:           os.Stdout,
+		RecentErrors:         recentErrors,
+		MissingLocales:       missingLocales,
+		Torrents:             c.Bool("torrents"),
+		TorrentPathTemplate:  c.String("torrent-path-template"),
+		DebugToken:           c.String("debug-token"),
+	}
+
+	if c.Bool("log-mirror-decisions") {
+		bouncerHandler.MirrorDecisionLog = os.Stdout
+	}
+
+	if interval := c.Int("mirror-latency-interval"); interval > 0 {
+		bouncerHandler.MirrorLatencies = NewMirrorLatencies()
+		probes := NewProbeLimiter(c.Int("max-concurrent-probes"))
+		probes.FailFast = c.Bool("probe-fail-fast")
+		checker := &MirrorLatencyChecker{
+			Catalog:   db,
+			Latencies: bouncerHandler.MirrorLatencies,
+			Client:    &http.Client{Timeout: DefaultDependencyTimeout},
+			Interval:  time.Duration(interval) * time.Second,
+			Probes:    probes,
+		}
+		go checker.Run(context.Background())
+	}
+
+	if products := c.StringSlice("one-time-products"); len(products) > 0 {
+		bouncerHandler.OneTimeProducts = products
+		bouncerHandler.OneTimeTokens = NewMemoryTokenStore()
+		bouncerHandler.OneTimeTTL = time.Duration(c.Int("one-time-ttl")) * time.Second
+	}
+
+	if ttl := c.Int("alias-cache-ttl"); ttl > 0 {
+		bouncerHandler.AliasCache = NewAliasCache(time.Duration(ttl)*time.Second, c.Int("alias-cache-size"))
+	}
+
+	switch format := c.String("access-log-format"); format {
+	case "":
+	case AccessLogFormatJSON, AccessLogFormatCLF:
+		sampleRate, err := parseSampleRate(c.String("access-log-sample-rate"))
+		if err != nil {
+			log.Fatalf("Could not parse access log sample rate: %v", err)
+		}
+		bouncerHandler.AccessLog = &AccessLogger{
+			Format:        format,
+			Output:        os.Stdout,
+			LogFullURL:    c.BoolT("access-log-full-url"),
+			LogSampleRate: sampleRate,
+		}
+	default:
+		log.Fatalf("Unknown access log format: %s", format)
 	}
 
 	healthHandler := &HealthHandler{
 		db:        db,
 		CacheTime: 5 * time.Second,
+		Dependencies: []DependencyCheck{
+			{Name: "mirrors", Required: false, Check: MirrorsCheck(db)},
+		},
+	}
+	if stubRootURL := c.String("stub-root-url"); stubRootURL != "" {
+		healthHandler.Dependencies = append(healthHandler.Dependencies, DependencyCheck{
+			Name:     "stub",
+			Required: false,
+			Check:    URLCheck(&http.Client{Timeout: DefaultDependencyTimeout}, stubRootURL),
+		})
+	}
+
+	// Load balancer heartbeats are frequent, so they only ping the db
+	lbHeartbeatHandler := &HealthHandler{
+		db:        db,
+		CacheTime: 5 * time.Second,
+	}
+
+	robotsHandler := &RobotsHandler{
+		Body:      c.String("robots-txt"),
+		CacheTime: time.Duration(c.Int("cache-time")) * time.Second,
+	}
+
+	faviconHandler := &FaviconHandler{
+		RedirectURL: c.String("favicon-url"),
+		CacheTime:   time.Duration(c.Int("cache-time")) * time.Second,
 	}
 
 	mux := http.NewServeMux()
 
-	mux.Handle("/__lbheartbeat__", healthHandler)
+	mux.Handle("/__lbheartbeat__", lbHeartbeatHandler)
 	mux.Handle("/__heartbeat__", healthHandler)
+	mux.Handle("/robots.txt", robotsHandler)
+	mux.Handle("/__debug__/errors", &RecentErrorsHandler{
+		Errors: recentErrors,
+		Token:  c.String("debug-token"),
+	})
+	mux.Handle(MissingLocalesPath, &MissingLocalesHandler{
+		Misses: missingLocales,
+		Token:  c.String("debug-token"),
+	})
+	mux.Handle("/favicon.ico", faviconHandler)
+	mux.Handle("/__products__", &ProductsHandler{db: db})
+	mux.Handle("/__matrix__", &MatrixHandler{db: db})
+	if bouncerHandler.OneTimeTokens != nil {
+		mux.Handle(RedeemPath, &RedeemHandler{Tokens: bouncerHandler.OneTimeTokens, Bouncer: bouncerHandler})
+	}
+	if checksumIndex != nil {
+		mux.Handle("/__checksum__", &ChecksumHandler{Index: checksumIndex})
+	}
+	capabilitiesHandler := &CapabilitiesHandler{Bouncer: bouncerHandler}
+	if c.Bool("gzip") {
+		capabilitiesHandler.GzipMinSize = (&GzipHandler{MinSize: c.Int("gzip-min-size")}).minSize()
+	}
+	mux.Handle(CapabilitiesPath, capabilitiesHandler)
 	mux.Handle("/", bouncerHandler)
 
+	if addr := c.String("admin-addr"); addr != "" {
+		adminHandler := &AdminHandler{
+			Maintenance:  maintenance,
+			AliasCache:   bouncerHandler.AliasCache,
+			Building:     building,
+			StubSwitch:   stubSwitch,
+			Events:       &MozLogEventSink{Output: os.Stdout},
+			AllowedCIDRs: adminCIDRs,
+		}
+
+		adminMux := http.NewServeMux()
+		adminMux.Handle("/__admin__/", adminHandler)
+		adminMux.Handle("/debug/vars", expvar.Handler())
+
+		go func() {
+			log.Fatal(http.ListenAndServe(addr, &MethodsHandler{
+				Handler: adminMux,
+				Allow:   []string{"GET", "HEAD", "POST"},
+			}))
+		}()
+	}
+
+	var handler http.Handler = mux
+	if c.Bool("gzip") {
+		handler = &GzipHandler{Handler: mux, MinSize: c.Int("gzip-min-size")}
+	}
+
 	server := &http.Server{
 		Addr:    c.String("addr"),
-		Handler: mux,
+		Handler: &MethodsHandler{Handler: handler},
 	}
 
 	err = server.ListenAndServe()
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// DefaultAllowedMethods are the methods bouncer responds to
+var DefaultAllowedMethods = []string{"GET", "HEAD"}
+
+// MethodsHandler answers TRACE and OPTIONS requests itself, so they never
+// reach Handler: TRACE is rejected with a 405 and OPTIONS gets a 204 listing
+// the Allow methods. Other requests are served by Handler.
+type MethodsHandler struct {
+	Handler http.Handler
+	// Allow is the methods of the Allow header. DefaultAllowedMethods if
+	// empty.
+	Allow []string
+}
+
+func (m *MethodsHandler) allow() string {
+	if len(m.Allow) == 0 {
+		return strings.Join(DefaultAllowedMethods, ", ")
+	}
+	return strings.Join(m.Allow, ", ")
+}
+
+func (m *MethodsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "TRACE":
+		w.Header().Set("Allow", m.allow())
+		http.Error(w, "Method Not Allowed.", http.StatusMethodNotAllowed)
+	case "OPTIONS":
+		w.Header().Set("Allow", m.allow())
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		m.Handler.ServeHTTP(w, req)
+	}
+}
+
+// productMethods returns the methods product is served with under
+// ProductMethods, resolved after aliases, or nil if it has no policy. GET
+// implies HEAD.
+func (b *BouncerHandler) productMethods(product string) ([]string, error) {
+	if len(b.ProductMethods) == 0 {
+		return nil, nil
+	}
+	product, err := b.catalogProduct(product)
+	if err != nil {
+		return nil, err
+	}
+	policy := productOrFamilyValue(b.ProductMethods, product)
+	if policy == "" {
+		return nil, nil
+	}
+
+	methods := []string{}
+	for _, method := range strings.Split(policy, "|") {
+		method = strings.ToUpper(strings.TrimSpace(method))
+		methods = append(methods, method)
+		if method == "GET" {
+			methods = append(methods, "HEAD")
+		}
+	}
+	return methods, nil
+}
+
+func methodAllowed(methods []string, method string) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// redirectStatus is the status of a redirect to a resolved url. Methods
+// other than GET and HEAD which the product's policy allows get a 307, so
+// the client repeats the request at the url.
+func (b *BouncerHandler) redirectStatus(req *http.Request, product string) int {
+	if req.Method == "GET" || req.Method == "HEAD" {
+		return http.StatusFound
+	}
+	if methods, err := b.productMethods(product); err == nil && methodAllowed(methods, req.Method) {
+		return http.StatusTemporaryRedirect
+	}
+	return http.StatusFound
+}
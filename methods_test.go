@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMethodsHandler(t *testing.T) {
+	testRequests := []struct {
+		Handler       *MethodsHandler
+		Method        string
+		ExpectedCode  int
+		ExpectedAllow string
+	}{
+		{&MethodsHandler{Handler: bouncerHandler}, "TRACE", 405, "GET, HEAD"},
+		{&MethodsHandler{Handler: bouncerHandler}, "OPTIONS", 204, "GET, HEAD"},
+		{&MethodsHandler{Handler: bouncerHandler, Allow: []string{"GET", "HEAD", "POST"}}, "OPTIONS", 204, "GET, HEAD, POST"},
+		{&MethodsHandler{Handler: bouncerHandler}, "GET", 302, ""},
+		{&MethodsHandler{Handler: bouncerHandler}, "HEAD", 302, ""},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest(testRequest.Method, "http://test/?product=firefox-latest&os=osx&lang=en-US", nil)
+		assert.NoError(t, err)
+
+		testRequest.Handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedCode, w.Code, "method: %v", testRequest.Method)
+		assert.Equal(t, testRequest.ExpectedAllow, w.HeaderMap.Get("Allow"), "method: %v", testRequest.Method)
+	}
+}
+
+func TestBouncerHandlerProductMethods(t *testing.T) {
+	handler := &BouncerHandler{
+		db: bouncerHandler.db,
+		ProductMethods: map[string]string{
+			"firefox":     "GET",
+			"firefox-ssl": "GET|POST",
+		},
+	}
+
+	testRequests := []struct {
+		Method           string
+		URL              string
+		ExpectedCode     int
+		ExpectedLocation string
+		ExpectedAllow    string
+	}{
+		{"GET", "http://test/?product=firefox-latest&os=osx&lang=en-US", 302, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", ""},
+		{"HEAD", "http://test/?product=firefox-latest&os=osx&lang=en-US", 302, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", ""},
+		{"POST", "http://test/?product=firefox-latest&os=osx&lang=en-US", 405, "", "GET, HEAD"},
+		{"PUT", "http://test/?product=firefox-latest&os=osx&lang=en-US", 405, "", "GET, HEAD"},
+		{"POST", "http://test/?product=firefox-ssl&os=osx&lang=en-US", 307, "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", ""},
+		{"GET", "http://test/?product=firefox-ssl&os=osx&lang=en-US", 302, "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", ""},
+		{"PUT", "http://test/?product=firefox-ssl&os=osx&lang=en-US", 405, "", "GET, HEAD, POST"},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest(testRequest.Method, testRequest.URL, nil)
+		assert.NoError(t, err)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedCode, w.Code, "%v %v", testRequest.Method, testRequest.URL)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "%v %v", testRequest.Method, testRequest.URL)
+		assert.Equal(t, testRequest.ExpectedAllow, w.HeaderMap.Get("Allow"), "%v %v", testRequest.Method, testRequest.URL)
+	}
+
+	// Products without a policy are served with any method
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "http://test/?product=firefox-latest&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+	bouncerHandler.ServeHTTP(w, req)
+	assert.Equal(t, 302, w.Code)
+}
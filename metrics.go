@@ -0,0 +1,127 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// TimingBuckets are the upper bounds, in milliseconds, of the histogram
+// buckets of timings
+var TimingBuckets = []int{1, 5, 10, 25, 50, 100, 250, 500, 1000}
+
+// Metrics receives counters and timings from the bouncer handler
+type Metrics interface {
+	Incr(name string)
+	Timing(name string, d time.Duration)
+}
+
+// ExemplarMetrics are Metrics which can link a timing to the trace of the
+// request it was recorded for
+type ExemplarMetrics interface {
+	Metrics
+	TimingExemplar(name string, d time.Duration, traceID string)
+}
+
+// TaggedMetrics are Metrics which can tag counters, e.g. with the product
+// and os of a download
+type TaggedMetrics interface {
+	Metrics
+	IncrTagged(name string, tags map[string]string)
+}
+
+// MultiMetrics sends metrics to every one of its Metrics. Tags and
+// exemplars are dropped for those which don't support them.
+type MultiMetrics []Metrics
+
+// Incr increments the counter name of every Metrics
+func (m MultiMetrics) Incr(name string) {
+	for _, metrics := range m {
+		metrics.Incr(name)
+	}
+}
+
+// Timing records a timing in every Metrics
+func (m MultiMetrics) Timing(name string, d time.Duration) {
+	for _, metrics := range m {
+		metrics.Timing(name, d)
+	}
+}
+
+// IncrTagged increments the counter name of every Metrics, with tags if
+// they support them
+func (m MultiMetrics) IncrTagged(name string, tags map[string]string) {
+	for _, metrics := range m {
+		if tagged, ok := metrics.(TaggedMetrics); ok {
+			tagged.IncrTagged(name, tags)
+		} else {
+			metrics.Incr(name)
+		}
+	}
+}
+
+// TimingExemplar records a timing in every Metrics, with its exemplar if
+// they support them
+func (m MultiMetrics) TimingExemplar(name string, d time.Duration, traceID string) {
+	for _, metrics := range m {
+		if exemplar, ok := metrics.(ExemplarMetrics); ok {
+			exemplar.TimingExemplar(name, d, traceID)
+		} else {
+			metrics.Timing(name, d)
+		}
+	}
+}
+
+// ExpvarMetrics publishes metrics as the "bouncer" expvar
+type ExpvarMetrics struct {
+	// Exemplars keeps the trace id of the last timing counted in each
+	// histogram bucket, in OpenMetrics exemplar format, as
+	// name.le_<ms>.exemplar. Not every scraper supports exemplars.
+	Exemplars bool
+
+	vars *expvar.Map
+}
+
+// NewExpvarMetrics returns metrics published in the "bouncer" expvar. It
+// must only be called once.
+func NewExpvarMetrics() *ExpvarMetrics {
+	return &ExpvarMetrics{vars: expvar.NewMap("bouncer")}
+}
+
+// Incr increments the counter name
+func (e *ExpvarMetrics) Incr(name string) {
+	e.vars.Add(name, 1)
+}
+
+// Timing adds d, in milliseconds, to the total of name, and counts it in
+// name.count and in the name.le_<ms> counter of the smallest TimingBuckets
+// bucket it fits in, or name.le_inf
+func (e *ExpvarMetrics) Timing(name string, d time.Duration) {
+	e.vars.AddFloat(name, float64(d)/float64(time.Millisecond))
+	e.vars.Add(name+".count", 1)
+	e.vars.Add(name+"."+timingBucket(d), 1)
+}
+
+// TimingExemplar records a timing like Timing and, if Exemplars is set and
+// traceID isn't empty, sets the exemplar of its bucket to
+// {trace_id="<traceID>"} <ms>
+func (e *ExpvarMetrics) TimingExemplar(name string, d time.Duration, traceID string) {
+	e.Timing(name, d)
+	if !e.Exemplars || traceID == "" {
+		return
+	}
+	exemplar := new(expvar.String)
+	exemplar.Set(fmt.Sprintf(`{trace_id="%s"} %s`, traceID, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64)))
+	e.vars.Set(name+"."+timingBucket(d)+".exemplar", exemplar)
+}
+
+// timingBucket returns the name of the histogram bucket of d
+func timingBucket(d time.Duration) string {
+	for _, ms := range TimingBuckets {
+		if d <= time.Duration(ms)*time.Millisecond {
+			return "le_" + strconv.Itoa(ms)
+		}
+	}
+	return "le_inf"
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpvarMetrics(t *testing.T) {
+	metrics := &ExpvarMetrics{vars: new(expvar.Map).Init()}
+	metrics.Incr("mirror.fallback")
+	metrics.Incr("mirror.fallback")
+	metrics.Timing("resolve", 1500*time.Microsecond)
+
+	assert.Equal(t, "2", metrics.vars.Get("mirror.fallback").String())
+	assert.Equal(t, "1.5", metrics.vars.Get("resolve").String())
+	assert.Equal(t, "1", metrics.vars.Get("resolve.count").String())
+	assert.Equal(t, "1", metrics.vars.Get("resolve.le_5").String())
+	assert.Nil(t, metrics.vars.Get("resolve.le_1"))
+
+	metrics.Timing("resolve", 2*time.Second)
+	assert.Equal(t, "1", metrics.vars.Get("resolve.le_inf").String())
+	assert.Equal(t, "2", metrics.vars.Get("resolve.count").String())
+}
+
+func TestExpvarMetricsExemplars(t *testing.T) {
+	metrics := &ExpvarMetrics{vars: new(expvar.Map).Init()}
+	metrics.TimingExemplar("resolve", 1500*time.Microsecond, "4bf92f3577b34da6a3ce929d0e0e4736")
+	assert.Equal(t, "1", metrics.vars.Get("resolve.le_5").String())
+	assert.Nil(t, metrics.vars.Get("resolve.le_5.exemplar"))
+
+	metrics.Exemplars = true
+	metrics.TimingExemplar("resolve", 1500*time.Microsecond, "")
+	assert.Nil(t, metrics.vars.Get("resolve.le_5.exemplar"))
+
+	metrics.TimingExemplar("resolve", 1500*time.Microsecond, "4bf92f3577b34da6a3ce929d0e0e4736")
+	assert.Equal(t, `"{trace_id=\"4bf92f3577b34da6a3ce929d0e0e4736\"} 1.5"`, metrics.vars.Get("resolve.le_5.exemplar").String())
+	assert.Equal(t, "3", metrics.vars.Get("resolve.count").String())
+}
+
+func TestBouncerHandlerTimingExemplars(t *testing.T) {
+	metrics := &ExpvarMetrics{Exemplars: true, vars: new(expvar.Map).Init()}
+	handler := &BouncerHandler{db: bouncerHandler.db, Metrics: metrics}
+
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+	req.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	exemplars := 0
+	metrics.vars.Do(func(kv expvar.KeyValue) {
+		if strings.HasPrefix(kv.Key, "resolve.le_") && strings.HasSuffix(kv.Key, ".exemplar") {
+			exemplars++
+			assert.Contains(t, kv.Value.String(), `trace_id=\"4bf92f3577b34da6a3ce929d0e0e4736\"`)
+		}
+	})
+	assert.Equal(t, 1, exemplars)
+}
+
+func TestMultiMetrics(t *testing.T) {
+	expvarMetrics := &ExpvarMetrics{vars: new(expvar.Map).Init()}
+	recording := &recordingMetrics{}
+	metrics := MultiMetrics{expvarMetrics, recording}
+
+	metrics.IncrTagged("download", map[string]string{"product": "firefox-latest"})
+	metrics.TimingExemplar("resolve", 3*time.Millisecond, "4bf92f3577b34da6a3ce929d0e0e4736")
+
+	assert.Equal(t, "1", expvarMetrics.vars.Get("download").String())
+	assert.Equal(t, "1", expvarMetrics.vars.Get("resolve.count").String())
+	assert.Equal(t, map[string]int{"download": 1, "resolve": 1}, recording.counts)
+}
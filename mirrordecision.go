@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/mozilla-services/go-bouncer/bouncer"
+	"github.com/mozilla-services/go-bouncer/mozlog"
+)
+
+// MirrorDecisionHeader is the response header debug requests get the
+// MirrorDecision of their mirror in, as json
+const MirrorDecisionHeader = "X-Bouncer-Mirror-Decision"
+
+// Mirror selection strategies
+const (
+	StrategyPinned   = "pinned"
+	StrategyLatency  = "latency"
+	StrategyWeighted = "weighted"
+	StrategyNone     = "none"
+)
+
+// Reasons a mirror candidate couldn't be chosen
+const (
+	ExcludedScheme     = "scheme"
+	ExcludedPool       = "pool"
+	ExcludedZeroRating = "zero_rating"
+	ExcludedNoLatency  = "no_latency"
+)
+
+// MirrorDecision records how the mirror of a request was chosen
+type MirrorDecision struct {
+	SSLOnly    bool              `json:"ssl_only"`
+	Strategy   string            `json:"strategy"`
+	Candidates []MirrorCandidate `json:"candidates,omitempty"`
+	// Roll is the weighted roll, between 1 and the total rating of the
+	// candidates, if the mirror was picked by rating
+	Roll    int    `json:"roll,omitempty"`
+	Chosen  string `json:"chosen,omitempty"`
+	BaseURL string `json:"base_url,omitempty"`
+}
+
+// MirrorCandidate is a mirror considered for a request. Inactive and
+// unhealthy mirrors aren't candidates.
+type MirrorCandidate struct {
+	ID        string  `json:"id"`
+	BaseURL   string  `json:"base_url"`
+	Rating    int     `json:"rating"`
+	LatencyMS float64 `json:"latency_ms,omitempty"`
+	// Excluded is why the mirror couldn't be chosen, if it couldn't
+	Excluded string `json:"excluded,omitempty"`
+}
+
+// mirrorDecisions keeps the last mirror decision of a request
+type mirrorDecisions struct {
+	// debug requests get the decision in MirrorDecisionHeader
+	debug bool
+	last  *MirrorDecision
+}
+
+// isDebug returns true if req has an "Authorization: Bearer <DebugToken>"
+// header
+func (b *BouncerHandler) isDebug(req *http.Request) bool {
+	if b.DebugToken == "" {
+		return false
+	}
+	token := req.Header.Get("Authorization")
+	return subtle.ConstantTimeCompare([]byte(token), []byte("Bearer "+b.DebugToken)) == 1
+}
+
+// withMirrorDecisions returns a copy of b recording its mirror decisions,
+// if req is a debug request or they are logged. b is returned otherwise.
+func (b *BouncerHandler) withMirrorDecisions(req *http.Request) *BouncerHandler {
+	debug := b.isDebug(req)
+	if !debug && b.MirrorDecisionLog == nil {
+		return b
+	}
+	db := *b
+	db.mirrorDecisions = &mirrorDecisions{debug: debug}
+	return &db
+}
+
+// newMirrorDecision starts recording a mirror decision, or returns nil if
+// the handler doesn't record them
+func (b *BouncerHandler) newMirrorDecision(sslOnly bool) *MirrorDecision {
+	if b.mirrorDecisions == nil {
+		return nil
+	}
+	d := &MirrorDecision{SSLOnly: sslOnly, Strategy: StrategyNone}
+	b.mirrorDecisions.last = d
+	return d
+}
+
+// pin records that a pinned base url was used
+func (d *MirrorDecision) pin(baseURL string) {
+	if d == nil {
+		return
+	}
+	d.Strategy = StrategyPinned
+	d.BaseURL = baseURL
+}
+
+// consider records the candidates of the decision
+func (d *MirrorDecision) consider(mirrors []bouncer.MirrorsResult, latencies *MirrorLatencies) {
+	if d == nil {
+		return
+	}
+	for _, m := range mirrors {
+		c := MirrorCandidate{ID: m.ID, BaseURL: m.BaseURL, Rating: m.Rating}
+		if latencies != nil {
+			if latency, ok := latencies.Latency(m.ID); ok {
+				c.LatencyMS = float64(latency) / float64(time.Millisecond)
+			}
+		}
+		if m.Rating <= 0 {
+			c.Excluded = ExcludedZeroRating
+		}
+		d.Candidates = append(d.Candidates, c)
+	}
+}
+
+// exclude records mirrors which couldn't be chosen, and why
+func (d *MirrorDecision) exclude(mirrors []bouncer.MirrorsResult, reason string) {
+	if d == nil {
+		return
+	}
+	for _, m := range mirrors {
+		d.Candidates = append(d.Candidates, MirrorCandidate{ID: m.ID, BaseURL: m.BaseURL, Rating: m.Rating, Excluded: reason})
+	}
+}
+
+// choose records the chosen mirror and how it was chosen. Candidates
+// without a latency can't be chosen by latency.
+func (d *MirrorDecision) choose(mirror *bouncer.MirrorsResult, strategy string, roll int, baseURL string) {
+	if d == nil || mirror == nil {
+		return
+	}
+	d.Strategy = strategy
+	d.Roll = roll
+	d.Chosen = mirror.ID
+	d.BaseURL = baseURL
+	if strategy != StrategyLatency {
+		return
+	}
+	for i, c := range d.Candidates {
+		if c.Excluded == "" && c.LatencyMS == 0 {
+			d.Candidates[i].Excluded = ExcludedNoLatency
+		}
+	}
+}
+
+// reportMirrorDecision sends the last mirror decision of a request to debug
+// clients, uncached, and to MirrorDecisionLog
+func (b *BouncerHandler) reportMirrorDecision(w http.ResponseWriter, reqParams *BouncerParams) {
+	if b.mirrorDecisions == nil || b.mirrorDecisions.last == nil {
+		return
+	}
+	decision := b.mirrorDecisions.last
+
+	if b.mirrorDecisions.debug {
+		res, err := json.Marshal(decision)
+		if err != nil {
+			log.Printf("reportMirrorDecision err: %v", err)
+		} else {
+			w.Header().Set(MirrorDecisionHeader, string(res))
+			w.Header().Set("Cache-Control", "no-store")
+		}
+	}
+
+	if b.MirrorDecisionLog == nil {
+		return
+	}
+	appLog := mozlog.NewAppLog("Bouncer", nil)
+	appLog.Type = "mirror.decision"
+	appLog.Fields = map[string]interface{}{
+		"product":  reqParams.Product,
+		"os":       reqParams.OS,
+		"lang":     reqParams.Lang,
+		"decision": decision,
+	}
+
+	line, err := appLog.ToJSON()
+	if err != nil {
+		log.Printf("reportMirrorDecision err: %v", err)
+		return
+	}
+	if _, err := b.MirrorDecisionLog.Write(append(line, '\n')); err != nil {
+		log.Printf("reportMirrorDecision err: %v", err)
+	}
+}
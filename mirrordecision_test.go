@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mozilla-services/go-bouncer/bouncer"
+	"github.com/stretchr/testify/assert"
+)
+
+// schemeMirrorsCatalog is a catalog with fixed mirrors, listed by the scheme
+// of their base url like the database does
+type schemeMirrorsCatalog struct {
+	Catalog
+	mirrors []bouncer.MirrorsResult
+}
+
+func (s *schemeMirrorsCatalog) Mirrors(sslOnly bool) ([]bouncer.MirrorsResult, error) {
+	prefix := "http://"
+	if sslOnly {
+		prefix = "https://"
+	}
+	var mirrors []bouncer.MirrorsResult
+	for _, m := range s.mirrors {
+		if strings.HasPrefix(m.BaseURL, prefix) {
+			mirrors = append(mirrors, m)
+		}
+	}
+	return mirrors, nil
+}
+
+var decisionMirrors = []bouncer.MirrorsResult{
+	{ID: "1", BaseURL: "https://a.example.com", Rating: 100},
+	{ID: "2", BaseURL: "https://b.example.com", Rating: 0},
+	{ID: "3", BaseURL: "https://c.example.com", Rating: 50},
+	{ID: "4", BaseURL: "http://d.example.com", Rating: 1000},
+}
+
+func mirrorDecision(t *testing.T, w *httptest.ResponseRecorder) *MirrorDecision {
+	decision := &MirrorDecision{}
+	assert.NoError(t, json.Unmarshal([]byte(w.HeaderMap.Get(MirrorDecisionHeader)), decision))
+	return decision
+}
+
+func TestBouncerHandlerMirrorDecision(t *testing.T) {
+	handler := &BouncerHandler{
+		db:         &schemeMirrorsCatalog{bouncerHandler.db, decisionMirrors},
+		CacheTime:  time.Minute,
+		DebugToken: "secret",
+	}
+
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-ssl&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 302, w.Code)
+	assert.Equal(t, "no-store", w.HeaderMap.Get("Cache-Control"))
+
+	decision := mirrorDecision(t, w)
+	assert.True(t, decision.SSLOnly)
+	assert.Equal(t, StrategyWeighted, decision.Strategy)
+	assert.True(t, decision.Roll >= 1 && decision.Roll <= 150, "roll: %v", decision.Roll)
+	assert.Equal(t, []MirrorCandidate{
+		{ID: "1", BaseURL: "https://a.example.com", Rating: 100},
+		{ID: "2", BaseURL: "https://b.example.com", Rating: 0, Excluded: ExcludedZeroRating},
+		{ID: "3", BaseURL: "https://c.example.com", Rating: 50},
+		{ID: "4", BaseURL: "http://d.example.com", Rating: 1000, Excluded: ExcludedScheme},
+	}, decision.Candidates)
+
+	chosen := "1"
+	if decision.Roll > 100 {
+		chosen = "3"
+	}
+	assert.Equal(t, chosen, decision.Chosen)
+	assert.True(t, strings.HasPrefix(w.HeaderMap.Get("Location"), decision.BaseURL+"/"), "location: %v", w.HeaderMap.Get("Location"))
+
+	// other requests don't get the decision
+	for _, token := range []string{"", "Bearer wrong"} {
+		req.Header.Set("Authorization", token)
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, "", w.HeaderMap.Get(MirrorDecisionHeader), "authorization: %v", token)
+		assert.Equal(t, "max-age=60", w.HeaderMap.Get("Cache-Control"), "authorization: %v", token)
+	}
+}
+
+func TestBouncerHandlerMirrorDecisionLatency(t *testing.T) {
+	latencies := NewMirrorLatencies()
+	latencies.Observe("1", 300*time.Millisecond)
+	latencies.Observe("3", 20*time.Millisecond)
+	handler := &BouncerHandler{
+		db:              &schemeMirrorsCatalog{bouncerHandler.db, append(decisionMirrors, bouncer.MirrorsResult{ID: "5", BaseURL: "https://e.example.com", Rating: 100})},
+		MirrorLatencies: latencies,
+		DebugToken:      "secret",
+	}
+
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-ssl&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, "https://c.example.com/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", w.HeaderMap.Get("Location"))
+
+	decision := mirrorDecision(t, w)
+	assert.Equal(t, StrategyLatency, decision.Strategy)
+	assert.Equal(t, 0, decision.Roll)
+	assert.Equal(t, "3", decision.Chosen)
+
+	excluded := map[string]string{}
+	for _, c := range decision.Candidates {
+		excluded[c.ID] = c.Excluded
+	}
+	assert.Equal(t, map[string]string{
+		"1": "",
+		"2": ExcludedZeroRating,
+		"3": "",
+		"4": ExcludedScheme,
+		"5": ExcludedNoLatency,
+	}, excluded)
+}
+
+func TestBouncerHandlerMirrorDecisionPinned(t *testing.T) {
+	handler := &BouncerHandler{
+		db:                 bouncerHandler.db,
+		PinnedBaseURLHttps: "pinned.example.com",
+		DebugToken:         "secret",
+	}
+
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-ssl&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	decision := mirrorDecision(t, w)
+	assert.Equal(t, StrategyPinned, decision.Strategy)
+	assert.Equal(t, "https://pinned.example.com", decision.BaseURL)
+	assert.Empty(t, decision.Candidates)
+}
+
+func TestBouncerHandlerMirrorDecisionLog(t *testing.T) {
+	buf := &bytes.Buffer{}
+	handler := &BouncerHandler{
+		db:                &schemeMirrorsCatalog{bouncerHandler.db, decisionMirrors},
+		CacheTime:         time.Minute,
+		MirrorDecisionLog: buf,
+	}
+
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, "http://d.example.com/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", w.HeaderMap.Get("Location"))
+	// logged decisions aren't sent to clients
+	assert.Equal(t, "", w.HeaderMap.Get(MirrorDecisionHeader))
+	assert.Equal(t, "max-age=60", w.HeaderMap.Get("Cache-Control"))
+
+	entry := struct {
+		Type   string
+		Fields struct {
+			Product  string
+			Decision MirrorDecision
+		}
+	}{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "mirror.decision", entry.Type)
+	assert.Equal(t, "firefox-latest", entry.Fields.Product)
+	assert.False(t, entry.Fields.Decision.SSLOnly)
+	assert.Equal(t, "4", entry.Fields.Decision.Chosen)
+	assert.True(t, entry.Fields.Decision.Roll >= 1 && entry.Fields.Decision.Roll <= 1000, "roll: %v", entry.Fields.Decision.Roll)
+	if assert.Len(t, entry.Fields.Decision.Candidates, 4) {
+		assert.Equal(t, ExcludedScheme, entry.Fields.Decision.Candidates[1].Excluded)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/mozilla-services/go-bouncer/bouncer"
+)
+
+// requestHost returns the host req was sent to: the TLS server name, if
+// the client sent one, or the Host header without its port
+func requestHost(req *http.Request) string {
+	if req.TLS != nil && req.TLS.ServerName != "" {
+		return strings.ToLower(req.TLS.ServerName)
+	}
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+// withMirrorPool returns a copy of b picking mirrors from the MirrorPools
+// pool of the host req was sent to. b is returned if the host has no pool.
+func (b *BouncerHandler) withMirrorPool(req *http.Request) *BouncerHandler {
+	pool, ok := b.MirrorPools[requestHost(req)]
+	if !ok {
+		return b
+	}
+	pb := *b
+	pb.mirrorPool = pool
+	return &pb
+}
+
+// withMirrorRegion returns a copy of b picking mirrors from the
+// MirrorRegions pool of region. If region is unknown, ok is false and b is
+// returned.
+func (b *BouncerHandler) withMirrorRegion(region string) (*BouncerHandler, bool) {
+	pool, ok := b.MirrorRegions[region]
+	if !ok {
+		b.incr("mirror.unknown_region")
+		return b, false
+	}
+	pb := *b
+	pb.mirrorPool = pool
+	return &pb, true
+}
+
+// poolMirrors splits mirrors into those of the request's mirror pool and
+// the others. If the request has no pool, or none of its mirrors is
+// available, every mirror is in the pool.
+func (b *BouncerHandler) poolMirrors(mirrors []bouncer.MirrorsResult) (pool, others []bouncer.MirrorsResult) {
+	if b.mirrorPool == nil {
+		return mirrors, nil
+	}
+
+	hosts := make(map[string]bool, len(b.mirrorPool))
+	for _, host := range b.mirrorPool {
+		hosts[strings.ToLower(host)] = true
+	}
+	for _, m := range mirrors {
+		if u, err := url.Parse(m.BaseURL); err == nil && hosts[strings.ToLower(u.Host)] {
+			pool = append(pool, m)
+		} else {
+			others = append(others, m)
+		}
+	}
+
+	if len(pool) == 0 && len(mirrors) > 0 {
+		b.incr("mirror.pool_fallback")
+		return mirrors, nil
+	}
+	return pool, others
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mozilla-services/go-bouncer/bouncer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestHost(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://Download.Example.com:8080/?product=firefox-latest", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "download.example.com", requestHost(req))
+
+	req.TLS = &tls.ConnectionState{ServerName: "eu.download.example.com"}
+	assert.Equal(t, "eu.download.example.com", requestHost(req))
+}
+
+func TestBouncerHandlerMirrorPools(t *testing.T) {
+	const path = "/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"
+	handler := &BouncerHandler{
+		db: &schemeMirrorsCatalog{memoizedCatalog(), []bouncer.MirrorsResult{
+			{ID: "1", BaseURL: "http://eu1.cdn.example.com", Rating: 100},
+			{ID: "2", BaseURL: "http://us1.cdn.example.com", Rating: 100},
+			{ID: "3", BaseURL: "https://us1.cdn.example.com", Rating: 100},
+		}},
+		MirrorPools: map[string][]string{
+			"eu.download.example.com":   {"eu1.cdn.example.com"},
+			"us.download.example.com":   {"US1.cdn.example.com"},
+			"gone.download.example.com": {"gone.cdn.example.com"},
+		},
+	}
+
+	testRequests := []struct {
+		Host              string
+		ServerName        string
+		ExpectedLocations []string
+	}{
+		{"eu.download.example.com", "", []string{"http://eu1.cdn.example.com" + path}},
+		{"us.download.example.com:443", "", []string{"http://us1.cdn.example.com" + path}},
+		// the TLS server name takes precedence over the Host header
+		{"us.download.example.com", "eu.download.example.com", []string{"http://eu1.cdn.example.com" + path}},
+		// other hosts, and pools without an available mirror, get every mirror
+		{"download.example.com", "", []string{"http://eu1.cdn.example.com" + path, "http://us1.cdn.example.com" + path}},
+		{"gone.download.example.com", "", []string{"http://eu1.cdn.example.com" + path, "http://us1.cdn.example.com" + path}},
+	}
+
+	for _, testRequest := range testRequests {
+		req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US", nil)
+		assert.NoError(t, err)
+		req.Host = testRequest.Host
+		if testRequest.ServerName != "" {
+			req.TLS = &tls.ConnectionState{ServerName: testRequest.ServerName}
+		}
+
+		for i := 0; i < 3; i++ {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Contains(t, testRequest.ExpectedLocations, w.HeaderMap.Get("Location"), "host: %v server name: %v", testRequest.Host, testRequest.ServerName)
+		}
+	}
+
+	// pinned base urls take precedence over pools
+	pinned := *handler
+	pinned.PinnedBaseURLHttp = "pinned.example.com"
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+	req.Host = "eu.download.example.com"
+	w := httptest.NewRecorder()
+	pinned.ServeHTTP(w, req)
+	assert.Equal(t, "http://pinned.example.com"+path, w.HeaderMap.Get("Location"))
+}
+
+func TestBouncerHandlerMirrorPoolDecision(t *testing.T) {
+	handler := &BouncerHandler{
+		db:          &schemeMirrorsCatalog{bouncerHandler.db, decisionMirrors},
+		MirrorPools: map[string][]string{"tenant.example.com": {"c.example.com"}},
+		DebugToken:  "secret",
+	}
+
+	req, err := http.NewRequest("GET", "http://tenant.example.com/?product=firefox-ssl&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, "https://c.example.com/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", w.HeaderMap.Get("Location"))
+
+	decision := mirrorDecision(t, w)
+	assert.Equal(t, "3", decision.Chosen)
+	excluded := map[string]string{}
+	for _, c := range decision.Candidates {
+		excluded[c.ID] = c.Excluded
+	}
+	assert.Equal(t, map[string]string{
+		"1": ExcludedPool,
+		"2": ExcludedPool,
+		"3": "",
+		"4": ExcludedScheme,
+	}, excluded)
+}
+
+func TestBouncerHandlerMirrorRegions(t *testing.T) {
+	const path = "/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"
+	metrics := &recordingMetrics{}
+	handler := &BouncerHandler{
+		db: &schemeMirrorsCatalog{memoizedCatalog(), []bouncer.MirrorsResult{
+			{ID: "1", BaseURL: "http://eu1.cdn.example.com", Rating: 100},
+			{ID: "2", BaseURL: "http://us1.cdn.example.com", Rating: 100},
+		}},
+		MirrorPools:   map[string][]string{"us.download.example.com": {"us1.cdn.example.com"}},
+		MirrorRegions: map[string][]string{"eu": {"eu1.cdn.example.com"}},
+		Metrics:       metrics,
+	}
+	every := []string{"http://eu1.cdn.example.com" + path, "http://us1.cdn.example.com" + path}
+
+	testRequests := []struct {
+		URL               string
+		ExpectedLocations []string
+	}{
+		{"http://test/?product=firefox-latest&os=osx&lang=en-US&region=eu", []string{"http://eu1.cdn.example.com" + path}},
+		{"http://test/?product=firefox-latest&os=osx&lang=en-US&region=EU", []string{"http://eu1.cdn.example.com" + path}},
+		// the region pool takes precedence over the host pool
+		{"http://us.download.example.com/?product=firefox-latest&os=osx&lang=en-US&region=eu", []string{"http://eu1.cdn.example.com" + path}},
+		// unknown regions are ignored
+		{"http://test/?product=firefox-latest&os=osx&lang=en-US&region=ue", every},
+		{"http://us.download.example.com/?product=firefox-latest&os=osx&lang=en-US&region=ue", []string{"http://us1.cdn.example.com" + path}},
+	}
+
+	for _, testRequest := range testRequests {
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+		for i := 0; i < 3; i++ {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, 302, w.Code, "url: %v", testRequest.URL)
+			assert.Contains(t, testRequest.ExpectedLocations, w.HeaderMap.Get("Location"), "url: %v", testRequest.URL)
+		}
+	}
+	assert.Equal(t, 6, metrics.counts["mirror.unknown_region"])
+
+	// in strict mode, unknown regions get a 400
+	handler.StrictRegions = true
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US&region=ue", nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "Unknown region.")
+
+	req, err = http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US&region=eu", nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, "http://eu1.cdn.example.com"+path, w.HeaderMap.Get("Location"))
+
+	// requests without a region are unaffected
+	req, err = http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 302, w.Code)
+}
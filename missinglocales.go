@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MissingLocalesPath serves the report of the missing locales
+const MissingLocalesPath = "/missing-locales"
+
+// DefaultMissingLocalesSize is the default number of product, os and lang
+// combinations tracked
+const DefaultMissingLocalesSize = 10000
+
+// DefaultMissingLocalesTop is the default number of langs listed per
+// product in the report
+const DefaultMissingLocalesTop = 10
+
+// missingLocale is a product, os and lang combination that was requested
+// but isn't available
+type missingLocale struct {
+	Product string
+	OS      string
+	Lang    string
+}
+
+// MissingLocales counts requests for langs products aren't available in.
+// Langs are client input, so at most size combinations are tracked; misses
+// of new combinations are dropped once it is full.
+type MissingLocales struct {
+	mu     sync.Mutex
+	size   int
+	misses map[missingLocale]int
+}
+
+// NewMissingLocales returns a tracker of at most size combinations
+func NewMissingLocales(size int) *MissingLocales {
+	return &MissingLocales{size: size, misses: map[missingLocale]int{}}
+}
+
+// Add counts a request for product on os in lang, which isn't available
+func (m *MissingLocales) Add(product, os, lang string) {
+	key := missingLocale{strings.ToLower(product), strings.ToLower(os), lang}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.misses[key]; !ok && len(m.misses) >= m.size {
+		return
+	}
+	m.misses[key]++
+}
+
+// MissingLang is a lang a product was requested in, with its misses by os
+type MissingLang struct {
+	Lang   string         `json:"lang"`
+	Misses int            `json:"misses"`
+	OS     map[string]int `json:"os"`
+}
+
+// MissingProduct is a product with the langs it was most requested in
+// without being available
+type MissingProduct struct {
+	Product string        `json:"product"`
+	Misses  int           `json:"misses"`
+	Langs   []MissingLang `json:"langs"`
+}
+
+// Report groups the misses by product, most missed first, listing the top
+// most missed langs of each
+func (m *MissingLocales) Report(top int) []MissingProduct {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	langs := map[string]map[string]*MissingLang{}
+	for key, n := range m.misses {
+		if langs[key.Product] == nil {
+			langs[key.Product] = map[string]*MissingLang{}
+		}
+		lang := langs[key.Product][key.Lang]
+		if lang == nil {
+			lang = &MissingLang{Lang: key.Lang, OS: map[string]int{}}
+			langs[key.Product][key.Lang] = lang
+		}
+		lang.Misses += n
+		lang.OS[key.OS] += n
+	}
+
+	report := make([]MissingProduct, 0, len(langs))
+	for product, productLangs := range langs {
+		p := MissingProduct{Product: product, Langs: make([]MissingLang, 0, len(productLangs))}
+		for _, lang := range productLangs {
+			p.Misses += lang.Misses
+			p.Langs = append(p.Langs, *lang)
+		}
+		sort.Slice(p.Langs, func(i, j int) bool {
+			if p.Langs[i].Misses != p.Langs[j].Misses {
+				return p.Langs[i].Misses > p.Langs[j].Misses
+			}
+			return p.Langs[i].Lang < p.Langs[j].Lang
+		})
+		if top > 0 && len(p.Langs) > top {
+			p.Langs = p.Langs[:top]
+		}
+		report = append(report, p)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Misses != report[j].Misses {
+			return report[i].Misses > report[j].Misses
+		}
+		return report[i].Product < report[j].Product
+	})
+	return report
+}
+
+// MissingLocalesHandler serves the missing locales report as json to
+// requests with an "Authorization: Bearer <Token>" header. The top param
+// overrides Top, the number of langs listed per product.
+type MissingLocalesHandler struct {
+	Misses *MissingLocales
+	Token  string
+	Top    int
+}
+
+func (h *MissingLocalesHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if h.Token == "" || h.Misses == nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	token := req.Header.Get("Authorization")
+	if subtle.ConstantTimeCompare([]byte(token), []byte("Bearer "+h.Token)) != 1 {
+		http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+		return
+	}
+
+	top := h.Top
+	if top <= 0 {
+		top = DefaultMissingLocalesTop
+	}
+	if v := req.URL.Query().Get("top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid top.", http.StatusBadRequest)
+			return
+		}
+		top = n
+	}
+
+	res, err := json.Marshal(struct {
+		Products []MissingProduct `json:"products"`
+	}{h.Misses.Report(top)})
+	if err != nil {
+		log.Printf("MissingLocalesHandler err: %v", err)
+		http.Error(w, "Internal Server Error.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
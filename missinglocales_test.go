@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMissingLocalesReport(t *testing.T) {
+	misses := NewMissingLocales(100)
+	for i := 0; i < 3; i++ {
+		misses.Add("firefox-latest", "win", "es-AR")
+	}
+	misses.Add("Firefox-Latest", "OSX", "es-AR")
+	misses.Add("firefox-latest", "win", "ast")
+	misses.Add("firefox-latest", "osx", "ast")
+	misses.Add("firefox-latest", "win", "ga-IE")
+	misses.Add("thunderbird-latest", "win", "es-AR")
+
+	assert.Equal(t, []MissingProduct{
+		{Product: "firefox-latest", Misses: 7, Langs: []MissingLang{
+			{Lang: "es-AR", Misses: 4, OS: map[string]int{"win": 3, "osx": 1}},
+			{Lang: "ast", Misses: 2, OS: map[string]int{"win": 1, "osx": 1}},
+			{Lang: "ga-IE", Misses: 1, OS: map[string]int{"win": 1}},
+		}},
+		{Product: "thunderbird-latest", Misses: 1, Langs: []MissingLang{
+			{Lang: "es-AR", Misses: 1, OS: map[string]int{"win": 1}},
+		}},
+	}, misses.Report(0))
+
+	// only the top langs are listed, products still count every miss
+	report := misses.Report(2)
+	assert.Equal(t, 7, report[0].Misses)
+	assert.Len(t, report[0].Langs, 2)
+}
+
+func TestMissingLocalesSize(t *testing.T) {
+	misses := NewMissingLocales(2)
+	misses.Add("firefox-latest", "win", "es-AR")
+	misses.Add("firefox-latest", "win", "ast")
+	misses.Add("firefox-latest", "win", "ga-IE")
+	misses.Add("firefox-latest", "win", "es-AR")
+
+	assert.Equal(t, []MissingProduct{
+		{Product: "firefox-latest", Misses: 3, Langs: []MissingLang{
+			{Lang: "es-AR", Misses: 2, OS: map[string]int{"win": 2}},
+			{Lang: "ast", Misses: 1, OS: map[string]int{"win": 1}},
+		}},
+	}, misses.Report(0))
+}
+
+func TestBouncerHandlerMissingLocales(t *testing.T) {
+	misses := NewMissingLocales(100)
+	handler := &BouncerHandler{db: bouncerHandler.db, MissingLocales: misses}
+	reportHandler := &MissingLocalesHandler{Misses: misses, Token: "secret"}
+
+	for _, url := range []string{
+		"http://test/?product=firefox-latest&os=osx&lang=es-AR",
+		"http://test/?product=firefox-latest&os=win&lang=es-AR",
+		"http://test/?product=firefox-latest&os=osx&lang=ast",
+		// available langs, unknown products and oses aren't missing locales
+		"http://test/?product=firefox-latest&os=osx&lang=en-US",
+		"http://test/?product=firefox-unknown&os=osx&lang=es-AR",
+		"http://test/?product=firefox-latest&os=beos&lang=es-AR",
+	} {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", url, nil)
+		assert.NoError(t, err, "url: %v", url)
+		handler.ServeHTTP(w, req)
+	}
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/missing-locales?top=1", nil)
+	assert.NoError(t, err)
+	reportHandler.ServeHTTP(w, req)
+	assert.Equal(t, 401, w.Code)
+
+	w = httptest.NewRecorder()
+	req.Header.Set("Authorization", "Bearer secret")
+	reportHandler.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "no-store", w.HeaderMap.Get("Cache-Control"))
+
+	report := struct {
+		Products []MissingProduct `json:"products"`
+	}{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, []MissingProduct{
+		{Product: "firefox-latest", Misses: 3, Langs: []MissingLang{
+			{Lang: "es-AR", Misses: 2, OS: map[string]int{"osx": 1, "win": 1}},
+		}},
+	}, report.Products)
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "http://test/missing-locales?top=none", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	reportHandler.ServeHTTP(w, req)
+	assert.Equal(t, 400, w.Code)
+}
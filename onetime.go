@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RedeemPath is the path one-time urls are redeemed at
+const RedeemPath = "/redeem"
+
+// DefaultOneTimeTTL is how long a one-time url can be redeemed if the
+// handler doesn't set OneTimeTTL
+const DefaultOneTimeTTL = 5 * time.Minute
+
+// TokenStore stores the urls of one-time tokens until they are redeemed
+type TokenStore interface {
+	// Put stores the url of a token for ttl
+	Put(token, url string, ttl time.Duration) error
+	// Redeem returns the url of a token and removes it. ok is false if the
+	// token is unknown, expired or was already redeemed.
+	Redeem(token string) (url string, ok bool, err error)
+}
+
+// memoryTokenSweepPuts is how many tokens a MemoryTokenStore stores
+// between drops of its expired tokens
+const memoryTokenSweepPuts = 1000
+
+type memoryToken struct {
+	url     string
+	expires time.Time
+}
+
+// MemoryTokenStore is an in-memory TokenStore. Tokens don't survive
+// restarts and aren't shared between bouncer instances. It is safe for
+// concurrent use.
+type MemoryTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]memoryToken
+	puts   int
+}
+
+// NewMemoryTokenStore returns an empty MemoryTokenStore
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{tokens: make(map[string]memoryToken)}
+}
+
+// Put stores the url of a token for ttl. Expired tokens are dropped every
+// memoryTokenSweepPuts puts, so puts don't scan every token.
+func (s *MemoryTokenStore) Put(token, url string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.puts++
+	if s.puts >= memoryTokenSweepPuts {
+		s.puts = 0
+		for t, v := range s.tokens {
+			if now.After(v.expires) {
+				delete(s.tokens, t)
+			}
+		}
+	}
+	s.tokens[token] = memoryToken{url: url, expires: now.Add(ttl)}
+	return nil
+}
+
+// Redeem returns the url of a token and removes it
+func (s *MemoryTokenStore) Redeem(token string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.tokens[token]
+	if !ok {
+		return "", false, nil
+	}
+	delete(s.tokens, token)
+	if time.Now().After(v.expires) {
+		return "", false, nil
+	}
+	return v.url, true, nil
+}
+
+// newOneTimeToken returns a random token
+func newOneTimeToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// isOneTime returns true if product, or the catalog product it resolves
+// to, is served with one-time urls
+func (b *BouncerHandler) isOneTime(product string) bool {
+	if b.OneTimeTokens == nil {
+		return false
+	}
+	if oneTimeProduct(b.OneTimeProducts, product) {
+		return true
+	}
+	catalogProduct, err := b.catalogProduct(product)
+	return err == nil && oneTimeProduct(b.OneTimeProducts, catalogProduct)
+}
+
+// oneTimeProduct returns true if product, or its family, is in products
+func oneTimeProduct(products []string, product string) bool {
+	family := strings.SplitN(product, "-", 2)[0]
+	for _, p := range products {
+		if strings.EqualFold(p, product) || strings.EqualFold(p, family) {
+			return true
+		}
+	}
+	return false
+}
+
+// oneTimeURL stores resolvedURL under a new token and returns the url
+// redeeming it
+func (b *BouncerHandler) oneTimeURL(resolvedURL string) (string, error) {
+	token, err := newOneTimeToken()
+	if err != nil {
+		return "", err
+	}
+	ttl := b.OneTimeTTL
+	if ttl == 0 {
+		ttl = DefaultOneTimeTTL
+	}
+	if err := b.OneTimeTokens.Put(token, resolvedURL, ttl); err != nil {
+		return "", err
+	}
+	return RedeemPath + "?" + url.Values{"token": []string{token}}.Encode(), nil
+}
+
+// RedeemHandler redirects a one-time token to its url, once. Later
+// requests for the token get a 410 Gone.
+type RedeemHandler struct {
+	Tokens TokenStore
+	// Bouncer is the handler which issued the tokens, whose redirects
+	// the redeemed ones match
+	Bouncer *BouncerHandler
+}
+
+func (h *RedeemHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	token := req.URL.Query().Get("token")
+	if token == "" {
+		errorResponse(w, req, http.StatusBadRequest, ErrorCodeBadRequest, "token is required.")
+		return
+	}
+
+	resolvedURL, ok, err := h.Tokens.Redeem(token)
+	if err != nil {
+		log.Printf("RedeemHandler err: %v", err)
+		errorResponse(w, req, http.StatusInternalServerError, ErrorCodeInternal, "Internal Server Error.")
+		return
+	}
+	if !ok {
+		errorResponse(w, req, http.StatusGone, ErrorCodeTokenRedeemed, "Gone.")
+		return
+	}
+	h.Bouncer.redirect(w, req, resolvedURL, http.StatusFound)
+}
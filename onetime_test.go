@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryTokenStore(t *testing.T) {
+	store := NewMemoryTokenStore()
+	assert.NoError(t, store.Put("token", "https://mirror/file", time.Minute))
+
+	url, ok, err := store.Redeem("token")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "https://mirror/file", url)
+
+	_, ok, err = store.Redeem("token")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.NoError(t, store.Put("expired", "https://mirror/file", -time.Second))
+	_, ok, err = store.Redeem("expired")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestMemoryTokenStoreSweep(t *testing.T) {
+	store := NewMemoryTokenStore()
+	for i := 0; i < memoryTokenSweepPuts-1; i++ {
+		assert.NoError(t, store.Put(fmt.Sprintf("expired%d", i), "https://mirror/file", -time.Second))
+	}
+	assert.Len(t, store.tokens, memoryTokenSweepPuts-1)
+
+	// expired tokens are dropped every memoryTokenSweepPuts puts
+	assert.NoError(t, store.Put("token", "https://mirror/file", time.Minute))
+	assert.Len(t, store.tokens, 1)
+}
+
+func TestBouncerHandlerOneTimeURL(t *testing.T) {
+	tokens := NewMemoryTokenStore()
+	handler := &BouncerHandler{
+		db:              bouncerHandler.db,
+		OneTimeProducts: []string{"firefox-latest"},
+		OneTimeTokens:   tokens,
+	}
+	redeem := &RedeemHandler{Tokens: tokens, Bouncer: handler}
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 302, w.Code)
+	assert.Equal(t, "no-store", w.HeaderMap.Get("Cache-Control"))
+	location := w.HeaderMap.Get("Location")
+	assert.True(t, strings.HasPrefix(location, "/redeem?token="), "location: %v", location)
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "http://test"+location, nil)
+	assert.NoError(t, err)
+	redeem.ServeHTTP(w, req)
+	assert.Equal(t, 302, w.Code)
+	assert.Equal(t, "no-store", w.HeaderMap.Get("Cache-Control"))
+	assert.Equal(t, DefaultContentSecurityPolicy, w.HeaderMap.Get("Content-Security-Policy"))
+	assert.Equal(t, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", w.HeaderMap.Get("Location"))
+
+	// A token can only be redeemed once
+	w = httptest.NewRecorder()
+	redeem.ServeHTTP(w, req)
+	assert.Equal(t, 410, w.Code)
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "http://test/redeem", nil)
+	assert.NoError(t, err)
+	redeem.ServeHTTP(w, req)
+	assert.Equal(t, 400, w.Code)
+
+	// Other products are served as usual
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "http://test/?product=firefox-sha1&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 302, w.Code)
+	assert.Equal(t, "https://download-installer.cdn.mozilla.net/pub/firefox/releases/43.0.1/mac/en-US/Firefox%2043.0.1.dmg", w.HeaderMap.Get("Location"))
+}
+
+func TestBouncerHandlerOneTimeCatalogProduct(t *testing.T) {
+	handler := &BouncerHandler{
+		db:              bouncerHandler.db,
+		OneTimeProducts: []string{"Firefox-43.0.1-SSL"},
+		OneTimeTokens:   NewMemoryTokenStore(),
+		Bundles: map[string][]string{
+			"firefox-suite": {"firefox-sha1", "firefox-latest"},
+		},
+	}
+
+	// aliases of a one-time product are served one-time, including with
+	// immutable=yes
+	for _, query := range []string{"product=firefox-sha1", "product=firefox-sha1&immutable=yes"} {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://test/?os=osx&lang=en-US&"+query, nil)
+		assert.NoError(t, err)
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, 302, w.Code, "query: %v", query)
+		location := w.HeaderMap.Get("Location")
+		assert.True(t, strings.HasPrefix(location, "/redeem?token="), "query: %v location: %v", query, location)
+	}
+
+	// so are bundled ones
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-suite&os=osx&lang=en-US&format=json", nil)
+	assert.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "no-store", w.HeaderMap.Get("Cache-Control"))
+	var bundle struct {
+		Products []bundleProduct `json:"products"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &bundle))
+	if assert.Len(t, bundle.Products, 2) {
+		assert.True(t, strings.HasPrefix(bundle.Products[0].URL, "/redeem?token="), "url: %v", bundle.Products[0].URL)
+		assert.Equal(t, "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg", bundle.Products[1].URL)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+)
+
+// DefaultOSVersionNames maps the names of the versioned oses some clients
+// send, e.g. win for win10 or macos for macos13, to their catalog os
+var DefaultOSVersionNames = map[string]string{
+	"win":     "win",
+	"windows": "win",
+	"osx":     "osx",
+	"mac":     "osx",
+	"macos":   "osx",
+}
+
+// versionedOSRegex matches an os name followed by its version, e.g. win10,
+// macos13 or macos-13.4
+var versionedOSRegex = regexp.MustCompile(`^([a-z]+)[-_]?[0-9]+(\.[0-9]+)*$`)
+
+// versionedOS returns the catalog os of an os qualified with its version,
+// e.g. osx for macos13. Windows versions are win, or win64 for clients
+// whose User-Agent is 64-bit and that don't ask for arch=x86. Catalog oses,
+// e.g. win64, and oses without a version are returned as is.
+func (b *BouncerHandler) versionedOS(req *http.Request, reqParams *BouncerParams) string {
+	os := reqParams.OS
+	match := versionedOSRegex.FindStringSubmatch(os)
+	if match == nil || archOSBases[os] {
+		return os
+	}
+	names := b.OSVersionNames
+	if names == nil {
+		names = DefaultOSVersionNames
+	}
+	catalogOS, ok := names[match[1]]
+	if !ok {
+		return os
+	}
+	if _, err := b.db.OSID(os); err == nil {
+		return os
+	}
+
+	if catalogOS == "win" && reqParams.Arch != Arch32Token && osFromUserAgent(req.UserAgent()) == "win64" {
+		return "win64"
+	}
+	return catalogOS
+}
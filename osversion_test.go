@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBouncerHandlerVersionedOS(t *testing.T) {
+	const (
+		win64UA = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:68.0) Gecko/20100101 Firefox/68.0"
+		win32UA = "Mozilla/5.0 (Windows NT 10.0; rv:68.0) Gecko/20100101 Firefox/68.0"
+		win32   = "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win32/en-US/Firefox%20Setup%2039.0.exe"
+		win64   = "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win64/en-US/Firefox%20Setup%2039.0.exe"
+		mac     = "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"
+	)
+
+	testRequests := []struct {
+		Names            map[string]string
+		URL              string
+		UserAgent        string
+		ExpectedLocation string
+	}{
+		{nil, "http://test/?product=firefox-latest&os=win10&lang=en-US", win32UA, win32},
+		{nil, "http://test/?product=firefox-latest&os=win10&lang=en-US", win64UA, win64},
+		{nil, "http://test/?product=firefox-latest&os=win11&lang=en-US", win64UA, win64},
+		{nil, "http://test/?product=firefox-latest&os=win11&lang=en-US&arch=x86", win64UA, win32},
+		{nil, "http://test/?product=firefox-latest&os=windows-11&lang=en-US", "", win32},
+		{nil, "http://test/?product=firefox-latest&os=macos13&lang=en-US", "", mac},
+		{nil, "http://test/?product=firefox-latest&os=macos-13.4&lang=en-US", "", mac},
+		// versions aren't taken for architectures
+		{nil, "http://test/?product=firefox-latest&os=osx-13&lang=en-US", "", mac},
+		{nil, "http://test/?product=firefox-latest&os=mac-13&lang=en-US", "", mac},
+		{nil, "http://test/?product=firefox-latest&os=win-10&lang=en-US", win64UA, win64},
+		{nil, "http://test/?product=firefox-latest&os=win-10&lang=en-US", "", win32},
+		{nil, "http://test/?product=firefox-latest&os=osx&lang=en-US", "", mac},
+		// catalog oses keep their digits
+		{nil, "http://test/?product=firefox-latest&os=win64&lang=en-US", win32UA, win64},
+		// unknown names aren't normalized
+		{nil, "http://test/?product=firefox-latest&os=beos5&lang=en-US", "", ""},
+		// the table replaces the default names
+		{map[string]string{"beos": "osx"}, "http://test/?product=firefox-latest&os=beos5&lang=en-US", "", mac},
+		{map[string]string{"beos": "osx"}, "http://test/?product=firefox-latest&os=macos13&lang=en-US", "", ""},
+	}
+
+	catalog := memoizedCatalog()
+	for _, testRequest := range testRequests {
+		handler := &BouncerHandler{
+			db:             catalog,
+			OSVersionNames: testRequest.Names,
+		}
+
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+		req.Header.Set("User-Agent", testRequest.UserAgent)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v ua: %v", testRequest.URL, testRequest.UserAgent)
+	}
+}
@@ -1,28 +1,171 @@
 package main
 
 import (
+	"encoding/base64"
+	"errors"
 	"net/url"
+	"regexp"
 	"strings"
 )
 
+// SpecParam is the query param of a compact product spec
+const SpecParam = "spec"
+
+// MaxSpecLength is the longest spec param accepted
+const MaxSpecLength = 256
+
+// matches the product, os and lang of a spec
+var specProductRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+var specOSRegex = regexp.MustCompile(`^[a-z0-9_-]*$`)
+var specLangRegex = regexp.MustCompile(`^[A-Za-z0-9-]*$`)
+
 // BouncerParams holds/parses params for incoming bouncer requests
 type BouncerParams struct {
 	PrintOnly       bool
+	View            string
+	Format          string
 	OS              string
 	Product         string
 	Lang            string
 	AttributionCode string
 	AttributionSig  string
+	ShortCode       string
+	// Scheme is "http", "https" or "" if not set or invalid
+	Scheme string
+	// Arch is the architecture the client explicitly asks for, e.g. x86
+	Arch string
+	// NoCache asks for a url bypassing intermediate caches
+	NoCache bool
+	// Version is the version of the product the client runs, e.g. 68.4.1
+	Version string
+	// EULAToken is the client's EULA acceptance token
+	EULAToken string
+	// Spec is the compact product spec of the request, see parseSpec
+	Spec string
+	// Checksum is the checksum type format=json responses list, see
+	// checksumType
+	Checksum string
+	// Region is the mirror region the client asks to be served from, see
+	// MirrorRegions
+	Region string
+	// Immutable asks for the bouncer url of the catalog product an alias
+	// resolves to, see immutableURL
+	Immutable bool
+}
+
+// ResolveTuple is the product, os and lang a short code expands to
+type ResolveTuple struct {
+	Product string
+	OS      string
+	Lang    string
+}
+
+// applyShortCode fills the product, os and lang not set explicitly from t
+func (p *BouncerParams) applyShortCode(t ResolveTuple) {
+	if p.Product == "" {
+		p.Product = strings.TrimSpace(strings.ToLower(t.Product))
+	}
+	if p.OS == "" {
+		p.OS = strings.TrimSpace(strings.ToLower(t.OS))
+	}
+	if p.Lang == "" {
+		p.Lang = t.Lang
+	}
+}
+
+// cleanSegments trims the trailing dots of a dash separated os or lang and
+// drops its empty segments, e.g. en--US. becomes en-US
+func cleanSegments(s string) string {
+	parts := strings.Split(strings.TrimRight(s, "."), "-")
+	kept := parts[:0]
+	for _, part := range parts {
+		if part != "" {
+			kept = append(kept, part)
+		}
+	}
+	return strings.Join(kept, "-")
+}
+
+// cleanOSLang cleans the segments of the os and lang
+func (p *BouncerParams) cleanOSLang() {
+	p.OS = cleanSegments(p.OS)
+	p.Lang = cleanSegments(p.Lang)
+}
+
+// parseSpec decodes a compact product spec, the unpadded base64url of
+// "product|os|lang", e.g. ZmlyZWZveC1sYXRlc3R8d2luNjR8ZW4tVVM for
+// firefox-latest|win64|en-US. os and lang may be empty.
+func parseSpec(spec string) (ResolveTuple, error) {
+	if len(spec) > MaxSpecLength {
+		return ResolveTuple{}, errors.New("spec too long")
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(spec, "="))
+	if err != nil {
+		return ResolveTuple{}, err
+	}
+
+	fields := strings.Split(string(decoded), "|")
+	if len(fields) != 3 {
+		return ResolveTuple{}, errors.New("expected product|os|lang")
+	}
+	t := ResolveTuple{
+		Product: strings.ToLower(fields[0]),
+		OS:      strings.ToLower(fields[1]),
+		Lang:    fields[2],
+	}
+	if !specProductRegex.MatchString(t.Product) || !specOSRegex.MatchString(t.OS) || !specLangRegex.MatchString(t.Lang) {
+		return ResolveTuple{}, errors.New("invalid product, os or lang")
+	}
+	return t, nil
 }
 
 // BouncerParamsFromValues constructs parameter list from incoming request Values
 func BouncerParamsFromValues(vals url.Values) *BouncerParams {
 	return &BouncerParams{
 		PrintOnly:       vals.Get("print") == "yes",
+		View:            strings.TrimSpace(strings.ToLower(vals.Get("view"))),
+		Format:          strings.TrimSpace(strings.ToLower(vals.Get("format"))),
 		OS:              strings.TrimSpace(strings.ToLower(vals.Get("os"))),
-		Product:         strings.TrimSpace(strings.ToLower(vals.Get("product"))),
+		Product:         withChannel(strings.TrimSpace(strings.ToLower(vals.Get("product"))), strings.TrimSpace(strings.ToLower(vals.Get("channel")))),
 		Lang:            vals.Get("lang"),
 		AttributionCode: vals.Get("attribution_code"),
 		AttributionSig:  vals.Get("attribution_sig"),
+		ShortCode:       strings.TrimSpace(strings.ToLower(vals.Get("c"))),
+		Scheme:          schemeParam(vals.Get("scheme")),
+		Arch:            strings.TrimSpace(strings.ToLower(vals.Get("arch"))),
+		NoCache:         vals.Get("nocache") == "1",
+		Version:         strings.TrimSpace(vals.Get("version")),
+		EULAToken:       strings.TrimSpace(vals.Get(EULATokenParam)),
+		Spec:            strings.TrimSpace(vals.Get(SpecParam)),
+		Checksum:        vals.Get("checksum"),
+		Region:          strings.TrimSpace(strings.ToLower(vals.Get("region"))),
+		Immutable:       vals.Get("immutable") == "yes",
+	}
+}
+
+// schemeParam returns scheme if it's a valid scheme param, or ""
+func schemeParam(scheme string) string {
+	scheme = strings.TrimSpace(strings.ToLower(scheme))
+	if scheme != "http" && scheme != "https" {
+		return ""
+	}
+	return scheme
+}
+
+// withChannel adds a channel to a product name after its family, e.g.
+// firefox-latest and beta become firefox-beta-latest. Products that already
+// name a channel are returned unchanged, as are release channel products,
+// which have no channel in their names.
+func withChannel(product, channel string) string {
+	if product == "" || !productChannels[channel] || channel == "release" {
+		return product
+	}
+
+	parts := strings.Split(product, "-")
+	for _, part := range parts {
+		if productChannels[part] {
+			return product
+		}
 	}
+	return strings.Join(append([]string{parts[0], channel}, parts[1:]...), "-")
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBouncerParamsChannel(t *testing.T) {
+	tests := []struct {
+		Query   string
+		Product string
+	}{
+		{"product=firefox&channel=beta", "firefox-beta"},
+		{"product=firefox-latest&channel=beta", "firefox-beta-latest"},
+		{"product=Firefox-stub&channel=ESR", "firefox-esr-stub"},
+		{"product=firefox-latest&channel=release", "firefox-latest"},
+		{"product=firefox-latest", "firefox-latest"},
+		{"product=firefox-latest&channel=", "firefox-latest"},
+		{"product=firefox-latest&channel=unknown", "firefox-latest"},
+		{"channel=beta", ""},
+
+		// a channel in the product takes precedence over the channel param
+		{"product=firefox-beta-latest&channel=beta", "firefox-beta-latest"},
+		{"product=firefox-beta-latest&channel=esr", "firefox-beta-latest"},
+		{"product=firefox-esr-latest&channel=nightly", "firefox-esr-latest"},
+	}
+
+	for _, test := range tests {
+		vals, err := url.ParseQuery(test.Query)
+		assert.NoError(t, err)
+		assert.Equal(t, test.Product, BouncerParamsFromValues(vals).Product, "query: %v", test.Query)
+	}
+}
+
+func TestParseSpec(t *testing.T) {
+	spec, err := parseSpec("ZmlyZWZveC1sYXRlc3R8d2luNjR8ZW4tVVM")
+	assert.NoError(t, err)
+	assert.Equal(t, ResolveTuple{Product: "firefox-latest", OS: "win64", Lang: "en-US"}, spec)
+
+	for _, invalid := range []string{
+		"",
+		"not base64",
+		strings.Repeat("A", MaxSpecLength+1),
+		"ZmlyZWZveC1sYXRlc3R8b3N4",           // firefox-latest|osx
+		"fG9zeHxlbi1VUw",                     // |osx|en-US
+		"ZmlyZWZveC1sYXRlc3R8b3N4fGVuLVVTfA", // firefox-latest|osx|en-US|
+	} {
+		_, err := parseSpec(invalid)
+		assert.Error(t, err, "spec: %v", invalid)
+	}
+}
+
+func TestCleanSegments(t *testing.T) {
+	testValues := []struct {
+		Value    string
+		Expected string
+	}{
+		{"win.", "win"},
+		{"en-US.", "en-US"},
+		{"en-US...", "en-US"},
+		{"en--US", "en-US"},
+		{"-win64--aarch64-", "win64-aarch64"},
+		{".", ""},
+		{"", ""},
+		// legitimate values are unchanged
+		{"win64-aarch64", "win64-aarch64"},
+		{"ja-JP-mac", "ja-JP-mac"},
+		{"linux64-x86_64", "linux64-x86_64"},
+	}
+
+	for _, testValue := range testValues {
+		assert.Equal(t, testValue.Expected, cleanSegments(testValue.Value), "value: %v", testValue.Value)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// PartnerParam and PartnerSigParam select the catalog of a partner. The
+// signature is the hex encoded HMAC-SHA256 of the partner id with
+// PartnerKey.
+const (
+	PartnerParam    = "partner"
+	PartnerSigParam = "partner_sig"
+)
+
+func partnerMAC(key []byte, partner string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strings.ToLower(partner)))
+	return mac.Sum(nil)
+}
+
+// PartnerSignature returns the partner_sig of a partner id signed with key
+func PartnerSignature(key []byte, partner string) string {
+	return hex.EncodeToString(partnerMAC(key, partner))
+}
+
+// validPartnerSignature returns true if sig is the signature of partner
+func validPartnerSignature(key []byte, partner, sig string) bool {
+	actual, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(partnerMAC(key, partner), actual)
+}
+
+// partnerCatalog returns the catalog of the partner a request is signed
+// for, or nil if the request has no partner or an invalid signature
+func (b *BouncerHandler) partnerCatalog(req *http.Request) Catalog {
+	if len(b.PartnerKey) == 0 || len(b.Partners) == 0 {
+		return nil
+	}
+
+	query := req.URL.Query()
+	partner := strings.ToLower(query.Get(PartnerParam))
+	if partner == "" {
+		return nil
+	}
+	catalog, ok := b.Partners[partner]
+	if !ok || !validPartnerSignature(b.PartnerKey, partner, query.Get(PartnerSigParam)) {
+		return nil
+	}
+	return catalog
+}
+
+// withPartnerCatalog returns a copy of b resolving against the catalog of
+// the partner req is signed for. b is returned if there's none.
+func (b *BouncerHandler) withPartnerCatalog(req *http.Request) *BouncerHandler {
+	catalog := b.partnerCatalog(req)
+	if catalog == nil {
+		return b
+	}
+	pb := *b
+	pb.db = catalog
+	pb.AliasCache = nil
+	return &pb
+}
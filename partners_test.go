@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBouncerHandlerPartners(t *testing.T) {
+	key := []byte("partner-key")
+	handler := &BouncerHandler{
+		db:         bouncerHandler.db,
+		Partners:   map[string]Catalog{"acme": newerCatalog()},
+		PartnerKey: key,
+	}
+
+	const defaultLocation = "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"
+	const brandedLocation = "https://download-installer.cdn.mozilla.net/pub/firefox/releases/43.0.1/mac/en-US/Firefox%2043.0.1.dmg"
+	base := "http://test/?product=firefox-latest&os=osx&lang=en-US"
+
+	testRequests := []struct {
+		URL              string
+		ExpectedLocation string
+	}{
+		{base + "&partner=acme&partner_sig=" + PartnerSignature(key, "acme"), brandedLocation},
+		{base + "&partner=ACME&partner_sig=" + PartnerSignature(key, "acme"), brandedLocation},
+		{base, defaultLocation},
+		{base + "&partner=acme", defaultLocation},
+		{base + "&partner=acme&partner_sig=" + PartnerSignature([]byte("other-key"), "acme"), defaultLocation},
+		{base + "&partner=acme&partner_sig=nothex", defaultLocation},
+		{base + "&partner=other&partner_sig=" + PartnerSignature(key, "other"), defaultLocation},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, 302, w.Code, "url: %v", testRequest.URL)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v", testRequest.URL)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// DefaultMaxConcurrentProbes is the default limit of simultaneous outbound
+// probes
+const DefaultMaxConcurrentProbes = 16
+
+// ErrProbesSaturated is returned by a fail fast ProbeLimiter when the limit
+// of simultaneous probes is reached
+var ErrProbesSaturated = errors.New("too many concurrent probes")
+
+// ProbeLimiter limits the number of outbound probes to mirrors, e.g.,: the
+// latency checks of MirrorLatencyChecker, running at the same time
+type ProbeLimiter struct {
+	slots chan struct{}
+
+	// FailFast makes Do return ErrProbesSaturated instead of waiting for
+	// a running probe to finish
+	FailFast bool
+}
+
+// NewProbeLimiter returns a limiter allowing maxConcurrentProbes probes at
+// a time. If maxConcurrentProbes <= 0, DefaultMaxConcurrentProbes is used.
+func NewProbeLimiter(maxConcurrentProbes int) *ProbeLimiter {
+	if maxConcurrentProbes <= 0 {
+		maxConcurrentProbes = DefaultMaxConcurrentProbes
+	}
+	return &ProbeLimiter{
+		slots: make(chan struct{}, maxConcurrentProbes),
+	}
+}
+
+// Do runs probe once there are fewer than the maximum probes running. It
+// returns ctx.Err() if ctx is done first. A nil ProbeLimiter runs probe
+// right away.
+func (p *ProbeLimiter) Do(ctx context.Context, probe func() error) error {
+	if p == nil {
+		return probe()
+	}
+	if p.FailFast {
+		select {
+		case p.slots <- struct{}{}:
+		default:
+			return ErrProbesSaturated
+		}
+	} else {
+		select {
+		case p.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	defer func() { <-p.slots }()
+
+	return probe()
+}
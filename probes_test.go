@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProbeLimiter(t *testing.T) {
+	limiter := NewProbeLimiter(3)
+
+	var running, maxRunning int32
+	probe := func() error {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, limiter.Do(context.Background(), probe))
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(3), maxRunning)
+}
+
+func TestProbeLimiterFailFast(t *testing.T) {
+	limiter := NewProbeLimiter(1)
+	limiter.FailFast = true
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	go limiter.Do(context.Background(), func() error {
+		close(started)
+		<-finish
+		return nil
+	})
+	<-started
+
+	err := limiter.Do(context.Background(), func() error { return nil })
+	assert.Equal(t, ErrProbesSaturated, err)
+
+	close(finish)
+}
+
+func TestProbeLimiterContext(t *testing.T) {
+	limiter := NewProbeLimiter(1)
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	go limiter.Do(context.Background(), func() error {
+		close(started)
+		<-finish
+		return nil
+	})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := limiter.Do(ctx, func() error { return nil })
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	close(finish)
+}
+
+func TestNewProbeLimiterDefault(t *testing.T) {
+	assert.Equal(t, DefaultMaxConcurrentProbes, cap(NewProbeLimiter(0).slots))
+}
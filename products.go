@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mozilla-services/go-bouncer/bouncer"
+)
+
+// FormatCSV is the format param value requesting a csv response
+const FormatCSV = "csv"
+
+// CatalogLoader loads the catalog data listed by ProductsHandler
+type CatalogLoader interface {
+	LoadCatalogData() (*bouncer.CatalogData, error)
+}
+
+// ProductListing is a product and the oses it is available on
+type ProductListing struct {
+	Product string   `json:"product"`
+	SSLOnly bool     `json:"ssl_only"`
+	OS      []string `json:"os"`
+}
+
+// ProductsHandler lists the active products of the catalog, as json or, for
+// clients asking for csv, as csv
+type ProductsHandler struct {
+	db CatalogLoader
+}
+
+// wantsCSV returns true if the client asked for a csv response
+func wantsCSV(req *http.Request) bool {
+	return strings.ToLower(req.URL.Query().Get("format")) == FormatCSV ||
+		strings.Contains(req.Header.Get("Accept"), "text/csv")
+}
+
+// productListings returns the products of data, sorted by name, with their
+// sorted oses
+func productListings(data *bouncer.CatalogData) []ProductListing {
+	oses := make(map[string][]string, len(data.Products))
+	for _, l := range data.Locations {
+		oses[l.ProductID] = append(oses[l.ProductID], l.OS)
+	}
+
+	listings := make([]ProductListing, 0, len(data.Products))
+	for _, p := range data.Products {
+		os := oses[p.ID]
+		if os == nil {
+			os = []string{}
+		}
+		sort.Strings(os)
+		listings = append(listings, ProductListing{
+			Product: p.Name,
+			SSLOnly: p.SSLOnly,
+			OS:      os,
+		})
+	}
+	sort.Slice(listings, func(i, j int) bool { return listings[i].Product < listings[j].Product })
+	return listings
+}
+
+func (h *ProductsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	data, err := h.db.LoadCatalogData()
+	if err != nil {
+		log.Printf("ProductsHandler err: %v", err)
+		errorResponse(w, req, http.StatusInternalServerError, ErrorCodeInternal, "Internal Server Error.")
+		return
+	}
+	listings := productListings(data)
+
+	if wantsCSV(req) {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"product", "ssl_only", "os"})
+		for _, l := range listings {
+			writer.Write([]string{l.Product, strconv.FormatBool(l.SSLOnly), strings.Join(l.OS, " ")})
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			log.Printf("ProductsHandler err: %v", err)
+		}
+		return
+	}
+
+	res, err := json.Marshal(map[string][]ProductListing{"products": listings})
+	if err != nil {
+		log.Printf("ProductsHandler err: %v", err)
+		errorResponse(w, req, http.StatusInternalServerError, ErrorCodeInternal, "Internal Server Error.")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
+
+// MatrixSource lists every os and lang in the catalog
+type MatrixSource interface {
+	CatalogOSes() ([]string, error)
+	CatalogLangs() ([]string, error)
+}
+
+// Matrix is the sorted oses and langs of the catalog
+type Matrix struct {
+	OS   []string `json:"os"`
+	Lang []string `json:"lang"`
+}
+
+// MatrixHandler serves the oses and langs across the catalog, for building
+// download matrices
+type MatrixHandler struct {
+	db MatrixSource
+}
+
+func (h *MatrixHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	oses, err := h.db.CatalogOSes()
+	if err != nil {
+		log.Printf("MatrixHandler err: %v", err)
+		errorResponse(w, req, http.StatusInternalServerError, ErrorCodeInternal, "Internal Server Error.")
+		return
+	}
+	langs, err := h.db.CatalogLangs()
+	if err != nil {
+		log.Printf("MatrixHandler err: %v", err)
+		errorResponse(w, req, http.StatusInternalServerError, ErrorCodeInternal, "Internal Server Error.")
+		return
+	}
+
+	res, err := json.Marshal(&Matrix{OS: oses, Lang: langs})
+	if err != nil {
+		log.Printf("MatrixHandler err: %v", err)
+		errorResponse(w, req, http.StatusInternalServerError, ErrorCodeInternal, "Internal Server Error.")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mozilla-services/go-bouncer/bouncer"
+	"github.com/stretchr/testify/assert"
+)
+
+// staticCatalogLoader is a CatalogLoader returning fixed data
+type staticCatalogLoader struct {
+	data *bouncer.CatalogData
+}
+
+func (s *staticCatalogLoader) LoadCatalogData() (*bouncer.CatalogData, error) {
+	return s.data, nil
+}
+
+var testProductsHandler = &ProductsHandler{
+	db: &staticCatalogLoader{&bouncer.CatalogData{
+		Products: []bouncer.CatalogProduct{
+			{ID: "1", Name: "Firefox", Langs: 2},
+			{ID: "2", Name: "Firefox, \"Partner\" Edition", SSLOnly: true},
+		},
+		Locations: []bouncer.CatalogLocation{
+			{ProductID: "1", OS: "win", Path: "/firefox/:lang/win.exe"},
+			{ProductID: "1", OS: "osx", Path: "/firefox/:lang/mac.dmg"},
+			{ProductID: "2", OS: "win", Path: "/partner/win.exe"},
+		},
+	}},
+}
+
+func TestProductsHandlerCSV(t *testing.T) {
+	for _, url := range []string{"http://test/__products__?format=csv", "http://test/__products__"} {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", url, nil)
+		assert.NoError(t, err)
+		if url == "http://test/__products__" {
+			req.Header.Set("Accept", "text/csv")
+		}
+
+		testProductsHandler.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code, "url: %v", url)
+		assert.Equal(t, "text/csv; charset=utf-8", w.HeaderMap.Get("Content-Type"), "url: %v", url)
+		assert.Equal(t, "product,ssl_only,os\n"+
+			"Firefox,false,osx win\n"+
+			"\"Firefox, \"\"Partner\"\" Edition\",true,win\n", w.Body.String(), "url: %v", url)
+
+		records, err := csv.NewReader(w.Body).ReadAll()
+		assert.NoError(t, err, "url: %v", url)
+		if assert.Len(t, records, 3, "url: %v", url) {
+			assert.Equal(t, []string{"Firefox, \"Partner\" Edition", "true", "win"}, records[2])
+		}
+	}
+}
+
+func TestProductsHandlerJSON(t *testing.T) {
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/__products__", nil)
+	assert.NoError(t, err)
+
+	testProductsHandler.ServeHTTP(w, req)
+	assert.Equal(t, "application/json", w.HeaderMap.Get("Content-Type"))
+
+	res := map[string][]ProductListing{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, []ProductListing{
+		{Product: "Firefox", OS: []string{"osx", "win"}},
+		{Product: "Firefox, \"Partner\" Edition", SSLOnly: true, OS: []string{"win"}},
+	}, res["products"])
+}
+
+func TestMatrixHandler(t *testing.T) {
+	handler := &MatrixHandler{db: bouncerHandler.db.(*bouncer.DB)}
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/__matrix__", nil)
+	assert.NoError(t, err)
+
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "application/json", w.HeaderMap.Get("Content-Type"))
+
+	res := &Matrix{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), res))
+	assert.Equal(t, []string{"osx", "win", "win64"}, res.OS)
+	assert.Equal(t, []string{"en-GB", "en-US"}, res.Lang)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultRecentErrorsSize is the default number of resolution errors kept
+const DefaultRecentErrorsSize = 100
+
+// ResolutionError is a request that failed to resolve
+type ResolutionError struct {
+	Product string    `json:"product"`
+	OS      string    `json:"os"`
+	Lang    string    `json:"lang"`
+	Error   string    `json:"error"`
+	Time    time.Time `json:"time"`
+}
+
+// RecentErrors keeps the last resolution errors in a fixed size ring
+type RecentErrors struct {
+	mu      sync.Mutex
+	entries []ResolutionError
+	next    int
+	full    bool
+}
+
+// NewRecentErrors returns a ring keeping the last size errors
+func NewRecentErrors(size int) *RecentErrors {
+	if size < 0 {
+		size = 0
+	}
+	return &RecentErrors{entries: make([]ResolutionError, size)}
+}
+
+// Add records e, replacing the oldest error if the ring is full
+func (r *RecentErrors) Add(e ResolutionError) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.entries) == 0 {
+		return
+	}
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Entries returns the recorded errors, oldest first
+func (r *RecentErrors) Entries() []ResolutionError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.full {
+		return append([]ResolutionError{}, r.entries[:r.next]...)
+	}
+	return append(append([]ResolutionError{}, r.entries[r.next:]...), r.entries[:r.next]...)
+}
+
+// RecentErrorsHandler serves the recent resolution errors as json to
+// requests with an "Authorization: Bearer <Token>" header
+type RecentErrorsHandler struct {
+	Errors *RecentErrors
+	Token  string
+}
+
+func (h *RecentErrorsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if h.Token == "" || h.Errors == nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	token := req.Header.Get("Authorization")
+	if subtle.ConstantTimeCompare([]byte(token), []byte("Bearer "+h.Token)) != 1 {
+		http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+		return
+	}
+
+	res, err := json.Marshal(struct {
+		Errors []ResolutionError `json:"errors"`
+	}{h.Errors.Entries()})
+	if err != nil {
+		log.Printf("RecentErrorsHandler err: %v", err)
+		http.Error(w, "Internal Server Error.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
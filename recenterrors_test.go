@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecentErrorsWraps(t *testing.T) {
+	recent := NewRecentErrors(3)
+	assert.Len(t, recent.Entries(), 0)
+
+	for i := 0; i < 5; i++ {
+		recent.Add(ResolutionError{Product: fmt.Sprintf("product-%d", i)})
+	}
+
+	entries := recent.Entries()
+	if assert.Len(t, entries, 3) {
+		assert.Equal(t, "product-2", entries[0].Product)
+		assert.Equal(t, "product-3", entries[1].Product)
+		assert.Equal(t, "product-4", entries[2].Product)
+	}
+
+	// a zero size ring keeps nothing
+	recent = NewRecentErrors(0)
+	recent.Add(ResolutionError{Product: "product-0"})
+	assert.Len(t, recent.Entries(), 0)
+}
+
+func TestBouncerHandlerRecentErrors(t *testing.T) {
+	recent := NewRecentErrors(10)
+	handler := &BouncerHandler{
+		db:           bouncerHandler.db,
+		RecentErrors: recent,
+	}
+	debugHandler := &RecentErrorsHandler{Errors: recent, Token: "secret"}
+
+	for _, url := range []string{
+		"http://test/?product=firefox-unknown&os=osx&lang=en-US",
+		"http://test/?product=firefox-latest&os=osx&lang=en-US",
+		"http://test/?product=firefox-latest&os=beos&lang=de",
+	} {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", url, nil)
+		assert.NoError(t, err)
+		handler.ServeHTTP(w, req)
+	}
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/__debug__/errors", nil)
+	assert.NoError(t, err)
+	debugHandler.ServeHTTP(w, req)
+	assert.Equal(t, 401, w.Code)
+
+	w = httptest.NewRecorder()
+	req.Header.Set("Authorization", "Bearer wrong")
+	debugHandler.ServeHTTP(w, req)
+	assert.Equal(t, 401, w.Code)
+
+	w = httptest.NewRecorder()
+	req.Header.Set("Authorization", "Bearer secret")
+	debugHandler.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	body := struct {
+		Errors []ResolutionError `json:"errors"`
+	}{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	if assert.Len(t, body.Errors, 2) {
+		assert.Equal(t, "firefox-unknown", body.Errors[0].Product)
+		assert.Equal(t, "osx", body.Errors[0].OS)
+		assert.Equal(t, "en-US", body.Errors[0].Lang)
+		assert.Equal(t, "unresolved: product_not_found", body.Errors[0].Error)
+		assert.False(t, body.Errors[0].Time.IsZero())
+
+		assert.Equal(t, "beos", body.Errors[1].OS)
+		assert.Equal(t, "unresolved: os_not_found", body.Errors[1].Error)
+	}
+
+	// without a token the endpoint doesn't exist
+	w = httptest.NewRecorder()
+	(&RecentErrorsHandler{Errors: recent}).ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+}
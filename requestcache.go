@@ -0,0 +1,146 @@
+package main
+
+import "github.com/mozilla-services/go-bouncer/bouncer"
+
+// requestCatalog memoizes the lookups of a Catalog for the duration of a
+// request, so resolving several oses, products or fallbacks for one request
+// doesn't repeat them. Errors are memoized too. It isn't safe for concurrent
+// use.
+type requestCatalog struct {
+	Catalog
+
+	aliases          map[string]aliasLookup
+	osIDs            map[string]osIDLookup
+	products         map[[2]string]productLookup
+	regions          map[[2]string]regionsLookup
+	locations        map[[2]string]locationLookup
+	productLocations map[string]productLocationsLookup
+	mirrors          map[bool]mirrorsLookup
+}
+
+type aliasLookup struct {
+	related string
+	err     error
+}
+
+type osIDLookup struct {
+	id  string
+	err error
+}
+
+type productLookup struct {
+	productID string
+	sslOnly   bool
+	language  string
+	err       error
+}
+
+type regionsLookup struct {
+	langs []string
+	err   error
+}
+
+type locationLookup struct {
+	id, path string
+	err      error
+}
+
+type productLocationsLookup struct {
+	locations []*bouncer.ProductLocationsResult
+	err       error
+}
+
+type mirrorsLookup struct {
+	mirrors []bouncer.MirrorsResult
+	err     error
+}
+
+func newRequestCatalog(c Catalog) *requestCatalog {
+	return &requestCatalog{
+		Catalog:          c,
+		aliases:          make(map[string]aliasLookup),
+		osIDs:            make(map[string]osIDLookup),
+		products:         make(map[[2]string]productLookup),
+		regions:          make(map[[2]string]regionsLookup),
+		locations:        make(map[[2]string]locationLookup),
+		productLocations: make(map[string]productLocationsLookup),
+		mirrors:          make(map[bool]mirrorsLookup),
+	}
+}
+
+func (r *requestCatalog) AliasFor(product string) (string, error) {
+	l, ok := r.aliases[product]
+	if !ok {
+		l.related, l.err = r.Catalog.AliasFor(product)
+		r.aliases[product] = l
+	}
+	return l.related, l.err
+}
+
+func (r *requestCatalog) OSID(name string) (string, error) {
+	l, ok := r.osIDs[name]
+	if !ok {
+		l.id, l.err = r.Catalog.OSID(name)
+		r.osIDs[name] = l
+	}
+	return l.id, l.err
+}
+
+func (r *requestCatalog) ProductForLanguage(product, lang string) (string, bool, string, error) {
+	key := [2]string{product, lang}
+	l, ok := r.products[key]
+	if !ok {
+		l.productID, l.sslOnly, l.language, l.err = r.Catalog.ProductForLanguage(product, lang)
+		r.products[key] = l
+	}
+	return l.productID, l.sslOnly, l.language, l.err
+}
+
+func (r *requestCatalog) ProductRegions(product, language string) ([]string, error) {
+	key := [2]string{product, language}
+	l, ok := r.regions[key]
+	if !ok {
+		l.langs, l.err = r.Catalog.ProductRegions(product, language)
+		r.regions[key] = l
+	}
+	return l.langs, l.err
+}
+
+func (r *requestCatalog) Location(productID, osID string) (string, string, error) {
+	key := [2]string{productID, osID}
+	l, ok := r.locations[key]
+	if !ok {
+		l.id, l.path, l.err = r.Catalog.Location(productID, osID)
+		r.locations[key] = l
+	}
+	return l.id, l.path, l.err
+}
+
+func (r *requestCatalog) ProductLocations(productID string) ([]*bouncer.ProductLocationsResult, error) {
+	l, ok := r.productLocations[productID]
+	if !ok {
+		l.locations, l.err = r.Catalog.ProductLocations(productID)
+		r.productLocations[productID] = l
+	}
+	return l.locations, l.err
+}
+
+func (r *requestCatalog) Mirrors(sslOnly bool) ([]bouncer.MirrorsResult, error) {
+	l, ok := r.mirrors[sslOnly]
+	if !ok {
+		l.mirrors, l.err = r.Catalog.Mirrors(sslOnly)
+		r.mirrors[sslOnly] = l
+	}
+	return l.mirrors, l.err
+}
+
+// withRequestCache returns a copy of b whose catalog lookups are memoized,
+// for serving a single request
+func (b *BouncerHandler) withRequestCache() *BouncerHandler {
+	if _, ok := b.db.(*requestCatalog); ok {
+		return b
+	}
+	rb := *b
+	rb.db = newRequestCatalog(b.db)
+	return &rb
+}
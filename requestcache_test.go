@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mozilla-services/go-bouncer/bouncer"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingCatalog counts the lookups it receives, by key
+type countingCatalog struct {
+	Catalog
+
+	aliases  map[string]int
+	osIDs    map[string]int
+	products map[string]int
+	mirrors  map[bool]int
+}
+
+func newCountingCatalog(c Catalog) *countingCatalog {
+	return &countingCatalog{
+		Catalog:  c,
+		aliases:  map[string]int{},
+		osIDs:    map[string]int{},
+		products: map[string]int{},
+		mirrors:  map[bool]int{},
+	}
+}
+
+func (c *countingCatalog) AliasFor(product string) (string, error) {
+	c.aliases[product]++
+	return c.Catalog.AliasFor(product)
+}
+
+func (c *countingCatalog) OSID(name string) (string, error) {
+	c.osIDs[name]++
+	return c.Catalog.OSID(name)
+}
+
+func (c *countingCatalog) ProductForLanguage(product, lang string) (string, bool, string, error) {
+	c.products[product+"/"+lang]++
+	return c.Catalog.ProductForLanguage(product, lang)
+}
+
+func (c *countingCatalog) Mirrors(sslOnly bool) ([]bouncer.MirrorsResult, error) {
+	c.mirrors[sslOnly]++
+	return c.Catalog.Mirrors(sslOnly)
+}
+
+func TestRequestCatalogBundle(t *testing.T) {
+	catalog := newCountingCatalog(bouncerHandler.db)
+	handler := &BouncerHandler{
+		db:      catalog,
+		Bundles: map[string][]string{"fx": {"firefox-latest", "firefox-ssl", "firefox-latest"}},
+	}
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/?product=fx&os=osx&lang=en-US&format=json", nil)
+	assert.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	assert.Equal(t, map[string]int{"firefox-latest": 1, "firefox-ssl": 1}, catalog.aliases)
+	assert.Equal(t, map[string]int{"osx": 1}, catalog.osIDs)
+	assert.Equal(t, map[string]int{"Firefox/en-US": 1, "firefox-ssl/en-US": 1}, catalog.products)
+	assert.Equal(t, map[bool]int{false: 1, true: 1}, catalog.mirrors)
+
+	// every request has its own cache
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, 2, catalog.aliases["firefox-latest"])
+}
+
+func TestRequestCatalogFallbacks(t *testing.T) {
+	catalog := newCountingCatalog(bouncerHandler.db)
+	handler := &BouncerHandler{
+		db:                  catalog,
+		UniversalOS:         map[string]string{"firefox": "web"},
+		ProductFeatureFlags: map[string]string{"firefox-nightly": "nightly"},
+	}
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&lang=en-US", nil)
+	assert.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 302, w.Code)
+
+	// the feature flag check and both resolutions share the alias lookup
+	assert.Equal(t, map[string]int{"firefox-latest": 1}, catalog.aliases)
+	assert.Equal(t, map[string]int{"web": 1, "win": 1}, catalog.osIDs)
+}
+
+// timingMetrics records the names of the timings it receives
+type timingMetrics struct {
+	recordingMetrics
+	timings []string
+}
+
+func (m *timingMetrics) Timing(name string, d time.Duration) {
+	m.timings = append(m.timings, name)
+}
+
+func TestBouncerHandlerResolveTiming(t *testing.T) {
+	metrics := &timingMetrics{}
+	handler := &BouncerHandler{db: bouncerHandler.db, Metrics: metrics}
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 302, w.Code)
+
+	assert.Equal(t, []string{"resolve"}, metrics.timings)
+}
+
+func TestBouncerHandlerResolveCacheTiming(t *testing.T) {
+	metrics := &timingMetrics{}
+	handler := &BouncerHandler{
+		db:         bouncerHandler.db,
+		AliasCache: NewAliasCache(time.Minute, DefaultAliasCacheSize),
+		Metrics:    metrics,
+	}
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US", nil)
+		assert.NoError(t, err)
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, 302, w.Code)
+	}
+
+	// The alias is only looked up by the first request
+	assert.Equal(t, []string{"resolve.miss", "resolve.hit"}, metrics.timings)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+)
+
+// MaxProductRewrites is the maximum number of product rewrite rules. Every
+// rule may be evaluated for every request.
+const MaxProductRewrites = 100
+
+// MaxProductRewritePatternLength is the maximum length of a product rewrite
+// pattern. Go regexps run in linear time, but long patterns are still slow
+// to match and hard to review.
+const MaxProductRewritePatternLength = 256
+
+// ProductRewrite rewrites the products fully matching Pattern to
+// Replacement, which may refer to groups of Pattern, e.g. $1
+type ProductRewrite struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// NewProductRewrite compiles a product rewrite rule. pattern must match the
+// whole product name.
+func NewProductRewrite(pattern, replacement string) (*ProductRewrite, error) {
+	if len(pattern) > MaxProductRewritePatternLength {
+		return nil, fmt.Errorf("product rewrite pattern longer than %d: %q", MaxProductRewritePatternLength, pattern)
+	}
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, err
+	}
+	return &ProductRewrite{
+		Pattern:     re,
+		Replacement: replacement,
+	}, nil
+}
+
+// rewriteProduct returns product rewritten by the first rule matching it, or
+// product unchanged if none matches
+func rewriteProduct(rewrites []*ProductRewrite, product string) string {
+	for _, rewrite := range rewrites {
+		if rewrite.Pattern.MatchString(product) {
+			return rewrite.Pattern.ReplaceAllString(product, rewrite.Replacement)
+		}
+	}
+	return product
+}
+
+// UARewriteRule rewrites the products fully matching Product to Replacement
+// for clients whose user agent matches UserAgent, e.g. to serve legacy
+// clients a build they still run
+type UARewriteRule struct {
+	UserAgent   *regexp.Regexp
+	Product     *regexp.Regexp
+	Replacement string
+}
+
+// uaRewriteRuleJSON is a UARewriteRule as configured
+type uaRewriteRuleJSON struct {
+	UserAgent   string `json:"user_agent"`
+	Product     string `json:"product"`
+	Replacement string `json:"replacement"`
+}
+
+// NewUARewriteRule compiles a user agent rewrite rule. productPattern must
+// match the whole product name, userAgentPattern any part of the user
+// agent.
+func NewUARewriteRule(userAgentPattern, productPattern, replacement string) (*UARewriteRule, error) {
+	for _, pattern := range []string{userAgentPattern, productPattern} {
+		if len(pattern) > MaxProductRewritePatternLength {
+			return nil, fmt.Errorf("user agent rewrite pattern longer than %d: %q", MaxProductRewritePatternLength, pattern)
+		}
+	}
+	if userAgentPattern == "" || productPattern == "" || replacement == "" {
+		return nil, fmt.Errorf("user agent rewrite rule needs a user agent, product and replacement")
+	}
+
+	userAgent, err := regexp.Compile(userAgentPattern)
+	if err != nil {
+		return nil, err
+	}
+	product, err := regexp.Compile("^(?:" + productPattern + ")$")
+	if err != nil {
+		return nil, err
+	}
+	return &UARewriteRule{
+		UserAgent:   userAgent,
+		Product:     product,
+		Replacement: replacement,
+	}, nil
+}
+
+// readUARewriteRules reads a json list of user agent rewrite rules, e.g.,:
+// [{"user_agent": "Windows NT 6\\.[01]", "product": "firefox-.*", "replacement": "firefox-esr-latest"}]
+func readUARewriteRules(r io.Reader) ([]*UARewriteRule, error) {
+	configured := []uaRewriteRuleJSON{}
+	if err := json.NewDecoder(r).Decode(&configured); err != nil {
+		return nil, err
+	}
+	if len(configured) > MaxProductRewrites {
+		return nil, fmt.Errorf("more than %d user agent rewrite rules", MaxProductRewrites)
+	}
+
+	rules := make([]*UARewriteRule, 0, len(configured))
+	for _, c := range configured {
+		rule, err := NewUARewriteRule(c.UserAgent, c.Product, c.Replacement)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// rewriteUserAgentProduct returns product rewritten by the first rule
+// matching userAgent and product, and false if no rule matches
+func rewriteUserAgentProduct(rules []*UARewriteRule, userAgent, product string) (string, bool) {
+	for _, rule := range rules {
+		if rule.UserAgent.MatchString(userAgent) && rule.Product.MatchString(product) {
+			return rule.Product.ReplaceAllString(product, rule.Replacement), true
+		}
+	}
+	return product, false
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewProductRewrite(t *testing.T) {
+	_, err := NewProductRewrite(`firefox-(\d+`, "firefox-latest")
+	assert.Error(t, err)
+
+	_, err = NewProductRewrite(strings.Repeat("a", MaxProductRewritePatternLength+1), "firefox-latest")
+	assert.Error(t, err)
+
+	rewrite, err := NewProductRewrite(`firefox-(\d+)\.0`, "firefox-$1.0-ssl")
+	assert.NoError(t, err)
+	assert.Equal(t, "firefox-39.0-ssl", rewriteProduct([]*ProductRewrite{rewrite}, "firefox-39.0"))
+	// patterns match whole product names
+	assert.Equal(t, "firefox-39.0-stub", rewriteProduct([]*ProductRewrite{rewrite}, "firefox-39.0-stub"))
+}
+
+func TestBouncerHandlerProductRewrites(t *testing.T) {
+	mustRewrite := func(pattern, replacement string) *ProductRewrite {
+		rewrite, err := NewProductRewrite(pattern, replacement)
+		assert.NoError(t, err)
+		return rewrite
+	}
+
+	handler := &BouncerHandler{
+		db: betaCatalog(),
+		ProductRewrites: []*ProductRewrite{
+			mustRewrite(`firefox-(\d+)\.0-stub`, "firefox-latest"),
+			mustRewrite(`firefox-.*-stub`, "firefox-beta-latest"),
+		},
+	}
+
+	testRequests := []struct {
+		URL              string
+		ExpectedLocation string
+	}{
+		{"http://test/?product=firefox-39.0-stub&os=osx&lang=en-US", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{"http://test/?product=firefox-40.0-stub&os=osx&lang=en-US", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{"http://test/?product=firefox-40.0b1-stub&os=osx&lang=en-US", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"},
+		{"http://test/?product=firefox-40.0-full&os=osx&lang=en-US", ""},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v", testRequest.URL)
+	}
+}
+
+func TestReadUARewriteRules(t *testing.T) {
+	rules, err := readUARewriteRules(strings.NewReader(`[
+		{"user_agent": "Windows NT 6\\.[01]", "product": "firefox-(latest|beta-latest)", "replacement": "firefox-$1-ssl"}
+	]`))
+	assert.NoError(t, err)
+	if assert.Len(t, rules, 1) {
+		product, ok := rewriteUserAgentProduct(rules, "Mozilla/5.0 (Windows NT 6.1; rv:68.0)", "firefox-latest")
+		assert.True(t, ok)
+		assert.Equal(t, "firefox-latest-ssl", product)
+	}
+
+	_, err = readUARewriteRules(strings.NewReader(`[{"user_agent": "Windows", "product": "firefox-(", "replacement": "firefox-latest"}]`))
+	assert.Error(t, err)
+
+	_, err = readUARewriteRules(strings.NewReader(`[{"user_agent": "Windows", "product": "firefox-latest"}]`))
+	assert.Error(t, err)
+}
+
+func TestBouncerHandlerUARewriteRules(t *testing.T) {
+	rule, err := NewUARewriteRule(`Windows NT 6\.[01]`, "firefox-latest", "firefox-sha1")
+	assert.NoError(t, err)
+	handler := &BouncerHandler{
+		db:             betaCatalog(),
+		UARewriteRules: []*UARewriteRule{rule},
+	}
+
+	testRequests := []struct {
+		URL              string
+		UserAgent        string
+		ExpectedLocation string
+	}{
+		{"http://test/?product=firefox-latest&os=win&lang=en-US", "Mozilla/5.0 (Windows NT 6.1; rv:68.0) Gecko/20100101 Firefox/68.0", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/43.0.1/win32/en-US/Firefox%20Setup%2043.0.1.exe"},
+		{"http://test/?product=firefox-latest&os=win&lang=en-US", "Mozilla/5.0 (Windows NT 10.0; rv:68.0) Gecko/20100101 Firefox/68.0", "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win32/en-US/Firefox%20Setup%2039.0.exe"},
+		{"http://test/?product=firefox-beta-latest&os=win&lang=en-US", "Mozilla/5.0 (Windows NT 6.1; rv:68.0) Gecko/20100101 Firefox/68.0", "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win32/en-US/Firefox%20Setup%2039.0.exe"},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+		req.Header.Set("User-Agent", testRequest.UserAgent)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v ua: %v", testRequest.URL, testRequest.UserAgent)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Reasons a redirect is to its scheme, tagging the redirect.scheme metric
+const (
+	SchemeReasonSSLOnly     = "ssl_only"
+	SchemeReasonHTTPSLang   = "https_lang"
+	SchemeReasonSchemeParam = "scheme_param"
+	SchemeReasonPinHeader   = "pin_header"
+	SchemeReasonPreferHTTPS = "prefer_https"
+	SchemeReasonDefault     = "default"
+)
+
+// schemeReason returns why the redirect of a request for a product, ssl
+// only or not, is to the scheme it is
+func (b *BouncerHandler) schemeReason(req *http.Request, reqParams *BouncerParams, sslOnly bool) string {
+	switch {
+	case sslOnly:
+		return SchemeReasonSSLOnly
+	case b.isHTTPSLang(reqParams.Lang):
+		return SchemeReasonHTTPSLang
+	case reqParams.Scheme == "https" || reqParams.Scheme == "http":
+		return SchemeReasonSchemeParam
+	case b.shouldPinHttps(req):
+		return SchemeReasonPinHeader
+	case b.PreferHTTPS || b.preferHttps:
+		return SchemeReasonPreferHTTPS
+	}
+	return SchemeReasonDefault
+}
+
+// countRedirectScheme counts a redirect to url in the redirect.scheme
+// metric, tagged with its scheme and the reason for it
+func (b *BouncerHandler) countRedirectScheme(req *http.Request, reqParams *BouncerParams, url string, sslOnly bool) {
+	scheme := "http"
+	if strings.HasPrefix(url, "https://") {
+		scheme = "https"
+	}
+	b.incrTagged("redirect.scheme", map[string]string{
+		"scheme": scheme,
+		"reason": b.schemeReason(req, reqParams, sslOnly),
+	})
+}
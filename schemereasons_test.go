@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// taggedRecordingMetrics counts the tagged metrics it receives by name and
+// tags, e.g. redirect.scheme#reason:default,scheme:http
+type taggedRecordingMetrics struct {
+	recordingMetrics
+}
+
+func (r *taggedRecordingMetrics) IncrTagged(name string, tags map[string]string) {
+	pairs := []string{}
+	for k, v := range tags {
+		pairs = append(pairs, k+":"+v)
+	}
+	sort.Strings(pairs)
+	r.Incr(name + "#" + strings.Join(pairs, ","))
+}
+
+func TestBouncerHandlerRedirectSchemeMetrics(t *testing.T) {
+	testRequests := []struct {
+		Handler        *BouncerHandler
+		URL            string
+		PinHeader      string
+		ExpectedMetric string
+	}{
+		{&BouncerHandler{}, "http://test/?product=firefox-latest&os=osx&lang=en-US", "", "redirect.scheme#reason:default,scheme:http"},
+		{&BouncerHandler{}, "http://test/?product=firefox-ssl&os=osx&lang=en-US", "", "redirect.scheme#reason:ssl_only,scheme:https"},
+		{&BouncerHandler{}, "http://test/?product=firefox-ssl&os=osx&lang=en-US&scheme=http", "", "redirect.scheme#reason:ssl_only,scheme:https"},
+		{&BouncerHandler{}, "http://test/?product=firefox-latest&os=osx&lang=en-US&scheme=https", "", "redirect.scheme#reason:scheme_param,scheme:https"},
+		{&BouncerHandler{PreferHTTPS: true}, "http://test/?product=firefox-latest&os=osx&lang=en-US&scheme=http", "", "redirect.scheme#reason:scheme_param,scheme:http"},
+		{&BouncerHandler{PinHttpsHeaderName: "X-Forwarded-Proto"}, "http://test/?product=firefox-latest&os=osx&lang=en-US", "https", "redirect.scheme#reason:pin_header,scheme:https"},
+		{&BouncerHandler{PreferHTTPS: true}, "http://test/?product=firefox-latest&os=osx&lang=en-US", "", "redirect.scheme#reason:prefer_https,scheme:https"},
+		{&BouncerHandler{HTTPSLangs: []string{"en"}}, "http://test/?product=firefox-latest&os=osx&lang=en-US", "", "redirect.scheme#reason:https_lang,scheme:https"},
+	}
+
+	catalog := memoizedCatalog()
+	for _, testRequest := range testRequests {
+		metrics := &taggedRecordingMetrics{}
+		handler := testRequest.Handler
+		handler.db = catalog
+		handler.Metrics = metrics
+
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+		if testRequest.PinHeader != "" {
+			req.Header.Set("X-Forwarded-Proto", testRequest.PinHeader)
+		}
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, 302, w.Code, "url: %v", testRequest.URL)
+		assert.Equal(t, 1, metrics.counts[testRequest.ExpectedMetric], "url: %v metrics: %v", testRequest.URL, metrics.counts)
+	}
+
+	// print=yes and failed requests aren't redirects
+	for _, url := range []string{
+		"http://test/?product=firefox-latest&os=osx&lang=en-US&print=yes",
+		"http://test/?product=firefox-unknown&os=osx&lang=en-US",
+	} {
+		metrics := &taggedRecordingMetrics{}
+		handler := &BouncerHandler{db: bouncerHandler.db, Metrics: metrics}
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", url, nil)
+		assert.NoError(t, err, "url: %v", url)
+		handler.ServeHTTP(w, req)
+		for name := range metrics.counts {
+			assert.False(t, strings.HasPrefix(name, "redirect.scheme"), "url: %v metric: %v", url, name)
+		}
+	}
+}
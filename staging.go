@@ -0,0 +1,20 @@
+package main
+
+import "net/http"
+
+// StagingParam is the query param resolving a request against the staging
+// catalog, if EnableStaging is set
+const StagingParam = "staging"
+
+// withStagingCatalog returns a copy of b resolving against the staging
+// catalog if req asks for it and staging is enabled. b is returned
+// otherwise.
+func (b *BouncerHandler) withStagingCatalog(req *http.Request) *BouncerHandler {
+	if !b.EnableStaging || b.Staging == nil || req.URL.Query().Get(StagingParam) != "1" {
+		return b
+	}
+	sb := *b
+	sb.db = b.Staging
+	sb.AliasCache = nil
+	return &sb
+}
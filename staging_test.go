@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBouncerHandlerStaging(t *testing.T) {
+	const liveLocation = "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/mac/en-US/Firefox%2039.0.dmg"
+	const stagingLocation = "https://download-installer.cdn.mozilla.net/pub/firefox/releases/43.0.1/mac/en-US/Firefox%2043.0.1.dmg"
+
+	testRequests := []struct {
+		EnableStaging    bool
+		URL              string
+		ExpectedLocation string
+	}{
+		{true, "http://test/?product=firefox-latest&os=osx&lang=en-US&staging=1", stagingLocation},
+		{true, "http://test/?product=firefox-latest&os=osx&lang=en-US&staging=0", liveLocation},
+		{true, "http://test/?product=firefox-latest&os=osx&lang=en-US", liveLocation},
+		{false, "http://test/?product=firefox-latest&os=osx&lang=en-US&staging=1", liveLocation},
+	}
+
+	for _, testRequest := range testRequests {
+		handler := &BouncerHandler{
+			db:            bouncerHandler.db,
+			Staging:       newerCatalog(),
+			EnableStaging: testRequest.EnableStaging,
+		}
+
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, 302, w.Code, "url: %v", testRequest.URL)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "url: %v, enabled: %v", testRequest.URL, testRequest.EnableStaging)
+	}
+}
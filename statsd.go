@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatsDSink sends metrics to a StatsD server over UDP, with DogStatsD
+// tags. Sends are fire-and-forget: metrics are dropped if the server is
+// unreachable, without slowing requests down.
+type StatsDSink struct {
+	// Prefix is prepended to metric names, e.g. bouncer for bouncer.download
+	Prefix string
+	// Tags are sent with every metric, e.g. env:prod
+	Tags []string
+
+	conn net.Conn
+}
+
+// NewStatsDSink returns a sink sending metrics to the StatsD server at
+// addr. UDP is connectionless, so it succeeds even if no server listens.
+func NewStatsDSink(addr, prefix string, tags []string) (*StatsDSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsDSink{Prefix: prefix, Tags: tags, conn: conn}, nil
+}
+
+// Close closes the connection of the sink
+func (s *StatsDSink) Close() error {
+	return s.conn.Close()
+}
+
+// Incr increments the counter name
+func (s *StatsDSink) Incr(name string) {
+	s.send(name, "1|c", nil)
+}
+
+// IncrTagged increments the counter name, tagged with tags
+func (s *StatsDSink) IncrTagged(name string, tags map[string]string) {
+	s.send(name, "1|c", tags)
+}
+
+// Timing records d, in milliseconds, as the timer name
+func (s *StatsDSink) Timing(name string, d time.Duration) {
+	s.send(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64)+"|ms", nil)
+}
+
+// send writes the metric line of name, e.g.
+// bouncer.download:1|c|#env:prod,os:win,product:firefox-latest
+func (s *StatsDSink) send(name, value string, tags map[string]string) {
+	line := name + ":" + value
+	if s.Prefix != "" {
+		line = s.Prefix + "." + line
+	}
+
+	all := make([]string, 0, len(s.Tags)+len(tags))
+	all = append(all, s.Tags...)
+	for k, v := range tags {
+		all = append(all, statsDTag(k)+":"+statsDTag(v))
+	}
+	if len(all) > 0 {
+		sort.Strings(all)
+		line += "|#" + strings.Join(all, ",")
+	}
+
+	// Errors, e.g. ECONNREFUSED from an earlier send, are ignored
+	s.conn.Write([]byte(line))
+}
+
+// statsDTagReplacer replaces the characters delimiting DogStatsD tags
+var statsDTagReplacer = strings.NewReplacer(",", "_", "|", "_", "#", "_", ":", "_", "\n", "_")
+
+// statsDTag escapes a tag key or value
+func statsDTag(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return statsDTagReplacer.Replace(s)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readStatsDLines reads the metric lines sent to conn until it is idle
+func readStatsDLines(t *testing.T, conn net.PacketConn) []string {
+	var lines []string
+	buf := make([]byte, 1500)
+	for {
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return lines
+		}
+		lines = append(lines, string(buf[:n]))
+	}
+}
+
+func TestStatsDSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	sink, err := NewStatsDSink(conn.LocalAddr().String(), "bouncer", []string{"env:test"})
+	assert.NoError(t, err)
+	defer sink.Close()
+
+	sink.Incr("alias_cache.hit")
+	sink.Timing("resolve", 1500*time.Microsecond)
+	sink.IncrTagged("download", map[string]string{"product": "firefox|latest", "os": ""})
+
+	assert.Equal(t, []string{
+		"bouncer.alias_cache.hit:1|c|#env:test",
+		"bouncer.resolve:1.5|ms|#env:test",
+		"bouncer.download:1|c|#env:test,os:none,product:firefox_latest",
+	}, readStatsDLines(t, conn))
+}
+
+func TestBouncerHandlerStatsD(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	sink, err := NewStatsDSink(conn.LocalAddr().String(), "bouncer", nil)
+	assert.NoError(t, err)
+	defer sink.Close()
+
+	handler := &BouncerHandler{db: bouncerHandler.db, Metrics: sink}
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 302, w.Code)
+
+	lines := readStatsDLines(t, conn)
+	// downloads are tagged with the catalog product and os they resolve to
+	assert.Contains(t, lines, "bouncer.download:1|c|#os:osx,product:Firefox")
+	timed := false
+	for _, line := range lines {
+		if strings.HasPrefix(line, "bouncer.resolve:") && strings.HasSuffix(line, "|ms") {
+			timed = true
+		}
+	}
+	assert.True(t, timed, "lines: %v", lines)
+
+	// failed and print=yes requests aren't downloads
+	for _, url := range []string{
+		"http://test/?product=bogus&os=osx&lang=en-US",
+		"http://test/?product=firefox-latest&os=osx&lang=en-US&print=yes",
+	} {
+		req, err = http.NewRequest("GET", url, nil)
+		assert.NoError(t, err)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		for _, line := range readStatsDLines(t, conn) {
+			assert.False(t, strings.HasPrefix(line, "bouncer.download"), "url: %v line: %v", url, line)
+		}
+	}
+}
+
+func TestStatsDSinkUnreachable(t *testing.T) {
+	// nothing listens on the port once the listener is closed
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := conn.LocalAddr().String()
+	conn.Close()
+
+	sink, err := NewStatsDSink(addr, "bouncer", nil)
+	assert.NoError(t, err)
+	defer sink.Close()
+
+	handler := &BouncerHandler{db: bouncerHandler.db, Metrics: sink}
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, 302, w.Code)
+	}
+}
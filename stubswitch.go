@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"sync/atomic"
+	"unicode"
+)
+
+// StubSwitch is a global switch for the stub installer, for stub service
+// incidents. While it is off, attributed requests aren't redirected to the
+// stub service and -stub products are served their full installer.
+type StubSwitch struct {
+	disabled int32
+}
+
+// Enabled returns true if the stub is on. A nil StubSwitch is always on.
+func (s *StubSwitch) Enabled() bool {
+	return s == nil || atomic.LoadInt32(&s.disabled) == 0
+}
+
+// SetEnabled turns the stub on or off
+func (s *StubSwitch) SetEnabled(enabled bool) {
+	var v int32
+	if !enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&s.disabled, v)
+}
+
+// fullInstallerProduct returns the product of the full installer of a stub
+// product, e.g. firefox-latest for firefox-stub, firefox-beta-latest for
+// firefox-beta-stub and firefox-48.0 for firefox-48.0-stub. Other products
+// are returned as is.
+func fullInstallerProduct(product string) string {
+	if !strings.HasSuffix(strings.ToLower(product), "-stub") {
+		return product
+	}
+	product = product[:len(product)-len("-stub")]
+
+	parts := strings.Split(product, "-")
+	if last := parts[len(parts)-1]; last != "" && unicode.IsDigit(rune(last[0])) {
+		return product
+	}
+	return product + "-latest"
+}
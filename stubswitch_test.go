@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFullInstallerProduct(t *testing.T) {
+	assert.Equal(t, "Firefox-latest", fullInstallerProduct("Firefox-stub"))
+	assert.Equal(t, "firefox-beta-latest", fullInstallerProduct("firefox-beta-stub"))
+	assert.Equal(t, "firefox-48.0", fullInstallerProduct("firefox-48.0-stub"))
+	assert.Equal(t, "firefox-49.0b8", fullInstallerProduct("firefox-49.0b8-stub"))
+	assert.Equal(t, "firefox-latest", fullInstallerProduct("firefox-latest"))
+	assert.Equal(t, "firefox", fullInstallerProduct("firefox"))
+}
+
+func TestStubSwitch(t *testing.T) {
+	var s *StubSwitch
+	assert.True(t, s.Enabled())
+
+	s = &StubSwitch{}
+	assert.True(t, s.Enabled())
+	s.SetEnabled(false)
+	assert.False(t, s.Enabled())
+	s.SetEnabled(true)
+	assert.True(t, s.Enabled())
+}
+
+func TestBouncerHandlerStubSwitch(t *testing.T) {
+	const attribution = "&attribution_code=source%3Dgoogle.com&attribution_sig=anhmacsig"
+	const installer = "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win32/en-US/Firefox%20Setup%2039.0.exe"
+
+	stubSwitch := &StubSwitch{}
+	events := &recordingEventSink{}
+	admin := &AdminHandler{StubSwitch: stubSwitch, Events: events}
+	handler := &BouncerHandler{
+		db:          bouncerHandler.db,
+		StubRootURL: "https://stub/",
+		StubSwitch:  stubSwitch,
+	}
+
+	setStub := func(enabled string) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("POST", "http://test/__admin__/stub?enabled="+enabled, nil)
+		assert.NoError(t, err)
+		admin.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, `{"stub":`+enabled+`}`, w.Body.String())
+	}
+
+	testRequests := []struct {
+		URL          string
+		StubLocation string
+		OffLocation  string
+	}{
+		{"http://test/?product=Firefox-stub&os=win&lang=en-US" + attribution, "https://stub/?attribution_code=source%3Dgoogle.com&attribution_sig=anhmacsig&lang=en-US&os=win&product=firefox-stub", installer},
+		{"http://test/?product=Firefox&os=win&lang=en-US" + attribution, "https://stub/?attribution_code=source%3Dgoogle.com&attribution_sig=anhmacsig&lang=en-US&os=win&product=firefox", installer},
+		// the stub product isn't in the catalog, its full installer is
+		{"http://test/?product=Firefox-stub&os=win&lang=en-US", "", installer},
+	}
+
+	check := func(on bool) {
+		for _, testRequest := range testRequests {
+			expected := testRequest.OffLocation
+			if on {
+				expected = testRequest.StubLocation
+			}
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", testRequest.URL, nil)
+			assert.NoError(t, err, "url: %v", testRequest.URL)
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, expected, w.HeaderMap.Get("Location"), "url: %v stub: %v", testRequest.URL, on)
+		}
+	}
+
+	check(true)
+
+	setStub("false")
+	check(false)
+	if assert.Len(t, events.events, 1) {
+		assert.Equal(t, "stub.disable", events.events[0].Action)
+	}
+
+	setStub("true")
+	check(true)
+	if assert.Len(t, events.events, 2) {
+		assert.Equal(t, "stub.enable", events.events[1].Action)
+	}
+
+	// reading the state isn't audited
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/__admin__/stub", nil)
+	assert.NoError(t, err)
+	admin.ServeHTTP(w, req)
+	assert.Equal(t, `{"stub":true}`, w.Body.String())
+	assert.Len(t, events.events, 2)
+
+	w = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", "http://test/__admin__/stub?enabled=maybe", nil)
+	assert.NoError(t, err)
+	admin.ServeHTTP(w, req)
+	assert.Equal(t, 400, w.Code)
+	assert.True(t, stubSwitch.Enabled())
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// sunsetDate returns the SunsetDates date of a product or its family
+func (b *BouncerHandler) sunsetDate(product string) (time.Time, bool) {
+	product = strings.ToLower(product)
+	if date, ok := b.SunsetDates[product]; ok {
+		return date, true
+	}
+	date, ok := b.SunsetDates[strings.SplitN(product, "-", 2)[0]]
+	return date, ok
+}
+
+// setSunset sets the RFC 8594 Sunset header of responses for products
+// scheduled to be retired, and a Link to their retirement policy if
+// SunsetPolicyURL is set
+func (b *BouncerHandler) setSunset(w http.ResponseWriter, product string) {
+	date, ok := b.sunsetDate(product)
+	if !ok {
+		return
+	}
+	w.Header().Set("Sunset", date.UTC().Format(http.TimeFormat))
+	if b.SunsetPolicyURL != "" {
+		policyURL := strings.Replace(b.SunsetPolicyURL, "{product}", url.QueryEscape(strings.ToLower(product)), -1)
+		w.Header().Add("Link", "<"+policyURL+`>; rel="sunset"`)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBouncerHandlerSunset(t *testing.T) {
+	handler := &BouncerHandler{
+		db: bouncerHandler.db,
+		SunsetDates: map[string]time.Time{
+			"firefox-latest": time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC),
+			"thunderbird":    time.Date(2027, 6, 30, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60)),
+		},
+		SunsetPolicyURL: "https://www.mozilla.org/retired/?product={product}",
+	}
+
+	testRequests := []struct {
+		URL            string
+		ExpectedSunset string
+		ExpectedLink   string
+	}{
+		{"http://test/?product=firefox-latest&os=osx&lang=en-US", "Thu, 31 Dec 2026 00:00:00 GMT", `<https://www.mozilla.org/retired/?product=firefox-latest>; rel="sunset"`},
+		{"http://test/?product=Firefox-Latest&os=osx&lang=en-US", "Thu, 31 Dec 2026 00:00:00 GMT", `<https://www.mozilla.org/retired/?product=firefox-latest>; rel="sunset"`},
+		// products without a sunset date
+		{"http://test/?product=firefox-ssl&os=osx&lang=en-US", "", ""},
+		// the family date applies to every product of the family, in GMT
+		{"http://test/?product=thunderbird-beta-latest&os=osx&lang=en-US", "Wed, 30 Jun 2027 10:00:00 GMT", `<https://www.mozilla.org/retired/?product=thunderbird-beta-latest>; rel="sunset"`},
+		// the product is escaped in the link
+		{"http://test/?product=thunderbird-beta%3E%3B+rel%3D%22x%22&os=osx&lang=en-US", "Wed, 30 Jun 2027 10:00:00 GMT", `<https://www.mozilla.org/retired/?product=thunderbird-beta%3E%3B+rel%3D%22x%22>; rel="sunset"`},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", testRequest.URL, nil)
+		assert.NoError(t, err, "url: %v", testRequest.URL)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, testRequest.ExpectedSunset, w.HeaderMap.Get("Sunset"), "url: %v", testRequest.URL)
+		assert.Equal(t, testRequest.ExpectedLink, w.HeaderMap.Get("Link"), "url: %v", testRequest.URL)
+	}
+
+	// the product is still served until it is retired
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/?product=firefox-latest&os=osx&lang=en-US", nil)
+	assert.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 302, w.Code)
+
+	// without a policy url, only the Sunset header is sent
+	handler.SunsetPolicyURL = ""
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, "Thu, 31 Dec 2026 00:00:00 GMT", w.HeaderMap.Get("Sunset"))
+	assert.Equal(t, "", w.HeaderMap.Get("Link"))
+}
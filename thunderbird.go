@@ -0,0 +1,42 @@
+package main
+
+import "strings"
+
+// DefaultThunderbirdNames maps Thunderbird products requested by their
+// channel alone to their catalog aliases. Firefox aliases are spelled
+// consistently, Thunderbird ones aren't, e.g. thunderbird-beta is
+// thunderbird-beta-latest in the catalog.
+var DefaultThunderbirdNames = map[string]string{
+	"thunderbird":                "thunderbird-latest",
+	"thunderbird-release":        "thunderbird-latest",
+	"thunderbird-release-latest": "thunderbird-latest",
+	"thunderbird-beta":           "thunderbird-beta-latest",
+	"thunderbird-esr":            "thunderbird-esr-latest",
+}
+
+// thunderbirdProduct returns the catalog name of a Thunderbird product, if
+// NormalizeThunderbird is set. An -ssl suffix is kept, so thunderbird-beta-ssl
+// becomes thunderbird-beta-latest-ssl. Other products are returned as is.
+func (b *BouncerHandler) thunderbirdProduct(product string) string {
+	if !b.NormalizeThunderbird {
+		return product
+	}
+
+	name := strings.ToLower(product)
+	if strings.SplitN(name, "-", 2)[0] != "thunderbird" {
+		return product
+	}
+	ssl := ""
+	if strings.HasSuffix(name, "-ssl") {
+		name, ssl = strings.TrimSuffix(name, "-ssl"), "-ssl"
+	}
+
+	names := b.ThunderbirdNames
+	if names == nil {
+		names = DefaultThunderbirdNames
+	}
+	if normalized, ok := names[name]; ok {
+		return normalized + ssl
+	}
+	return product
+}
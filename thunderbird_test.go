@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// thunderbirdCatalog resolves the Thunderbird aliases to the fixture
+// products
+type thunderbirdCatalog struct {
+	Catalog
+}
+
+var thunderbirdAliases = map[string]string{
+	"thunderbird-latest":          "Firefox",
+	"thunderbird-latest-ssl":      "Firefox-SSL",
+	"thunderbird-beta-latest":     "Firefox-43.0.1-SSL",
+	"thunderbird-beta-latest-ssl": "Firefox-43.0.1-SSL",
+	"thunderbird-esr-latest":      "Firefox-SSL",
+	"thunderbird-38.5.0":          "Firefox-43.0.1-SSL",
+	"thunderbird-43.0b1-ssl":      "Firefox-43.0.1-SSL",
+}
+
+func (c *thunderbirdCatalog) AliasFor(product string) (string, error) {
+	if related, ok := thunderbirdAliases[product]; ok {
+		return related, nil
+	}
+	return c.Catalog.AliasFor(product)
+}
+
+func TestThunderbirdProduct(t *testing.T) {
+	handler := &BouncerHandler{NormalizeThunderbird: true}
+
+	assert.Equal(t, "thunderbird-latest", handler.thunderbirdProduct("thunderbird"))
+	assert.Equal(t, "thunderbird-latest", handler.thunderbirdProduct("thunderbird-release"))
+	assert.Equal(t, "thunderbird-latest-ssl", handler.thunderbirdProduct("thunderbird-ssl"))
+	assert.Equal(t, "thunderbird-beta-latest", handler.thunderbirdProduct("thunderbird-beta"))
+	assert.Equal(t, "thunderbird-beta-latest-ssl", handler.thunderbirdProduct("Thunderbird-Beta-SSL"))
+	assert.Equal(t, "thunderbird-esr-latest", handler.thunderbirdProduct("thunderbird-esr"))
+
+	// Catalog names and other products are unchanged
+	assert.Equal(t, "thunderbird-beta-latest", handler.thunderbirdProduct("thunderbird-beta-latest"))
+	assert.Equal(t, "thunderbird-38.5.0-ssl", handler.thunderbirdProduct("thunderbird-38.5.0-ssl"))
+	assert.Equal(t, "firefox-beta", handler.thunderbirdProduct("firefox-beta"))
+	assert.Equal(t, "thunderbirds-beta", handler.thunderbirdProduct("thunderbirds-beta"))
+
+	handler.ThunderbirdNames = map[string]string{"thunderbird-daily": "thunderbird-nightly-latest"}
+	assert.Equal(t, "thunderbird-nightly-latest", handler.thunderbirdProduct("thunderbird-daily"))
+	assert.Equal(t, "thunderbird-beta", handler.thunderbirdProduct("thunderbird-beta"))
+
+	handler.NormalizeThunderbird = false
+	assert.Equal(t, "thunderbird-daily", handler.thunderbirdProduct("thunderbird-daily"))
+}
+
+func TestBouncerHandlerThunderbird(t *testing.T) {
+	const releaseLocation = "http://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win32/en-US/Firefox%20Setup%2039.0.exe"
+	const sslLocation = "https://download-installer.cdn.mozilla.net/pub/firefox/releases/39.0/win32/en-US/Firefox%20Setup%2039.0.exe"
+	const betaLocation = "https://download-installer.cdn.mozilla.net/pub/firefox/releases/43.0.1/win32/en-US/Firefox%20Setup%2043.0.1.exe"
+	const xpUserAgent = "Mozilla/5.0 (Windows NT 5.1; rv:38.0) Gecko/20100101 Thunderbird/38.0"
+
+	handler := &BouncerHandler{
+		db:                   &thunderbirdCatalog{memoizedCatalog()},
+		NormalizeThunderbird: true,
+	}
+
+	testRequests := []struct {
+		Product          string
+		UserAgent        string
+		ExpectedLocation string
+	}{
+		// release
+		{"thunderbird", "", releaseLocation},
+		{"thunderbird-release", "", releaseLocation},
+		{"thunderbird-latest", "", releaseLocation},
+		// ssl
+		{"thunderbird-ssl", "", sslLocation},
+		{"thunderbird-latest-ssl", "", sslLocation},
+		// beta
+		{"thunderbird-beta", "", betaLocation},
+		{"thunderbird-beta-ssl", "", betaLocation},
+		{"thunderbird-beta-latest", "", betaLocation},
+		// esr
+		{"thunderbird-esr", "", sslLocation},
+		{"thunderbird-esr-latest", "", sslLocation},
+		// Windows XP clients get the last sha1 signed build
+		{"thunderbird", xpUserAgent, betaLocation},
+		{"thunderbird-esr", xpUserAgent, betaLocation},
+		{"thunderbird-beta-ssl", xpUserAgent, betaLocation},
+	}
+
+	for _, testRequest := range testRequests {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "http://test/?os=win&lang=en-US&product="+testRequest.Product, nil)
+		assert.NoError(t, err)
+		req.Header.Set("User-Agent", testRequest.UserAgent)
+
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, 302, w.Code, "product: %v ua: %v", testRequest.Product, testRequest.UserAgent)
+		assert.Equal(t, testRequest.ExpectedLocation, w.HeaderMap.Get("Location"), "product: %v ua: %v", testRequest.Product, testRequest.UserAgent)
+	}
+
+	// Without NormalizeThunderbird, channel names aren't catalog names
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://test/?os=win&lang=en-US&product=thunderbird-beta", nil)
+	assert.NoError(t, err)
+	handler.NormalizeThunderbird = false
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// TraceparentHeader is the W3C trace context header tracing proxies and
+// OpenTelemetry instrumented clients propagate the active span in
+const TraceparentHeader = "traceparent"
+
+// traceID returns the trace id of the traceparent header of req, or "" if
+// the request isn't traced. The header is
+// <version>-<trace id>-<parent id>-<flags>, in lowercase hex.
+func traceID(req *http.Request) string {
+	parts := strings.Split(strings.TrimSpace(req.Header.Get(TraceparentHeader)), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return ""
+	}
+	if strings.ToLower(parts[1]) != parts[1] || parts[1] == strings.Repeat("0", 32) {
+		return ""
+	}
+	if _, err := hex.DecodeString(parts[1]); err != nil {
+		return ""
+	}
+	return parts[1]
+}